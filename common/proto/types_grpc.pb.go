@@ -19,10 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Node_Handshake_FullMethodName      = "/Node/Handshake"
-	Node_NewTransaction_FullMethodName = "/Node/NewTransaction"
-	Node_NewBlock_FullMethodName       = "/Node/NewBlock"
-	Node_GetBlocks_FullMethodName      = "/Node/GetBlocks"
+	Node_Handshake_FullMethodName              = "/Node/Handshake"
+	Node_NewTransaction_FullMethodName         = "/Node/NewTransaction"
+	Node_StemTransaction_FullMethodName        = "/Node/StemTransaction"
+	Node_NewBlock_FullMethodName               = "/Node/NewBlock"
+	Node_GetBlocks_FullMethodName              = "/Node/GetBlocks"
+	Node_GetMempool_FullMethodName             = "/Node/GetMempool"
+	Node_GetMempoolTransactions_FullMethodName = "/Node/GetMempoolTransactions"
 )
 
 // NodeClient is the client API for Node service.
@@ -31,8 +34,11 @@ const (
 type NodeClient interface {
 	Handshake(ctx context.Context, in *Version, opts ...grpc.CallOption) (*Version, error)
 	NewTransaction(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*Transaction, error)
+	StemTransaction(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*Transaction, error)
 	NewBlock(ctx context.Context, in *Block, opts ...grpc.CallOption) (*Block, error)
 	GetBlocks(ctx context.Context, in *Version, opts ...grpc.CallOption) (*Blocks, error)
+	GetMempool(ctx context.Context, in *Version, opts ...grpc.CallOption) (*TxHashes, error)
+	GetMempoolTransactions(ctx context.Context, in *TxHashes, opts ...grpc.CallOption) (*Transactions, error)
 }
 
 type nodeClient struct {
@@ -61,6 +67,15 @@ func (c *nodeClient) NewTransaction(ctx context.Context, in *Transaction, opts .
 	return out, nil
 }
 
+func (c *nodeClient) StemTransaction(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*Transaction, error) {
+	out := new(Transaction)
+	err := c.cc.Invoke(ctx, Node_StemTransaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *nodeClient) NewBlock(ctx context.Context, in *Block, opts ...grpc.CallOption) (*Block, error) {
 	out := new(Block)
 	err := c.cc.Invoke(ctx, Node_NewBlock_FullMethodName, in, out, opts...)
@@ -79,14 +94,35 @@ func (c *nodeClient) GetBlocks(ctx context.Context, in *Version, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *nodeClient) GetMempool(ctx context.Context, in *Version, opts ...grpc.CallOption) (*TxHashes, error) {
+	out := new(TxHashes)
+	err := c.cc.Invoke(ctx, Node_GetMempool_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeClient) GetMempoolTransactions(ctx context.Context, in *TxHashes, opts ...grpc.CallOption) (*Transactions, error) {
+	out := new(Transactions)
+	err := c.cc.Invoke(ctx, Node_GetMempoolTransactions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // NodeServer is the server API for Node service.
 // All implementations must embed UnimplementedNodeServer
 // for forward compatibility
 type NodeServer interface {
 	Handshake(context.Context, *Version) (*Version, error)
 	NewTransaction(context.Context, *Transaction) (*Transaction, error)
+	StemTransaction(context.Context, *Transaction) (*Transaction, error)
 	NewBlock(context.Context, *Block) (*Block, error)
 	GetBlocks(context.Context, *Version) (*Blocks, error)
+	GetMempool(context.Context, *Version) (*TxHashes, error)
+	GetMempoolTransactions(context.Context, *TxHashes) (*Transactions, error)
 	mustEmbedUnimplementedNodeServer()
 }
 
@@ -100,12 +136,21 @@ func (UnimplementedNodeServer) Handshake(context.Context, *Version) (*Version, e
 func (UnimplementedNodeServer) NewTransaction(context.Context, *Transaction) (*Transaction, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method NewTransaction not implemented")
 }
+func (UnimplementedNodeServer) StemTransaction(context.Context, *Transaction) (*Transaction, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StemTransaction not implemented")
+}
 func (UnimplementedNodeServer) NewBlock(context.Context, *Block) (*Block, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method NewBlock not implemented")
 }
 func (UnimplementedNodeServer) GetBlocks(context.Context, *Version) (*Blocks, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlocks not implemented")
 }
+func (UnimplementedNodeServer) GetMempool(context.Context, *Version) (*TxHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMempool not implemented")
+}
+func (UnimplementedNodeServer) GetMempoolTransactions(context.Context, *TxHashes) (*Transactions, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMempoolTransactions not implemented")
+}
 func (UnimplementedNodeServer) mustEmbedUnimplementedNodeServer() {}
 
 // UnsafeNodeServer may be embedded to opt out of forward compatibility for this service.
@@ -155,6 +200,24 @@ func _Node_NewTransaction_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Node_StemTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transaction)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).StemTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Node_StemTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).StemTransaction(ctx, req.(*Transaction))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Node_NewBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Block)
 	if err := dec(in); err != nil {
@@ -191,6 +254,42 @@ func _Node_GetBlocks_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Node_GetMempool_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Version)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).GetMempool(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Node_GetMempool_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).GetMempool(ctx, req.(*Version))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Node_GetMempoolTransactions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxHashes)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NodeServer).GetMempoolTransactions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Node_GetMempoolTransactions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NodeServer).GetMempoolTransactions(ctx, req.(*TxHashes))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Node_ServiceDesc is the grpc.ServiceDesc for Node service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -206,6 +305,10 @@ var Node_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "NewTransaction",
 			Handler:    _Node_NewTransaction_Handler,
 		},
+		{
+			MethodName: "StemTransaction",
+			Handler:    _Node_StemTransaction_Handler,
+		},
 		{
 			MethodName: "NewBlock",
 			Handler:    _Node_NewBlock_Handler,
@@ -214,6 +317,14 @@ var Node_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlocks",
 			Handler:    _Node_GetBlocks_Handler,
 		},
+		{
+			MethodName: "GetMempool",
+			Handler:    _Node_GetMempool_Handler,
+		},
+		{
+			MethodName: "GetMempoolTransactions",
+			Handler:    _Node_GetMempoolTransactions_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "common/proto/types.proto",
@@ -1,6 +1,11 @@
 package requests
 
-import "github.com/yuriykis/microblocknet/common/proto"
+import (
+	"fmt"
+	"time"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+)
 
 type InitTransactionRequest struct {
 	FromAddress []byte
@@ -19,6 +24,20 @@ type NewTransactionRequest struct {
 
 type NewTransactionResponse struct {
 	Transaction *proto.Transaction
+	Reject      *TxReject `json:",omitempty"`
+}
+
+// TxReject is sent back to the submitter when the node refuses the transaction
+type TxReject struct {
+	Code string
+	// Class is "consensus" for the invalid transactions
+	// and "policy" for the ones the node does not relay
+	Class  string
+	Reason string
+}
+
+func (r *TxReject) Error() string {
+	return fmt.Sprintf("transaction rejected by %s rules (%s): %s", r.Class, r.Code, r.Reason)
 }
 
 type GetMyUTXOsRequest struct {
@@ -45,6 +64,18 @@ type GetUTXOsByAddressResponse struct {
 	UTXOs []*proto.UTXO
 }
 
+type GetUTXOProofRequest struct {
+	TxHash   []byte
+	OutIndex int
+}
+
+// GetUTXOProofResponse carries the proof of the outpoint and the header
+// of the block whose UTXO root it is checked against
+type GetUTXOProofResponse struct {
+	Proof  *proto.UTXOProof
+	Header *proto.Header
+}
+
 type PeersAddrsRequest struct{}
 
 type PeersAddrsResponse struct {
@@ -58,3 +89,122 @@ type GetCurrentHeightResponse struct {
 type HealthcheckResponse struct {
 	Healthcheck string
 }
+
+// MempoolTransaction is a transaction waiting in the node's mempool
+type MempoolTransaction struct {
+	Hash        []byte
+	Transaction *proto.Transaction
+	Fee         int64
+	Size        int
+	FeeRate     int64 // fee per 1000 bytes
+	Added       time.Time
+	Parents     [][]byte // unconfirmed transactions this one spends from
+}
+
+type GetMempoolResponse struct {
+	Transactions []*MempoolTransaction
+}
+
+type GetMempoolTransactionRequest struct {
+	Hash []byte
+}
+
+type GetMempoolTransactionResponse struct {
+	Transaction *MempoolTransaction
+}
+
+// MempoolFeeRateBucket counts the mempool transactions paying
+// a fee rate from MinFeeRate up to the MinFeeRate of the next bucket
+type MempoolFeeRateBucket struct {
+	MinFeeRate int64
+	Count      int
+	Bytes      int
+}
+
+type GetMempoolStatsResponse struct {
+	Count            int
+	Bytes            int
+	MinFeeRate       int64
+	FeeRateHistogram []MempoolFeeRateBucket
+	Oldest           *MempoolTransaction
+}
+
+type ValidateTransactionRequest struct {
+	Transaction *proto.Transaction
+}
+
+// ValidationStatus is the outcome of a single validation rule
+type ValidationStatus string
+
+const (
+	ValidationPass ValidationStatus = "pass"
+	ValidationFail ValidationStatus = "fail"
+	// ValidationSkip is reported for the rules that could not be checked
+	// because a rule they depend on has failed
+	ValidationSkip ValidationStatus = "skip"
+)
+
+type ValidationRule struct {
+	Rule string
+	// Class is "consensus" for the validity rules and "policy" for the relay rules
+	Class  string
+	Status ValidationStatus
+	Reason string `json:",omitempty"`
+}
+
+// ValidateTransactionResponse reports if the transaction would be accepted by the node,
+// Reject holds the reject code the node would return on submission
+type ValidateTransactionResponse struct {
+	Hash    []byte
+	Valid   bool
+	Fee     int64
+	Size    int
+	FeeRate int64
+	Rules   []ValidationRule
+	Reject  *TxReject `json:",omitempty"`
+}
+
+// GetAddressTransactionsRequest asks for a page of the address history,
+// a zero Limit means the default page size of the node
+type GetAddressTransactionsRequest struct {
+	Address []byte
+	Offset  int
+	Limit   int
+}
+
+// AddressTransaction is a confirmed transaction paying to the address or spending its outputs,
+// Received and Sent are the amounts credited to and debited from the address
+type AddressTransaction struct {
+	Hash     []byte
+	Height   int32
+	Position int32 // index of the transaction in its block
+	Received int64
+	Sent     int64
+}
+
+type GetAddressTransactionsResponse struct {
+	Transactions []*AddressTransaction
+	// NextOffset is the offset of the next page, zero when this is the last one
+	NextOffset int `json:",omitempty"`
+}
+
+const (
+	TxStatusUnknown   = "unknown"
+	TxStatusPending   = "pending"
+	TxStatusConfirmed = "confirmed"
+)
+
+type GetTransactionStatusRequest struct {
+	Hash []byte
+}
+
+// GetTransactionStatusResponse tells if the transaction is waiting in the mempool,
+// confirmed in the main chain or unknown to the node, the block fields are set
+// only for a confirmed transaction
+type GetTransactionStatusResponse struct {
+	Status        string
+	Confirmations int    `json:",omitempty"`
+	BlockHash     []byte `json:",omitempty"`
+	Height        int32  `json:",omitempty"`
+	Position      int32  `json:",omitempty"`
+}
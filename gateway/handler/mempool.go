@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuriykis/microblocknet/common/requests"
+	"github.com/yuriykis/microblocknet/gateway/service"
+	"go.uber.org/zap"
+)
+
+type MempoolHandler interface {
+	Mempool(c *gin.Context)
+	Transaction(c *gin.Context)
+	Stats(c *gin.Context)
+}
+
+type mempoolHandler struct {
+	service service.Service
+	logger  *zap.SugaredLogger
+}
+
+func NewMempoolHandler(logger *zap.SugaredLogger, service service.Service) MempoolHandler {
+	return &mempoolHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (h *mempoolHandler) Mempool(c *gin.Context) {
+	txs, err := h.service.MempoolTransactions(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, requests.GetMempoolResponse{
+		Transactions: txs,
+	})
+}
+
+// Transaction looks up the mempool transaction by its hex encoded hash
+func (h *mempoolHandler) Transaction(c *gin.Context) {
+	hash, err := hex.DecodeString(c.Param("hash"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "hash must be hex encoded",
+		})
+		return
+	}
+	tx, err := h.service.MempoolTransaction(c.Request.Context(), hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if tx == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "transaction not found in mempool",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, requests.GetMempoolTransactionResponse{
+		Transaction: tx,
+	})
+}
+
+func (h *mempoolHandler) Stats(c *gin.Context) {
+	stats, err := h.service.MempoolStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
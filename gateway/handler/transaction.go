@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"encoding/hex"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +16,8 @@ import (
 type TxHandler interface {
 	InitTransaction(c *gin.Context)
 	NewTransaction(c *gin.Context)
+	ValidateTransaction(c *gin.Context)
+	TransactionStatus(c *gin.Context)
 }
 
 type txHandler struct {
@@ -66,6 +70,13 @@ func (h *txHandler) NewTransaction(c *gin.Context) {
 			})
 		}
 		t, err := h.service.NewTransaction(c.Request.Context(), tReq.Transaction)
+		var reject *requests.TxReject
+		if errors.As(err, &reject) {
+			c.JSON(http.StatusBadRequest, requests.NewTransactionResponse{
+				Reject: reject,
+			})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -81,3 +92,48 @@ func (h *txHandler) NewTransaction(c *gin.Context) {
 		})
 	}
 }
+
+// ValidateTransaction reports if the transaction would be accepted
+// without submitting it to the network
+func (h *txHandler) ValidateTransaction(c *gin.Context) {
+	var vReq requests.ValidateTransactionRequest
+	if err := c.ShouldBindJSON(&vReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if vReq.Transaction == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "transaction is required",
+		})
+		return
+	}
+	res, err := h.service.ValidateTransaction(c.Request.Context(), vReq.Transaction)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// TransactionStatus reports if the transaction is pending, confirmed or unknown to the node
+func (h *txHandler) TransactionStatus(c *gin.Context) {
+	hash, err := hex.DecodeString(c.Param("hash"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "hash must be hex encoded",
+		})
+		return
+	}
+	res, err := h.service.TransactionStatus(c.Request.Context(), hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -16,6 +16,7 @@ type server struct {
 	utxoh  handler.UtxoHandler
 	txh    handler.TxHandler
 	hh     handler.HealthHandler
+	mh     handler.MempoolHandler
 }
 
 func newServer(logger *zap.SugaredLogger, service service.Service) *server {
@@ -26,6 +27,7 @@ func newServer(logger *zap.SugaredLogger, service service.Service) *server {
 		utxoh:  handler.NewUtxoHandler(logger, service),
 		txh:    handler.NewTxHandler(logger, service),
 		hh:     handler.NewHealthHandler(logger, service),
+		mh:     handler.NewMempoolHandler(logger, service),
 	}
 	s.configureRouter()
 	return s
@@ -38,6 +40,11 @@ func (s *server) configureRouter() {
 	s.router.GET("/utxo", s.utxoh.UTXO)
 	s.router.POST("/transaction/init", s.txh.InitTransaction)
 	s.router.POST("/transaction", s.txh.NewTransaction)
+	s.router.POST("/transaction/validate", s.txh.ValidateTransaction)
+	s.router.GET("/transaction/status/:hash", s.txh.TransactionStatus)
+	s.router.GET("/mempool", s.mh.Mempool)
+	s.router.GET("/mempool/stats", s.mh.Stats)
+	s.router.GET("/mempool/transaction/:hash", s.mh.Transaction)
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
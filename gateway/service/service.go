@@ -15,6 +15,11 @@ type Service interface {
 	UTXOsByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error)
 	InitTransaction(ctx context.Context, t *types.Transaction) (*proto.Transaction, error)
 	NewTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
+	ValidateTransaction(ctx context.Context, t *proto.Transaction) (*requests.ValidateTransactionResponse, error)
+	MempoolTransactions(ctx context.Context) ([]*requests.MempoolTransaction, error)
+	MempoolTransaction(ctx context.Context, hash []byte) (*requests.MempoolTransaction, error)
+	MempoolStats(ctx context.Context) (*requests.GetMempoolStatsResponse, error)
+	TransactionStatus(ctx context.Context, hash []byte) (*requests.GetTransactionStatusResponse, error)
 }
 
 type service struct {
@@ -108,5 +113,81 @@ func (s *service) NewTransaction(
 		s.logger.Errorf("failed to send transaction: %v", err)
 		return nil, err
 	}
+	if res.Reject != nil {
+		return nil, res.Reject
+	}
+	return res.Transaction, nil
+}
+
+func (s *service) ValidateTransaction(
+	ctx context.Context,
+	t *proto.Transaction,
+) (*requests.ValidateTransactionResponse, error) {
+	n, err := s.n.Node()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.ValidateTransaction(ctx, requests.ValidateTransactionRequest{
+		Transaction: t,
+	})
+	if err != nil {
+		s.logger.Errorf("failed to validate transaction: %v", err)
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *service) MempoolTransactions(ctx context.Context) ([]*requests.MempoolTransaction, error) {
+	n, err := s.n.Node()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Mempool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Transactions, nil
+}
+
+// MempoolTransaction returns nil if the node does not have the transaction in its mempool
+func (s *service) MempoolTransaction(
+	ctx context.Context,
+	hash []byte,
+) (*requests.MempoolTransaction, error) {
+	n, err := s.n.Node()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.MempoolTransaction(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
 	return res.Transaction, nil
 }
+
+func (s *service) MempoolStats(ctx context.Context) (*requests.GetMempoolStatsResponse, error) {
+	n, err := s.n.Node()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.MempoolStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s *service) TransactionStatus(
+	ctx context.Context,
+	hash []byte,
+) (*requests.GetTransactionStatusResponse, error) {
+	n, err := s.n.Node()
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.TransactionStatus(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
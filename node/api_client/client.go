@@ -11,7 +11,20 @@ type Client interface {
 	Healthcheck(ctx context.Context) (requests.HealthcheckResponse, error)
 	GetBlockByHeight(ctx context.Context, height int) (requests.GetBlockByHeightResponse, error)
 	GetUTXOsByAddress(ctx context.Context, address []byte) (*requests.GetUTXOsByAddressResponse, error)
+	GetUTXOProof(ctx context.Context, txHash []byte, outIndex int) (requests.GetUTXOProofResponse, error)
 	PeersAddrs(ctx context.Context) []string
 	NewTransaction(ctx context.Context, tReq requests.NewTransactionRequest) (requests.NewTransactionResponse, error)
+	ValidateTransaction(
+		ctx context.Context,
+		vReq requests.ValidateTransactionRequest,
+	) (requests.ValidateTransactionResponse, error)
 	Height(ctx context.Context) (requests.GetCurrentHeightResponse, error)
+	Mempool(ctx context.Context) (requests.GetMempoolResponse, error)
+	MempoolTransaction(ctx context.Context, hash []byte) (requests.GetMempoolTransactionResponse, error)
+	MempoolStats(ctx context.Context) (requests.GetMempoolStatsResponse, error)
+	AddressTransactions(
+		ctx context.Context,
+		aReq requests.GetAddressTransactionsRequest,
+	) (requests.GetAddressTransactionsResponse, error)
+	TransactionStatus(ctx context.Context, hash []byte) (requests.GetTransactionStatusResponse, error)
 }
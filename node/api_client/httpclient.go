@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/yuriykis/microblocknet/common/requests"
@@ -78,6 +79,38 @@ func (c *HTTPClient) GetUTXOsByAddress(
 	return &cResp, nil
 }
 
+// GetUTXOProof returns the proof of the outpoint with the header it is made against,
+// secure.VerifyUTXOProof checks it against the UTXO root of the header
+func (c *HTTPClient) GetUTXOProof(
+	ctx context.Context,
+	txHash []byte,
+	outIndex int,
+) (requests.GetUTXOProofResponse, error) {
+	res := requests.GetUTXOProofResponse{}
+	b, err := json.Marshal(&requests.GetUTXOProofRequest{TxHash: txHash, OutIndex: outIndex})
+	if err != nil {
+		return res, err
+	}
+	endpoint := c.Endpoint + "/utxo/proof"
+	req, err := http.NewRequest("GET", endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("failed to get utxo proof, status code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
 func (c *HTTPClient) PeersAddrs(ctx context.Context) []string {
 	// TODO: implement
 	return nil
@@ -110,6 +143,35 @@ func (c *HTTPClient) NewTransaction(
 	return cResp, nil
 }
 
+func (c *HTTPClient) ValidateTransaction(
+	ctx context.Context,
+	vReq requests.ValidateTransactionRequest,
+) (requests.ValidateTransactionResponse, error) {
+	res := requests.ValidateTransactionResponse{}
+	b, err := json.Marshal(&vReq)
+	if err != nil {
+		return res, err
+	}
+	endpoint := c.Endpoint + "/transaction/validate"
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("failed to validate transaction, status code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
 func (c *HTTPClient) Height(ctx context.Context) (requests.GetCurrentHeightResponse, error) {
 	res := requests.GetCurrentHeightResponse{}
 	endpoint := c.Endpoint + "/height"
@@ -147,3 +209,130 @@ func (c *HTTPClient) Healthcheck(ctx context.Context) (requests.HealthcheckRespo
 	}
 	return res, nil
 }
+
+func (c *HTTPClient) Mempool(ctx context.Context) (requests.GetMempoolResponse, error) {
+	res := requests.GetMempoolResponse{}
+	endpoint := c.Endpoint + "/mempool"
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// MempoolTransaction returns the response with nil Transaction
+// when the node does not have the transaction in its mempool
+func (c *HTTPClient) MempoolTransaction(
+	ctx context.Context,
+	hash []byte,
+) (requests.GetMempoolTransactionResponse, error) {
+	res := requests.GetMempoolTransactionResponse{}
+	b, err := json.Marshal(&requests.GetMempoolTransactionRequest{Hash: hash})
+	if err != nil {
+		return res, err
+	}
+	endpoint := c.Endpoint + "/mempool/transaction"
+	req, err := http.NewRequest("GET", endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return res, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func (c *HTTPClient) MempoolStats(ctx context.Context) (requests.GetMempoolStatsResponse, error) {
+	res := requests.GetMempoolStatsResponse{}
+	endpoint := c.Endpoint + "/mempool/stats"
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func (c *HTTPClient) AddressTransactions(
+	ctx context.Context,
+	aReq requests.GetAddressTransactionsRequest,
+) (requests.GetAddressTransactionsResponse, error) {
+	res := requests.GetAddressTransactionsResponse{}
+	b, err := json.Marshal(&aReq)
+	if err != nil {
+		return res, err
+	}
+	endpoint := c.Endpoint + "/address/transactions"
+	req, err := http.NewRequest("GET", endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("failed to get address transactions, status code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func (c *HTTPClient) TransactionStatus(
+	ctx context.Context,
+	hash []byte,
+) (requests.GetTransactionStatusResponse, error) {
+	res := requests.GetTransactionStatusResponse{}
+	b, err := json.Marshal(&requests.GetTransactionStatusRequest{Hash: hash})
+	if err != nil {
+		return res, err
+	}
+	endpoint := c.Endpoint + "/transaction/status"
+	req, err := http.NewRequest("GET", endpoint, bytes.NewBuffer(b))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("failed to get transaction status, status code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
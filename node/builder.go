@@ -5,6 +5,7 @@ import (
 	"github.com/yuriykis/microblocknet/node/middleware"
 	"github.com/yuriykis/microblocknet/node/server"
 	"github.com/yuriykis/microblocknet/node/service"
+	"github.com/yuriykis/microblocknet/node/store"
 )
 
 type NodeBuilder struct {
@@ -30,7 +31,9 @@ func NewNodeBuilder(
 			ApiListenAddr:        apiListenAddr,
 			GatewayAddress:       gatewayAddr,
 			ConsulServiceAddress: consulServiceAddr,
-			StoreType:            storeType,
+			StoreConfig:          store.Config{Type: storeType},
+			MempoolConfig:        service.DefaultMempoolConfig(),
+			DandelionConfig:      service.DefaultDandelionConfig(),
 		},
 		bootOpts: boot.BootOpts{
 			BootstrapNodes: bootstrapNodes,
@@ -39,12 +42,36 @@ func NewNodeBuilder(
 	}
 }
 
+func (b *NodeBuilder) WithMempoolConfig(conf service.MempoolConfig) *NodeBuilder {
+	b.serverConfig.MempoolConfig = conf
+	return b
+}
+
+func (b *NodeBuilder) WithStoreConfig(conf store.Config) *NodeBuilder {
+	b.serverConfig.StoreConfig = conf
+	return b
+}
+
+func (b *NodeBuilder) WithDandelionConfig(conf service.DandelionConfig) *NodeBuilder {
+	b.serverConfig.DandelionConfig = conf
+	return b
+}
+
+func (b *NodeBuilder) WithPruneDepth(depth int) *NodeBuilder {
+	b.serverConfig.PruneDepth = depth
+	return b
+}
+
+func (b *NodeBuilder) WithUTXORootHeight(height int) *NodeBuilder {
+	b.serverConfig.UTXORootHeight = height
+	return b
+}
+
 func (b *NodeBuilder) Build() error {
 	var err error
 	n := service.New(b.serverConfig)
 	b.node = n
 	b.apiServer, err = server.NewApiServer(
-		b.serverConfig.NodeListenAddress,
 		b.serverConfig.ApiListenAddr,
 		n,
 	)
@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"context"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+// addressEntries sums what a confirmed transaction pays to
+// and spends from every address it involves
+type addressEntries struct {
+	txHash   []byte
+	height   int32
+	position int32
+	entries  map[string]*store.AddressTx
+	order    []string
+}
+
+func newAddressEntries(txHash []byte, height int32, position int) *addressEntries {
+	return &addressEntries{
+		txHash:   txHash,
+		height:   height,
+		position: int32(position),
+		entries:  make(map[string]*store.AddressTx),
+	}
+}
+
+func (a *addressEntries) entry(address []byte) *store.AddressTx {
+	e, ok := a.entries[string(address)]
+	if !ok {
+		e = &store.AddressTx{
+			Address:  address,
+			TxHash:   a.txHash,
+			Height:   a.height,
+			Position: a.position,
+		}
+		a.entries[string(address)] = e
+		a.order = append(a.order, string(address))
+	}
+	return e
+}
+
+// credit records the output paying to its address
+func (a *addressEntries) credit(output *proto.TxOutput) {
+	a.entry(output.Address).Received += output.Value
+}
+
+// debit records the spent output of its address
+func (a *addressEntries) debit(output *proto.TxOutput) {
+	a.entry(output.Address).Sent += output.Value
+}
+
+// list returns the entries in the order the addresses appeared in the transaction
+func (a *addressEntries) list() []*store.AddressTx {
+	entries := make([]*store.AddressTx, 0, len(a.order))
+	for _, address := range a.order {
+		entries = append(entries, a.entries[address])
+	}
+	return entries
+}
+
+// AddressHistory returns the page of the confirmed transactions
+// that pay to the address or spend its outputs, the most recent first
+func (c *Chain) AddressHistory(address []byte, page store.Page) ([]*store.AddressTx, error) {
+	ctx := context.Background()
+	return c.store.AddressTxStore(ctx).List(ctx, address, page)
+}
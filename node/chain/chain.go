@@ -3,13 +3,18 @@ package chain
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/yuriykis/microblocknet/common/crypto"
 	"github.com/yuriykis/microblocknet/common/proto"
 	"github.com/yuriykis/microblocknet/node/secure"
 	"github.com/yuriykis/microblocknet/node/store"
+	pb "google.golang.org/protobuf/proto"
 )
 
 type HeadersList struct {
@@ -30,6 +35,11 @@ func (l *HeadersList) Add(header *proto.Header) {
 	l.headers = append(l.headers, header)
 }
 
+// RemoveLast drops the header of the tip block
+func (l *HeadersList) RemoveLast() {
+	l.headers = l.headers[:len(l.headers)-1]
+}
+
 func (l *HeadersList) Get(index int) (*proto.Header, error) {
 	if index > l.Height() {
 		return nil, fmt.Errorf("index %d is greater than height %d", index, l.Height())
@@ -39,94 +49,462 @@ func (l *HeadersList) Get(index int) (*proto.Header, error) {
 
 // -----------------------------------------------------------------------------
 
+var (
+	ErrInvalidSignature   = errors.New("transaction is not valid")
+	ErrDuplicateInput     = errors.New("input is spent twice in the transaction")
+	ErrUTXONotFound       = errors.New("utxo not found")
+	ErrUTXOSpent          = errors.New("utxo is already spent")
+	ErrInputNotOwned      = errors.New("input public key does not own the utxo")
+	ErrNegativeOutput     = errors.New("output value is negative")
+	ErrInsufficientInputs = errors.New("inputs do not cover outputs")
+)
+
+// UTXOSource provides outputs that are not part of the confirmed UTXO set yet,
+// e.g. the outputs of the transactions waiting in the mempool
+type UTXOSource interface {
+	UTXO(txHash []byte, outIndex int) *proto.UTXO
+	IsSpent(utxoKey string) bool
+}
+
+// blockView tracks the outputs created and spent by the transactions
+// of a block that is being validated
+type blockView struct {
+	utxos map[string]*proto.UTXO
+	spent map[string]struct{}
+}
+
+func newBlockView() *blockView {
+	return &blockView{
+		utxos: make(map[string]*proto.UTXO),
+		spent: make(map[string]struct{}),
+	}
+}
+
+func (v *blockView) add(tx *proto.Transaction) {
+	txHash := []byte(secure.HashTransaction(tx))
+	for index, output := range tx.Outputs {
+		v.utxos[secure.MakeUTXOKey(txHash, index)] = &proto.UTXO{
+			TxHash:   txHash,
+			OutIndex: int32(index),
+			Output:   output,
+		}
+	}
+	for _, input := range tx.Inputs {
+		v.spent[secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))] = struct{}{}
+	}
+}
+
+func (v *blockView) UTXO(txHash []byte, outIndex int) *proto.UTXO {
+	return v.utxos[secure.MakeUTXOKey(txHash, outIndex)]
+}
+
+func (v *blockView) IsSpent(utxoKey string) bool {
+	_, ok := v.spent[utxoKey]
+	return ok
+}
+
+// -----------------------------------------------------------------------------
+
 const godSeed = "41b84a2eff9a47393471748fbbdff9d20c14badab3d2de59fd8b5e98edd34d1c577c4c3515c6c19e5b9fdfba39528b1be755aae4d6a75fc851d3a17fbf51f1bc"
 
 type Chain struct {
 	store   store.Storer
 	headers *HeadersList
+	// pruneDepth is the number of the recent blocks keeping their bodies, zero keeps all of them
+	pruneDepth int
+
+	utxoTreeLock sync.Mutex
+	// utxoTree commits to the UTXO set of the tip, nil until it is first needed
+	utxoTree *utxoTree
+	// utxoRootHeight is the height of the first block whose header commits to the UTXO set
+	utxoRootHeight int
 }
 
 func New(s store.Storer) *Chain {
 	chain := &Chain{
-		store:   s,
-		headers: NewHeadersList(),
+		store:          s,
+		headers:        NewHeadersList(),
+		utxoRootHeight: storedUTXORootHeight(s),
+	}
+	if !chain.restoreHeaders() {
+		chain.addBlock(genesisBlock())
 	}
-	chain.addBlock(genesisBlock())
 	return chain
 }
 
+// restoreHeaders rebuilds the headers list from the blocks kept by a persistent store,
+// it follows the blocks from the genesis by height, skipping the blocks that do not
+// link to the previous one, and stops at the first gap. It returns false when
+// the store holds no blocks. Only the headers are kept while the blocks are read,
+// a store that cannot be read panics rather than getting a new genesis block.
+func (c *Chain) restoreHeaders() bool {
+	ctx := context.Background()
+	headers := make([]*proto.Header, 0)
+	_, err := c.store.BlockStore(ctx).Iterate(ctx, store.Cursor{}, func(block *proto.Block) error {
+		headers = append(headers, block.Header)
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("error restoring the headers: %s", err))
+	}
+	if len(headers) == 0 {
+		return false
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].Height < headers[j].Height
+	})
+	var prevHash string
+	for _, header := range headers {
+		height := int(header.Height)
+		if height > c.headers.Height()+1 {
+			break
+		}
+		if height <= c.headers.Height() {
+			continue
+		}
+		if height > 0 && !bytes.Equal(header.PrevBlockHash, []byte(prevHash)) {
+			// a stale block at this height, another one may extend the chain
+			continue
+		}
+		c.headers.Add(header)
+		prevHash = secure.HashHeader(header)
+	}
+	return c.headers.Height() >= 0
+}
+
 func (c *Chain) Store() store.Storer {
 	return c.store
 }
 
+// ID returns the chain id, the hex encoded hash of the genesis block
+func (c *Chain) ID() (string, error) {
+	genesis, err := c.GetBlockByHeight(0)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString([]byte(secure.HashBlock(genesis))), nil
+}
+
+// CheckID records the chain id in the store meta the first time, after that
+// it refuses the store whose genesis block is not the one of the recorded chain,
+// e.g. when the stored blocks were lost and the chain started from a new genesis
+func (c *Chain) CheckID() error {
+	ctx := context.Background()
+	id, err := c.ID()
+	if err != nil {
+		return err
+	}
+	meta, err := c.store.MetaStore(ctx).Get(ctx)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		meta = &store.Meta{Version: store.SchemaVersion()}
+	}
+	if meta.ChainID == "" {
+		meta.ChainID = id
+		return c.store.MetaStore(ctx).Put(ctx, meta)
+	}
+	if meta.ChainID != id {
+		return fmt.Errorf("store holds chain %s, the genesis block is of chain %s", meta.ChainID, id)
+	}
+	return nil
+}
+
 func (c *Chain) Height() int {
 	return c.headers.Height()
 }
 
 func (c *Chain) AddBlock(block *proto.Block) error {
-	if err := c.ValidateBlock(block); err != nil {
+	next, err := c.validateBlock(block)
+	if err != nil {
 		return err
 	}
-	return c.addBlock(block)
+	return c.connectBlock(block, next)
 }
 
+// addBlock connects the block without validating it
 func (c *Chain) addBlock(block *proto.Block) error {
-	ctx := context.Background()
-	if err := c.store.BlockStore(ctx).Put(ctx, block); err != nil {
+	return c.connectBlock(block, nil)
+}
+
+// connectBlock commits the block together with its transactions and UTXO changes
+// in one batch, the header is added only when the commit succeeds. next is
+// the UTXO tree after the block if the validation has built it, nil otherwise.
+func (c *Chain) connectBlock(block *proto.Block, next *utxoTree) error {
+	if err := c.commitBlock(block, next); err != nil {
 		return err
 	}
 	c.headers.Add(block.Header)
+	return nil
+}
 
-	for _, tx := range block.Transactions {
-		if err := c.store.TxStore(ctx).Put(ctx, tx); err != nil {
+func (c *Chain) commitBlock(block *proto.Block, next *utxoTree) error {
+	ctx := context.Background()
+	batch := store.NewBatch()
+	batch.PutBlock(block)
+	blockHash := secure.HashBlock(block)
+	for i, tx := range block.Transactions {
+		batch.PutTx(tx)
+		batch.PutTxLocation(&store.TxLocation{
+			TxHash:    []byte(secure.HashTransaction(tx)),
+			BlockHash: []byte(blockHash),
+			Height:    block.Header.Height,
+			Position:  int32(i),
+		})
+		if err := c.makeUTXOs(batch, tx, block.Header.Height, i); err != nil {
 			return err
 		}
-		if err := c.makeUTXOs(tx); err != nil {
-			return err
+	}
+	meta, pruned, err := c.prune(batch, int(block.Header.Height))
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		if tree := c.loadedUTXOTree(); tree != nil {
+			next = tree.apply(block.Transactions)
 		}
 	}
-	return c.store.BlockStore(ctx).Put(ctx, block)
+	if err := c.store.Commit(ctx, batch); err != nil {
+		return err
+	}
+	if next != nil {
+		c.setUTXOTree(next)
+	}
+	if pruned == 0 {
+		return nil
+	}
+	// the blocks pruned again after a crash lost their bodies already
+	meta.PrunedHeight = int32(pruned)
+	return c.store.MetaStore(ctx).Put(ctx, meta)
 }
 
-func (c *Chain) makeUTXOs(tx *proto.Transaction) error {
+// makeUTXOs adds the outputs of the transaction at the position in the block
+// to the UTXO set, moves the outputs it spends to the archive and indexes
+// the addresses involved
+func (c *Chain) makeUTXOs(batch *store.Batch, tx *proto.Transaction, height int32, position int) error {
 	ctx := context.Background()
 	txHash := secure.HashTransaction(tx)
+	addresses := newAddressEntries([]byte(txHash), height, position)
 	for index, output := range tx.Outputs {
-		utxo := &proto.UTXO{
+		batch.PutUTXO(&proto.UTXO{
 			TxHash:   []byte(txHash),
 			OutIndex: int32(index),
 			Output:   output,
 			Spent:    false,
-		}
-		if err := c.store.UTXOStore(ctx).Put(ctx, utxo); err != nil {
-			return err
-		}
+		})
+		addresses.credit(output)
 	}
 	for _, input := range tx.Inputs {
 		utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
-		utxo, err := c.store.UTXOStore(ctx).Get(ctx, utxoKey)
+		utxo := batch.UTXO(utxoKey)
+		if utxo == nil {
+			stored, err := c.store.UTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil {
+				return err
+			}
+			if stored == nil {
+				// the output is archived already when Repair commits the block again
+				archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, utxoKey)
+				if err != nil {
+					return err
+				}
+				if archived == nil || archived.Height != height {
+					return fmt.Errorf("utxo %s: %w", utxoKey, ErrUTXONotFound)
+				}
+				stored = archived.UTXO
+			}
+			utxo = stored
+		}
+		// the stored UTXO must not change before the batch is committed
+		spent := pb.Clone(utxo).(*proto.UTXO)
+		spent.Spent = true
+		batch.DeleteUTXO(utxoKey)
+		batch.PutSpentUTXO(&store.SpentUTXO{
+			UTXO:   spent,
+			Height: height,
+		})
+		addresses.debit(utxo.Output)
+	}
+	for _, entry := range addresses.list() {
+		batch.PutAddressTx(entry)
+	}
+	return nil
+}
+
+// DisconnectTip removes the tip block from the chain, the outputs it created
+// leave the UTXO set, the ones it spent return from the archive and its transactions
+// are removed from the address and location indexes. It returns the disconnected block.
+func (c *Chain) DisconnectTip() (*proto.Block, error) {
+	ctx := context.Background()
+	if c.Height() == 0 {
+		return nil, fmt.Errorf("the genesis block cannot be disconnected")
+	}
+	base, err := c.SnapshotHeight()
+	if err != nil {
+		return nil, err
+	}
+	// the outputs spent by the snapshot block are not known until its history is verified
+	if c.Height() <= base {
+		return nil, fmt.Errorf("the block of the imported snapshot cannot be disconnected")
+	}
+	if err := c.checkNotPruned(c.Height()); err != nil {
+		return nil, err
+	}
+	tip, err := c.GetBlockByHeight(c.Height())
+	if err != nil {
+		return nil, err
+	}
+	batch := store.NewBatch()
+	tree := c.loadedUTXOTree()
+	// undo the transactions in reverse, so the outputs created and spent
+	// within the block end up deleted
+	for i := len(tip.Transactions) - 1; i >= 0; i-- {
+		tx := tip.Transactions[i]
+		txHash := secure.HashTransaction(tx)
+		addresses := newAddressEntries([]byte(txHash), tip.Header.Height, i)
+		for index, output := range tx.Outputs {
+			utxoKey := secure.MakeUTXOKey([]byte(txHash), index)
+			batch.DeleteUTXO(utxoKey)
+			// the output may be spent by a later transaction of the block
+			batch.DeleteSpentUTXO(utxoKey)
+			if tree != nil {
+				tree = tree.delete(utxoKey)
+			}
+			addresses.credit(output)
+		}
+		for _, input := range tx.Inputs {
+			utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+			archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil {
+				return nil, err
+			}
+			if archived == nil {
+				return nil, fmt.Errorf("spent utxo %s: %w", utxoKey, ErrUTXONotFound)
+			}
+			utxo := pb.Clone(archived.UTXO).(*proto.UTXO)
+			utxo.Spent = false
+			batch.PutUTXO(utxo)
+			batch.DeleteSpentUTXO(utxoKey)
+			if tree != nil {
+				tree = tree.put(utxo)
+			}
+			addresses.debit(utxo.Output)
+		}
+		for _, entry := range addresses.list() {
+			batch.DeleteAddressTx(entry)
+		}
+		batch.DeleteTxLocation(txHash)
+	}
+	batch.DeleteBlock(secure.HashBlock(tip))
+	if err := c.store.Commit(ctx, batch); err != nil {
+		return nil, err
+	}
+	c.setUTXOTree(tree)
+	c.headers.RemoveLast()
+	return tip, nil
+}
+
+// Repair finds the tip block that was stored without all its transactions
+// and UTXO changes, e.g. when the node crashed in the middle of a commit
+// to a store without transactions, and commits it again.
+// It returns true if the tip had to be repaired.
+func (c *Chain) Repair() (bool, error) {
+	base, err := c.SnapshotHeight()
+	if err != nil {
+		return false, err
+	}
+	// the snapshot block is stored by the import without the outputs it spends
+	if c.Height() == base {
+		return false, nil
+	}
+	tip, err := c.GetBlockByHeight(c.Height())
+	if err != nil {
+		return false, err
+	}
+	applied, err := c.isApplied(tip)
+	if err != nil || applied {
+		return false, err
+	}
+	if err := c.commitBlock(tip, nil); err != nil {
+		return false, fmt.Errorf("failed to repair block at height %d: %w", c.Height(), err)
+	}
+	return true, nil
+}
+
+// isApplied checks that the transactions of the block are stored and located,
+// their outputs are in the UTXO set or spent within the block and their inputs
+// are moved to the archive
+func (c *Chain) isApplied(block *proto.Block) (bool, error) {
+	ctx := context.Background()
+	for _, tx := range block.Transactions {
+		txHash := secure.HashTransaction(tx)
+		if _, err := c.store.TxStore(ctx).Get(ctx, txHash); err != nil {
+			return false, nil
+		}
+		loc, err := c.store.TxLocationStore(ctx).Get(ctx, txHash)
 		if err != nil {
-			return err
+			return false, err
 		}
-		utxo.Spent = true
-		if err := c.store.UTXOStore(ctx).Put(ctx, utxo); err != nil {
-			return err
+		if loc == nil {
+			return false, nil
+		}
+		for index := range tx.Outputs {
+			utxoKey := secure.MakeUTXOKey([]byte(txHash), index)
+			utxo, err := c.store.UTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil {
+				return false, err
+			}
+			if utxo != nil {
+				continue
+			}
+			archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil {
+				return false, err
+			}
+			if archived == nil {
+				return false, nil
+			}
+		}
+		for _, input := range tx.Inputs {
+			utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+			utxo, err := c.store.UTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil {
+				return false, err
+			}
+			if utxo != nil {
+				return false, nil
+			}
+			archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil {
+				return false, err
+			}
+			if archived == nil {
+				return false, nil
+			}
 		}
 	}
-	return nil
+	return true, nil
 }
 
 func (c *Chain) ValidateBlock(b *proto.Block) error {
+	_, err := c.validateBlock(b)
+	return err
+}
+
+// validateBlock returns the UTXO tree after the valid block, so connecting
+// the block does not build it again
+func (c *Chain) validateBlock(b *proto.Block) (*utxoTree, error) {
 	if !secure.VerifyBlock(b) {
-		return fmt.Errorf("block is not valid")
+		return nil, fmt.Errorf("block is not valid")
 	}
 
 	currentBlock, err := c.GetBlockByHeight(c.Height())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if b.Header.Height != currentBlock.Header.Height+1 {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"block height %d is not equal to current height %d + 1",
 			b.Header.Height,
 			currentBlock.Header.Height,
@@ -134,48 +512,129 @@ func (c *Chain) ValidateBlock(b *proto.Block) error {
 	}
 	currentBlockHash := secure.HashBlock(currentBlock)
 	if !bytes.Equal(b.Header.PrevBlockHash, []byte(currentBlockHash)) {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"block prev hash %s is not equal to current hash %s",
 			b.Header.PrevBlockHash,
 			currentBlockHash,
 		)
 	}
+	// transactions are validated in order, so a transaction may spend
+	// the outputs created by the ones placed before it in the same block
+	view := newBlockView()
 	for _, tx := range b.Transactions {
-		if err := c.ValidateTransaction(tx); err != nil {
-			return err
+		if _, err := c.CheckTransaction(tx, view); err != nil {
+			return nil, err
 		}
+		view.add(tx)
 	}
-	return nil
+	tree, err := c.loadUTXOTree()
+	if err != nil {
+		return nil, err
+	}
+	next := tree.apply(b.Transactions)
+	if err := checkUTXORoot(b.Header, next, c.utxoRootHeight); err != nil {
+		return nil, err
+	}
+	return next, nil
 }
 
 func (c *Chain) ValidateTransaction(tx *proto.Transaction) error {
+	_, err := c.CheckTransaction(tx, nil)
+	return err
+}
+
+// CheckTransaction validates the transaction against the confirmed UTXO set,
+// resolving the inputs that are not confirmed yet from src, and returns
+// the fee paid by the transaction. src may be nil. The outputs found
+// in the archive only are reported spent.
+func (c *Chain) CheckTransaction(tx *proto.Transaction, src UTXOSource) (int64, error) {
 	ctx := context.Background()
+	return checkTransaction(tx, func(input *proto.TxInput) (*proto.UTXO, bool, error) {
+		utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+		utxo, err := c.store.UTXOStore(ctx).Get(ctx, utxoKey)
+		if err != nil {
+			return nil, false, err
+		}
+		if utxo == nil && src != nil {
+			utxo = src.UTXO(input.PrevTxHash, int(input.OutIndex))
+		}
+		if utxo == nil {
+			archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, utxoKey)
+			if err != nil || archived == nil {
+				return nil, false, err
+			}
+			return archived.UTXO, true, nil
+		}
+		return utxo, src != nil && src.IsSpent(utxoKey), nil
+	})
+}
+
+// utxoLookup resolves the output spent by the input and tells whether it is
+// already spent, it returns a nil UTXO if the output does not exist
+type utxoLookup func(input *proto.TxInput) (*proto.UTXO, bool, error)
+
+func checkTransaction(tx *proto.Transaction, lookup utxoLookup) (int64, error) {
 	if !secure.VerifyTransaction(tx) {
-		return fmt.Errorf("transaction is not valid")
+		return 0, ErrInvalidSignature
 	}
-	inputsSum := int64(0)
+	// every rule is checked for all the inputs before the next one,
+	// so the error tells which rules the transaction has passed
+	seen := make(map[string]struct{}, len(tx.Inputs))
 	for _, input := range tx.Inputs {
 		utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
-		utxo, err := c.store.UTXOStore(ctx).Get(ctx, utxoKey)
+		if _, ok := seen[utxoKey]; ok {
+			return 0, fmt.Errorf("utxo %s: %w", utxoKey, ErrDuplicateInput)
+		}
+		seen[utxoKey] = struct{}{}
+	}
+	utxos := make([]*proto.UTXO, 0, len(tx.Inputs))
+	spent := make([]bool, 0, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		utxo, isSpent, err := lookup(input)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if utxo == nil {
-			return fmt.Errorf("utxo %s not found", utxoKey)
+			utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+			return 0, fmt.Errorf("utxo %s: %w", utxoKey, ErrUTXONotFound)
 		}
-		if utxo.Spent {
-			return fmt.Errorf("utxo %s is already spent", utxoKey)
+		utxos = append(utxos, utxo)
+		spent = append(spent, isSpent)
+	}
+	// the signature proves the input holds the key, the key has to be
+	// the one of the address the spent output was paid to
+	for i, utxo := range utxos {
+		input := tx.Inputs[i]
+		address := crypto.PublicKeyFromBytes(input.PublicKey).Address().Bytes()
+		if !bytes.Equal(address, utxo.Output.Address) {
+			utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+			return 0, fmt.Errorf("utxo %s: %w", utxoKey, ErrInputNotOwned)
+		}
+	}
+	inputsSum := int64(0)
+	for i, utxo := range utxos {
+		if spent[i] {
+			utxoKey := secure.MakeUTXOKey(tx.Inputs[i].PrevTxHash, int(tx.Inputs[i].OutIndex))
+			return 0, fmt.Errorf("utxo %s: %w", utxoKey, ErrUTXOSpent)
 		}
 		inputsSum += utxo.Output.Value
 	}
 	outputsSum := int64(0)
 	for _, output := range tx.Outputs {
+		if output.Value < 0 {
+			return 0, fmt.Errorf("output value %d: %w", output.Value, ErrNegativeOutput)
+		}
 		outputsSum += output.Value
 	}
 	if inputsSum < outputsSum {
-		return fmt.Errorf("inputs sum %d is less than outputs sum %d", inputsSum, outputsSum)
+		return 0, fmt.Errorf(
+			"inputs sum %d is less than outputs sum %d: %w",
+			inputsSum,
+			outputsSum,
+			ErrInsufficientInputs,
+		)
 	}
-	return nil
+	return inputsSum - outputsSum, nil
 }
 
 func (c *Chain) GetBlockByHeight(height int) (*proto.Block, error) {
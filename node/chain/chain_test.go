@@ -2,6 +2,7 @@ package chain
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -88,9 +89,372 @@ func TestChainAddBlockWithTxs(t *testing.T) {
 		block.Header.PrevBlockHash = []byte(secure.HashBlock(prevBlock))
 		block.Header.Height = int32(i + 1)
 
-		secure.SignBlock(block, myPrivKey)
+		signBlock(t, chain, block, myPrivKey)
 
 		err = chain.AddBlock(block)
 		assert.Nil(t, err)
 	}
 }
+
+func TestValidateTransactionErrors(t *testing.T) {
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	genesis, err := chain.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisTxHash := []byte(secure.HashTransaction(genesis.Transactions[0]))
+
+	makeTx := func(prevTxHash []byte, value int64) *proto.Transaction {
+		tx := &proto.Transaction{
+			Inputs: []*proto.TxInput{
+				{
+					PublicKey:  myPrivKey.PublicKey().Bytes(),
+					PrevTxHash: prevTxHash,
+					OutIndex:   0,
+				},
+			},
+			Outputs: []*proto.TxOutput{
+				{
+					Value:   value,
+					Address: myPrivKey.PublicKey().Address().Bytes(),
+				},
+			},
+		}
+		tx.Inputs[0].Signature = secure.SignTransaction(tx, myPrivKey).Bytes()
+		return tx
+	}
+
+	fee, err := chain.CheckTransaction(makeTx(genesisTxHash, 99000), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), fee)
+
+	err = chain.ValidateTransaction(makeTx(genesisTxHash, 100001))
+	assert.ErrorIs(t, err, ErrInsufficientInputs)
+
+	err = chain.ValidateTransaction(makeTx(util.RandomHash(), 1))
+	assert.ErrorIs(t, err, ErrUTXONotFound)
+
+	tx := makeTx(genesisTxHash, 1)
+	tx.Inputs[0].Signature = crypto.GeneratePrivateKey().Sign("other").Bytes()
+	assert.ErrorIs(t, chain.ValidateTransaction(tx), ErrInvalidSignature)
+
+	// the signature of a third key is valid, but the key does not own the output
+	thirdKey := crypto.GeneratePrivateKey()
+	tx = makeTx(genesisTxHash, 1)
+	tx.Inputs[0].PublicKey = thirdKey.PublicKey().Bytes()
+	tx.Inputs[0].Signature = secure.SignTransaction(tx, thirdKey).Bytes()
+	assert.ErrorIs(t, chain.ValidateTransaction(tx), ErrInputNotOwned)
+}
+
+func TestValidateBlockSpendsWithinBlock(t *testing.T) {
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	genesis, err := chain.GetBlockByHeight(0)
+	assert.Nil(t, err)
+
+	spend := func(prevTx *proto.Transaction, value int64) *proto.Transaction {
+		tx := &proto.Transaction{
+			Inputs: []*proto.TxInput{
+				{
+					PublicKey:  myPrivKey.PublicKey().Bytes(),
+					PrevTxHash: []byte(secure.HashTransaction(prevTx)),
+					OutIndex:   0,
+				},
+			},
+			Outputs: []*proto.TxOutput{
+				{
+					Value:   value,
+					Address: myPrivKey.PublicKey().Address().Bytes(),
+				},
+			},
+		}
+		tx.Inputs[0].Signature = secure.SignTransaction(tx, myPrivKey).Bytes()
+		return tx
+	}
+	makeBlock := func(txs ...*proto.Transaction) *proto.Block {
+		block := util.RandomBlock()
+		block.Transactions = txs
+		block.Header.PrevBlockHash = []byte(secure.HashBlock(genesis))
+		block.Header.Height = 1
+		signBlock(t, chain, block, myPrivKey)
+		return block
+	}
+
+	parent := spend(genesis.Transactions[0], 99000)
+	child := spend(parent, 98000)
+
+	// spending the same output twice in one block is not allowed
+	doubleSpend := makeBlock(parent, spend(genesis.Transactions[0], 50000))
+	assert.ErrorIs(t, chain.ValidateBlock(doubleSpend), ErrUTXOSpent)
+
+	// the child is placed before its parent
+	assert.ErrorIs(t, chain.ValidateBlock(makeBlock(child, parent)), ErrUTXONotFound)
+
+	assert.Nil(t, chain.AddBlock(makeBlock(parent, child)))
+	assert.Equal(t, 1, chain.Height())
+}
+
+func TestChainRestoresFromBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.db")
+	s, err := store.NewChainBoltStore(path)
+	assert.Nil(t, err)
+	chain := New(s)
+	for i := 0; i < 5; i++ {
+		prevBlock, err := chain.GetBlockByHeight(chain.Height())
+		assert.Nil(t, err)
+		block := util.RandomBlock()
+		block.Header.Height = int32(chain.Height() + 1)
+		block.Header.PrevBlockHash = []byte(secure.HashBlock(prevBlock))
+		assert.Nil(t, chain.addBlock(block))
+	}
+	// a block that does not extend the chain is not restored
+	stale := util.RandomBlock()
+	stale.Header.Height = 3
+	assert.Nil(t, s.BlockStore(context.Background()).Put(context.Background(), stale))
+	tip, err := chain.GetBlockByHeight(5)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	s, err = store.NewChainBoltStore(path)
+	assert.Nil(t, err)
+	defer s.Close()
+	restored := New(s)
+	assert.Equal(t, 5, restored.Height())
+	restoredTip, err := restored.GetBlockByHeight(5)
+	assert.Nil(t, err)
+	assert.Equal(t, secure.HashBlock(tip), secure.HashBlock(restoredTip))
+}
+
+func TestChainRepairPartialBlock(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	genesis, err := chain.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	repaired, err := chain.Repair()
+	assert.Nil(t, err)
+	assert.False(t, repaired)
+
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{
+			{
+				PublicKey:  myPrivKey.PublicKey().Bytes(),
+				PrevTxHash: []byte(secure.HashTransaction(genesis.Transactions[0])),
+				OutIndex:   0,
+			},
+		},
+		Outputs: []*proto.TxOutput{
+			{
+				Value:   1000,
+				Address: myPrivKey.PublicKey().Address().Bytes(),
+			},
+		},
+	}
+	tx.Inputs[0].Signature = secure.SignTransaction(tx, myPrivKey).Bytes()
+	block := util.RandomBlock()
+	block.Transactions = []*proto.Transaction{tx}
+	block.Header.PrevBlockHash = []byte(secure.HashBlock(genesis))
+	block.Header.Height = 1
+	signBlock(t, chain, block, myPrivKey)
+	assert.Nil(t, chain.ValidateBlock(block))
+
+	// the node crashed right after the block was written
+	assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+
+	restarted := New(s)
+	assert.Equal(t, 1, restarted.Height())
+	repaired, err = restarted.Repair()
+	assert.Nil(t, err)
+	assert.True(t, repaired)
+
+	spentKey := secure.MakeUTXOKey(tx.Inputs[0].PrevTxHash, 0)
+	spent, err := s.UTXOStore(ctx).Get(ctx, spentKey)
+	assert.Nil(t, err)
+	assert.Nil(t, spent)
+	archived, err := s.SpentUTXOStore(ctx).Get(ctx, spentKey)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), archived.Height)
+	assert.True(t, archived.UTXO.Spent)
+	created, err := s.UTXOStore(ctx).Get(ctx, secure.MakeUTXOKey([]byte(secure.HashTransaction(tx)), 0))
+	assert.Nil(t, err)
+	assert.NotNil(t, created)
+
+	repaired, err = restarted.Repair()
+	assert.Nil(t, err)
+	assert.False(t, repaired)
+}
+
+func TestChainAddressHistory(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	myAddress := myPrivKey.PublicKey().Address().Bytes()
+	toAddress := crypto.GeneratePrivateKey().PublicKey().Address().Bytes()
+	genesis, err := chain.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisTx := genesis.Transactions[0]
+
+	history, err := chain.AddressHistory(myAddress, store.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, genesisTx.Outputs[0].Value, history[0].Received)
+
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{
+			{
+				PublicKey:  myPrivKey.PublicKey().Bytes(),
+				PrevTxHash: []byte(secure.HashTransaction(genesisTx)),
+				OutIndex:   0,
+			},
+		},
+		Outputs: []*proto.TxOutput{
+			{
+				Value:   100,
+				Address: toAddress,
+			},
+			{
+				Value:   genesisTx.Outputs[0].Value - 100,
+				Address: myAddress,
+			},
+		},
+	}
+	tx.Inputs[0].Signature = secure.SignTransaction(tx, myPrivKey).Bytes()
+	block := util.RandomBlock()
+	block.Transactions = []*proto.Transaction{tx}
+	block.Header.PrevBlockHash = []byte(secure.HashBlock(genesis))
+	block.Header.Height = 1
+	signBlock(t, chain, block, myPrivKey)
+	assert.Nil(t, chain.AddBlock(block))
+
+	history, err = chain.AddressHistory(myAddress, store.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, []byte(secure.HashTransaction(tx)), history[0].TxHash)
+	assert.Equal(t, int32(1), history[0].Height)
+	assert.Equal(t, genesisTx.Outputs[0].Value-100, history[0].Received)
+	assert.Equal(t, genesisTx.Outputs[0].Value, history[0].Sent)
+
+	history, err = chain.AddressHistory(toAddress, store.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, int64(100), history[0].Received)
+	assert.Equal(t, int64(0), history[0].Sent)
+
+	// disconnecting the block undoes its UTXO changes and history
+	disconnected, err := chain.DisconnectTip()
+	assert.Nil(t, err)
+	assert.Equal(t, block, disconnected)
+	assert.Equal(t, 0, chain.Height())
+
+	history, err = chain.AddressHistory(toAddress, store.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(history))
+	history, err = chain.AddressHistory(myAddress, store.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+
+	spentKey := secure.MakeUTXOKey(tx.Inputs[0].PrevTxHash, 0)
+	spent, err := s.UTXOStore(ctx).Get(ctx, spentKey)
+	assert.Nil(t, err)
+	assert.False(t, spent.Spent)
+	archived, err := s.SpentUTXOStore(ctx).Get(ctx, spentKey)
+	assert.Nil(t, err)
+	assert.Nil(t, archived)
+	created, err := s.UTXOStore(ctx).Get(ctx, secure.MakeUTXOKey([]byte(secure.HashTransaction(tx)), 0))
+	assert.Nil(t, err)
+	assert.Nil(t, created)
+	_, err = chain.GetBlockByHash(secure.HashBlock(block))
+	assert.NotNil(t, err)
+
+	// the block can be connected again
+	assert.Nil(t, chain.AddBlock(block))
+	_, err = chain.DisconnectTip()
+	assert.Nil(t, err)
+	_, err = chain.DisconnectTip()
+	assert.NotNil(t, err)
+}
+
+func TestChainTxLocation(t *testing.T) {
+	chain := New(store.NewChainMemoryStore())
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	genesis, err := chain.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	genesisTx := genesis.Transactions[0]
+
+	loc, err := chain.TxLocation(secure.HashTransaction(genesisTx))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(secure.HashBlock(genesis)), loc.BlockHash)
+	assert.Equal(t, 1, chain.Confirmations(loc))
+
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{
+			{
+				PublicKey:  myPrivKey.PublicKey().Bytes(),
+				PrevTxHash: []byte(secure.HashTransaction(genesisTx)),
+				OutIndex:   0,
+			},
+		},
+		Outputs: []*proto.TxOutput{
+			{
+				Value:   genesisTx.Outputs[0].Value,
+				Address: crypto.GeneratePrivateKey().PublicKey().Address().Bytes(),
+			},
+		},
+	}
+	tx.Inputs[0].Signature = secure.SignTransaction(tx, myPrivKey).Bytes()
+	block := util.RandomBlock()
+	block.Transactions = []*proto.Transaction{util.RandomTransaction(), tx}
+	block.Header.PrevBlockHash = []byte(secure.HashBlock(genesis))
+	block.Header.Height = 1
+	secure.SignBlock(block, myPrivKey)
+	assert.Nil(t, chain.addBlock(block))
+
+	next := util.RandomBlock()
+	next.Transactions = nil
+	next.Header.PrevBlockHash = []byte(secure.HashBlock(block))
+	next.Header.Height = 2
+	secure.SignBlock(next, myPrivKey)
+	assert.Nil(t, chain.addBlock(next))
+
+	txHash := secure.HashTransaction(tx)
+	loc, err = chain.TxLocation(txHash)
+	assert.Nil(t, err)
+	assert.Equal(t, &store.TxLocation{
+		TxHash:    []byte(txHash),
+		BlockHash: []byte(secure.HashBlock(block)),
+		Height:    1,
+		Position:  1,
+	}, loc)
+	assert.Equal(t, 2, chain.Confirmations(loc))
+
+	// the transactions of a disconnected block are no longer located
+	_, err = chain.DisconnectTip()
+	assert.Nil(t, err)
+	_, err = chain.DisconnectTip()
+	assert.Nil(t, err)
+	loc, err = chain.TxLocation(txHash)
+	assert.Nil(t, err)
+	assert.Nil(t, loc)
+}
+
+func TestChainCheckID(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	id, err := chain.ID()
+	assert.Nil(t, err)
+
+	assert.Nil(t, chain.CheckID())
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, id, meta.ChainID)
+	assert.Nil(t, chain.CheckID())
+
+	// the store recorded for another chain is refused
+	meta.ChainID = "other"
+	assert.Nil(t, s.MetaStore(ctx).Put(ctx, meta))
+	assert.NotNil(t, chain.CheckID())
+}
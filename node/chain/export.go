@@ -0,0 +1,124 @@
+package chain
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// Export writes the blocks from the genesis to the tip, each of them encoded
+// as a protobuf message prefixed by its varint length. It returns the number
+// of the written blocks.
+func (c *Chain) Export(w io.Writer) (int, error) {
+	base, err := c.SnapshotHeight()
+	if err != nil {
+		return 0, err
+	}
+	if base > 0 {
+		return 0, fmt.Errorf("the history below the imported snapshot at height %d is not verified yet", base)
+	}
+	if err := c.checkNotPruned(1); err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	for h := 0; h <= c.Height(); h++ {
+		block, err := c.GetBlockByHeight(h)
+		if err != nil {
+			return h, err
+		}
+		if _, err := protodelim.MarshalTo(bw, block); err != nil {
+			return h, err
+		}
+	}
+	return c.Height() + 1, bw.Flush()
+}
+
+// Import reads the blocks written by Export into the chain of the store.
+// An empty store starts from the genesis block of the file, otherwise the file
+// must hold the same chain, the blocks the chain has already are skipped.
+// Every other block is validated and connected with AddBlock. A positive
+// utxoRootHeight replaces the stored one as SetUTXORootHeight does. It returns
+// the chain and the number of the connected blocks.
+func Import(s store.Storer, r io.Reader, utxoRootHeight int) (*Chain, int, error) {
+	ctx := context.Background()
+	br := bufio.NewReader(r)
+	genesis, err := readBlock(br)
+	if errors.Is(err, io.EOF) {
+		return nil, 0, fmt.Errorf("the file holds no blocks")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if genesis.Header.Height != 0 {
+		return nil, 0, fmt.Errorf("the file starts at height %d, not with the genesis block", genesis.Header.Height)
+	}
+	added := 0
+	var c *Chain
+	stored, err := s.BlockStore(ctx).Count(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if stored == 0 {
+		if !secure.VerifyBlock(genesis) {
+			return nil, 0, fmt.Errorf("genesis block is not valid")
+		}
+		c = &Chain{
+			store:          s,
+			headers:        NewHeadersList(),
+			utxoRootHeight: storedUTXORootHeight(s),
+		}
+		if err := c.addBlock(genesis); err != nil {
+			return nil, 0, err
+		}
+		added++
+	} else {
+		c = New(s)
+	}
+	if utxoRootHeight != 0 {
+		if err := c.SetUTXORootHeight(utxoRootHeight); err != nil {
+			return nil, 0, err
+		}
+	}
+	for block := genesis; ; {
+		height := int(block.Header.Height)
+		if height <= c.Height() {
+			header, err := c.headers.Get(height)
+			if err != nil {
+				return c, added, err
+			}
+			if secure.HashBlock(block) != secure.HashHeader(header) {
+				return c, added, fmt.Errorf("block at height %d is not the one of the chain", height)
+			}
+		} else {
+			if err := c.AddBlock(block); err != nil {
+				return c, added, fmt.Errorf("block at height %d: %w", height, err)
+			}
+			added++
+		}
+		block, err = readBlock(br)
+		if errors.Is(err, io.EOF) {
+			return c, added, nil
+		}
+		if err != nil {
+			return c, added, err
+		}
+	}
+}
+
+func readBlock(r *bufio.Reader) (*proto.Block, error) {
+	block := &proto.Block{}
+	if err := protodelim.UnmarshalFrom(r, block); err != nil {
+		return nil, err
+	}
+	if block.Header == nil {
+		return nil, fmt.Errorf("block without a header")
+	}
+	return block, nil
+}
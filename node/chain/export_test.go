@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"google.golang.org/protobuf/encoding/protodelim"
+	pb "google.golang.org/protobuf/proto"
+)
+
+func TestChainExportImport(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	source := New(store.NewChainMemoryStore())
+	for i := 0; i < 3; i++ {
+		addSpendingBlock(t, source, myPrivKey)
+	}
+	var buf bytes.Buffer
+	n, err := source.Export(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	s := store.NewChainMemoryStore()
+	imported, n, err := Import(s, bytes.NewReader(buf.Bytes()), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, source.Height(), imported.Height())
+	for h := 0; h <= source.Height(); h++ {
+		want, err := source.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		got, err := imported.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		assert.Equal(t, secure.HashBlock(want), secure.HashBlock(got))
+	}
+	assert.Equal(t, len(source.Store().UTXOStore(ctx).List(ctx)), len(s.UTXOStore(ctx).List(ctx)))
+
+	// importing the file again connects nothing, a longer file only the new blocks
+	_, n, err = Import(s, bytes.NewReader(buf.Bytes()), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	addSpendingBlock(t, source, myPrivKey)
+	buf.Reset()
+	_, err = source.Export(&buf)
+	assert.Nil(t, err)
+	imported, n, err = Import(s, bytes.NewReader(buf.Bytes()), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, source.Height(), imported.Height())
+
+	// the chain that diverges from the file does not take it
+	other := New(store.NewChainMemoryStore())
+	addSpendingBlock(t, other, myPrivKey)
+	_, _, err = Import(other.Store(), bytes.NewReader(buf.Bytes()), 0)
+	assert.NotNil(t, err)
+}
+
+func TestChainImportValidatesBlocks(t *testing.T) {
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	source := New(store.NewChainMemoryStore())
+	for i := 0; i < 2; i++ {
+		addSpendingBlock(t, source, myPrivKey)
+	}
+	var buf bytes.Buffer
+	for h := 0; h <= source.Height(); h++ {
+		block, err := source.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		if h == 2 {
+			// spend more than the input has, the block is signed again
+			block = pb.Clone(block).(*proto.Block)
+			block.Transactions[0].Outputs[0].Value += 1000000
+			secure.SignBlock(block, myPrivKey)
+		}
+		_, err = protodelim.MarshalTo(&buf, block)
+		assert.Nil(t, err)
+	}
+	imported, n, err := Import(store.NewChainMemoryStore(), &buf, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, imported.Height())
+
+	_, _, err = Import(store.NewChainMemoryStore(), bytes.NewReader([]byte{0x05, 0x01}), 0)
+	assert.NotNil(t, err)
+	_, _, err = Import(store.NewChainMemoryStore(), bytes.NewReader(nil), 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,20 @@
+package chain
+
+import (
+	"context"
+
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+// TxLocation returns the block of the main chain that confirms the transaction,
+// nil if the transaction is not confirmed
+func (c *Chain) TxLocation(txHash string) (*store.TxLocation, error) {
+	ctx := context.Background()
+	return c.store.TxLocationStore(ctx).Get(ctx, txHash)
+}
+
+// Confirmations returns the number of blocks on top of the block at the location
+// including the block itself, so a transaction in the tip has one confirmation
+func (c *Chain) Confirmations(loc *store.TxLocation) int {
+	return c.Height() - int(loc.Height) + 1
+}
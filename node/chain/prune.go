@@ -0,0 +1,130 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+// ErrBelowPrunedHeight is returned for the blocks whose bodies are pruned
+var ErrBelowPrunedHeight = errors.New("below the pruned height")
+
+// EnablePruning makes the chain keep the bodies of the depth most recent blocks only,
+// the older blocks are stored with their headers and the outputs they spent leave
+// the archive, since such a deep block is never disconnected. The blocks below
+// the depth are pruned right away, the next ones as the chain grows.
+func (c *Chain) EnablePruning(depth int) error {
+	if depth < 1 {
+		return fmt.Errorf("prune depth %d is not positive", depth)
+	}
+	c.pruneDepth = depth
+	ctx := context.Background()
+	batch := store.NewBatch()
+	meta, pruned, err := c.prune(batch, c.Height())
+	if err != nil || pruned == 0 {
+		return err
+	}
+	if err := c.store.Commit(ctx, batch); err != nil {
+		return err
+	}
+	meta.PrunedHeight = int32(pruned)
+	return c.store.MetaStore(ctx).Put(ctx, meta)
+}
+
+// Pruned reports whether the chain drops the old block bodies or has dropped them
+// in the past, in both cases the node cannot serve the whole history. It stays true
+// once a body is pruned, as the bodies never come back, the blocks above PrunedHeight
+// are still served by BlocksFrom.
+func (c *Chain) Pruned() (bool, error) {
+	height, err := c.PrunedHeight()
+	if err != nil {
+		return false, err
+	}
+	return c.pruneDepth > 0 || height > 0, nil
+}
+
+// PrunedHeight returns the height of the last block stored without its body,
+// zero if there is none, the genesis block is never pruned
+func (c *Chain) PrunedHeight() (int, error) {
+	ctx := context.Background()
+	meta, err := c.store.MetaStore(ctx).Get(ctx)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return int(meta.PrunedHeight), nil
+}
+
+// prune stages the removal of the bodies of the blocks that fall below the depth
+// when the chain reaches the tip height. It returns the meta to record the new
+// pruned height in once the batch is committed, and the height, zero when
+// no block has to be pruned.
+func (c *Chain) prune(batch *store.Batch, tip int) (*store.Meta, int, error) {
+	if c.pruneDepth == 0 {
+		return nil, 0, nil
+	}
+	ctx := context.Background()
+	meta, err := c.store.MetaStore(ctx).Get(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if meta == nil {
+		meta = &store.Meta{Version: store.SchemaVersion()}
+	}
+	to := tip - c.pruneDepth
+	if to <= int(meta.PrunedHeight) {
+		return meta, 0, nil
+	}
+	for h := int(meta.PrunedHeight) + 1; h <= to; h++ {
+		block, err := c.GetBlockByHeight(h)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, tx := range block.Transactions {
+			for _, input := range tx.Inputs {
+				batch.DeleteSpentUTXO(secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex)))
+			}
+		}
+		// the hash of the block is the one of its header, so the header
+		// replaces the stored block and the chain restores it as before
+		batch.PutBlock(&proto.Block{
+			Header:    block.Header,
+			PublicKey: block.PublicKey,
+			Signature: block.Signature,
+		})
+	}
+	return meta, to, nil
+}
+
+// checkNotPruned fails when the body of the block at the height is pruned
+func (c *Chain) checkNotPruned(height int) error {
+	pruned, err := c.PrunedHeight()
+	if err != nil {
+		return err
+	}
+	if height <= pruned {
+		return fmt.Errorf("block at height %d is %w %d", height, ErrBelowPrunedHeight, pruned)
+	}
+	return nil
+}
+
+// BlocksFrom returns the blocks from the height up to the tip, it fails
+// with ErrBelowPrunedHeight when the range reaches a pruned body
+func (c *Chain) BlocksFrom(height int) ([]*proto.Block, error) {
+	// the genesis block is never pruned
+	if err := c.checkNotPruned(max(height, 1)); err != nil {
+		return nil, err
+	}
+	blocks := make([]*proto.Block, 0)
+	for h := height; h <= c.Height(); h++ {
+		block, err := c.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
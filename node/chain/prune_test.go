@@ -0,0 +1,115 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+func TestChainPruning(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	full := New(store.NewChainMemoryStore())
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	assert.NotNil(t, chain.EnablePruning(0))
+	assert.Nil(t, chain.EnablePruning(2))
+	pruned, err := chain.Pruned()
+	assert.Nil(t, err)
+	assert.True(t, pruned)
+
+	for i := 0; i < 5; i++ {
+		block := addSpendingBlock(t, full, myPrivKey)
+		assert.Nil(t, chain.AddBlock(block))
+	}
+	height, err := chain.PrunedHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, height)
+
+	// the headers stay, the bodies of the two recent blocks only
+	for h := 0; h <= chain.Height(); h++ {
+		block, err := chain.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		want, err := full.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		assert.Equal(t, secure.HashBlock(want), secure.HashBlock(block))
+		if h == 0 || h > 3 {
+			assert.Equal(t, len(want.Transactions), len(block.Transactions))
+		} else {
+			assert.Equal(t, 0, len(block.Transactions))
+		}
+	}
+	assert.Equal(t, len(full.Store().UTXOStore(ctx).List(ctx)), len(s.UTXOStore(ctx).List(ctx)))
+
+	// the blocks above the pruned height are still served
+	blocks, err := chain.BlocksFrom(4)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(blocks))
+	assert.Equal(t, int32(4), blocks[0].Header.Height)
+	for _, from := range []int{0, 3} {
+		_, err = chain.BlocksFrom(from)
+		assert.True(t, errors.Is(err, ErrBelowPrunedHeight))
+	}
+
+	// the undo data of the pruned blocks is dropped, the one near the tip kept
+	block, err := full.GetBlockByHeight(3)
+	assert.Nil(t, err)
+	input := block.Transactions[0].Inputs[0]
+	archived, err := s.SpentUTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex)))
+	assert.Nil(t, err)
+	assert.Nil(t, archived)
+	block, err = full.GetBlockByHeight(4)
+	assert.Nil(t, err)
+	input = block.Transactions[0].Inputs[0]
+	archived, err = s.SpentUTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex)))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), archived.Height)
+
+	restarted := New(s)
+	assert.Equal(t, 5, restarted.Height())
+	_, err = restarted.Export(&bytes.Buffer{})
+	assert.NotNil(t, err)
+	_, err = restarted.Verify()
+	assert.NotNil(t, err)
+	_, err = restarted.Snapshot(2)
+	assert.NotNil(t, err)
+	_, err = restarted.Snapshot(3)
+	assert.Nil(t, err)
+
+	// the recent blocks can be disconnected, the pruned ones cannot
+	_, err = restarted.DisconnectTip()
+	assert.Nil(t, err)
+	_, err = restarted.DisconnectTip()
+	assert.Nil(t, err)
+	_, err = restarted.DisconnectTip()
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, restarted.Height())
+}
+
+func TestChainEnablePruningPrunesStoredBlocks(t *testing.T) {
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	chain := New(store.NewChainMemoryStore())
+	for i := 0; i < 4; i++ {
+		addSpendingBlock(t, chain, myPrivKey)
+	}
+	pruned, err := chain.Pruned()
+	assert.Nil(t, err)
+	assert.False(t, pruned)
+	blocks, err := chain.BlocksFrom(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(blocks))
+
+	assert.Nil(t, chain.EnablePruning(3))
+	height, err := chain.PrunedHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, height)
+	block, err := chain.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(block.Transactions))
+}
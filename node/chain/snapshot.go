@@ -0,0 +1,370 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	pb "google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrSnapshotHash = errors.New("snapshot content hash does not match")
+	// ErrSnapshotMismatch is returned when the verified history
+	// does not produce the UTXO set of the imported snapshot
+	ErrSnapshotMismatch = errors.New("history does not match the snapshot utxo set")
+)
+
+// Snapshot exports the UTXO set at the height, it takes the current set
+// and undoes the blocks above the height, the outputs they spent
+// come back from the archive
+func (c *Chain) Snapshot(height int) (*proto.UTXOSnapshot, error) {
+	ctx := context.Background()
+	if height < 1 || height > c.Height() {
+		return nil, fmt.Errorf("snapshot height %d is not between 1 and chain height %d", height, c.Height())
+	}
+	base, err := c.SnapshotHeight()
+	if err != nil {
+		return nil, err
+	}
+	if height < base {
+		return nil, fmt.Errorf("the history below the imported snapshot at height %d is not verified yet", base)
+	}
+	// the blocks above the height are undone, so their bodies are needed
+	if err := c.checkNotPruned(height + 1); err != nil {
+		return nil, err
+	}
+	utxos := make(map[string]*proto.UTXO)
+	_, err = c.store.UTXOStore(ctx).Iterate(ctx, store.Cursor{}, func(utxo *proto.UTXO) error {
+		utxos[secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))] = pb.Clone(utxo).(*proto.UTXO)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for h := c.Height(); h > height; h-- {
+		block, err := c.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
+			txHash := []byte(secure.HashTransaction(tx))
+			for index := range tx.Outputs {
+				delete(utxos, secure.MakeUTXOKey(txHash, index))
+			}
+			for _, input := range tx.Inputs {
+				utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+				archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, utxoKey)
+				if err != nil {
+					return nil, err
+				}
+				if archived == nil {
+					return nil, fmt.Errorf("spent utxo %s: %w", utxoKey, ErrUTXONotFound)
+				}
+				utxo := pb.Clone(archived.UTXO).(*proto.UTXO)
+				utxo.Spent = false
+				utxos[utxoKey] = utxo
+			}
+		}
+	}
+	snap := &proto.UTXOSnapshot{
+		Height: int32(height),
+		Utxos:  unspentUTXOs(utxos),
+	}
+	for h := 0; h <= height; h++ {
+		header, err := c.headers.Get(h)
+		if err != nil {
+			return nil, err
+		}
+		snap.Headers = append(snap.Headers, header)
+	}
+	snap.Block, err = c.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	snap.Hash = snapshotHash(snap)
+	return snap, nil
+}
+
+// ImportSnapshot fills the empty store with the snapshot, the blocks below
+// the snapshot height are stored with their headers only, the node downloads
+// and verifies them later with VerifySnapshot
+func ImportSnapshot(s store.Storer, snap *proto.UTXOSnapshot) error {
+	ctx := context.Background()
+	if err := checkSnapshot(snap); err != nil {
+		return err
+	}
+	n, err := s.BlockStore(ctx).Count(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("the snapshot can only be imported into an empty store")
+	}
+	batch := store.NewBatch()
+	for _, header := range snap.Headers[:snap.Height] {
+		batch.PutBlock(&proto.Block{Header: header})
+	}
+	batch.PutBlock(snap.Block)
+	blockHash := secure.HashBlock(snap.Block)
+	for i, tx := range snap.Block.Transactions {
+		batch.PutTx(tx)
+		batch.PutTxLocation(&store.TxLocation{
+			TxHash:    []byte(secure.HashTransaction(tx)),
+			BlockHash: []byte(blockHash),
+			Height:    snap.Height,
+			Position:  int32(i),
+		})
+	}
+	for _, utxo := range snap.Utxos {
+		batch.PutUTXO(utxo)
+	}
+	if err := s.Commit(ctx, batch); err != nil {
+		return err
+	}
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		meta = &store.Meta{Version: store.SchemaVersion()}
+	}
+	meta.ChainID = hex.EncodeToString([]byte(secure.HashHeader(snap.Headers[0])))
+	meta.SnapshotHeight = snap.Height
+	meta.SnapshotHash = hashUTXOSet(snap.Utxos)
+	return s.MetaStore(ctx).Put(ctx, meta)
+}
+
+// checkSnapshot verifies the content hash of the snapshot, that its headers
+// link from the genesis to the block at the snapshot height and that
+// its UTXO set is the one committed to by the header of the block, a block
+// without the root is refused since nothing else vouches for the set
+func checkSnapshot(snap *proto.UTXOSnapshot) error {
+	if !bytes.Equal(snap.Hash, snapshotHash(snap)) {
+		return ErrSnapshotHash
+	}
+	if snap.Height < 1 || len(snap.Headers) != int(snap.Height)+1 || snap.Block == nil {
+		return fmt.Errorf("snapshot at height %d must have the headers from the genesis and the block", snap.Height)
+	}
+	for i, header := range snap.Headers {
+		if header.Height != int32(i) {
+			return fmt.Errorf("snapshot header %d has height %d", i, header.Height)
+		}
+		if i > 0 && !bytes.Equal(header.PrevBlockHash, []byte(secure.HashHeader(snap.Headers[i-1]))) {
+			return fmt.Errorf("snapshot header %d does not link to the previous one", i)
+		}
+	}
+	if secure.HashBlock(snap.Block) != secure.HashHeader(snap.Headers[snap.Height]) || !secure.VerifyBlock(snap.Block) {
+		return fmt.Errorf("snapshot block is not the block at height %d", snap.Height)
+	}
+	tree := &utxoTree{}
+	for _, utxo := range snap.Utxos {
+		if utxo.Spent {
+			return fmt.Errorf("snapshot utxo %s is spent", secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+		}
+		tree = tree.put(utxo)
+	}
+	return checkUTXORoot(snap.Block.Header, tree, 0)
+}
+
+// SnapshotHeight returns the height of the imported snapshot whose history
+// is not verified yet, zero if there is none
+func (c *Chain) SnapshotHeight() (int, error) {
+	ctx := context.Background()
+	meta, err := c.store.MetaStore(ctx).Get(ctx)
+	if err != nil || meta == nil {
+		return 0, err
+	}
+	return int(meta.SnapshotHeight), nil
+}
+
+// VerifySnapshot replays the blocks from the genesis up to the imported snapshot,
+// checking them against the stored headers, and compares the UTXO set they produce
+// with the one of the snapshot. When they match the blocks replace the stored headers
+// and the snapshot is cleared, so the store is the same as the one of a node
+// that connected every block.
+func (c *Chain) VerifySnapshot(blocks []*proto.Block) error {
+	ctx := context.Background()
+	meta, err := c.store.MetaStore(ctx).Get(ctx)
+	if err != nil {
+		return err
+	}
+	if meta == nil || meta.SnapshotHeight == 0 {
+		return fmt.Errorf("there is no snapshot to verify")
+	}
+	height := int(meta.SnapshotHeight)
+	if len(blocks) <= height {
+		return fmt.Errorf("%d blocks do not reach the snapshot height %d", len(blocks), height)
+	}
+	// the history below the pruned height keeps the headers only
+	pruned, err := c.PrunedHeight()
+	if err != nil {
+		return err
+	}
+	utxos := make(map[string]*proto.UTXO)
+	spentAt := make(map[string]int32)
+	tree := &utxoTree{}
+	lookup := func(input *proto.TxInput) (*proto.UTXO, bool, error) {
+		utxo := utxos[secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))]
+		if utxo == nil {
+			return nil, false, nil
+		}
+		return utxo, utxo.Spent, nil
+	}
+	batch := store.NewBatch()
+	for h, block := range blocks[:height+1] {
+		header, err := c.headers.Get(h)
+		if err != nil {
+			return err
+		}
+		if secure.HashBlock(block) != secure.HashHeader(header) {
+			return fmt.Errorf("block at height %d does not match the snapshot header", h)
+		}
+		// a block without its transactions still passes the signature check
+		if !secure.VerifyBlock(block) || (len(block.Transactions) == 0 && len(block.Header.MerkleRoot) > 0) {
+			return fmt.Errorf("block at height %d is not valid", h)
+		}
+		tree = tree.apply(block.Transactions)
+		if err := checkUTXORoot(block.Header, tree, c.utxoRootHeight); err != nil {
+			return err
+		}
+		if h < height && h > pruned {
+			batch.PutBlock(block)
+		}
+		blockHash := secure.HashBlock(block)
+		for i, tx := range block.Transactions {
+			// the genesis transaction creates the coins, it is not validated by the chain either
+			if h > 0 {
+				if _, err := checkTransaction(tx, lookup); err != nil {
+					return fmt.Errorf("transaction %d of block at height %d: %w", i, h, err)
+				}
+			}
+			txHash := []byte(secure.HashTransaction(tx))
+			addresses := newAddressEntries(txHash, int32(h), i)
+			for index, output := range tx.Outputs {
+				utxos[secure.MakeUTXOKey(txHash, index)] = &proto.UTXO{
+					TxHash:   txHash,
+					OutIndex: int32(index),
+					Output:   output,
+				}
+				addresses.credit(output)
+			}
+			for _, input := range tx.Inputs {
+				utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+				utxo := utxos[utxoKey]
+				utxo.Spent = true
+				spentAt[utxoKey] = int32(h)
+				addresses.debit(utxo.Output)
+			}
+			for _, entry := range addresses.list() {
+				batch.PutAddressTx(entry)
+			}
+			if h < height {
+				batch.PutTx(tx)
+				batch.PutTxLocation(&store.TxLocation{
+					TxHash:    txHash,
+					BlockHash: []byte(blockHash),
+					Height:    int32(h),
+					Position:  int32(i),
+				})
+			}
+		}
+	}
+	if !bytes.Equal(hashUTXOSet(unspentUTXOs(utxos)), meta.SnapshotHash) {
+		return ErrSnapshotMismatch
+	}
+	// the outputs spent below the snapshot go to the archive,
+	// the unspent ones are stored already and may be spent by now
+	for key, utxo := range utxos {
+		if utxo.Spent && int(spentAt[key]) > pruned {
+			batch.PutSpentUTXO(&store.SpentUTXO{
+				UTXO:   utxo,
+				Height: spentAt[key],
+			})
+		}
+	}
+	if err := c.store.Commit(ctx, batch); err != nil {
+		return err
+	}
+	meta.SnapshotHeight = 0
+	meta.SnapshotHash = nil
+	return c.store.MetaStore(ctx).Put(ctx, meta)
+}
+
+// unspentUTXOs returns the unspent outputs ordered by their keys
+func unspentUTXOs(utxos map[string]*proto.UTXO) []*proto.UTXO {
+	keys := make([]string, 0, len(utxos))
+	for key, utxo := range utxos {
+		if !utxo.Spent {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	unspent := make([]*proto.UTXO, 0, len(keys))
+	for _, key := range keys {
+		unspent = append(unspent, utxos[key])
+	}
+	return unspent
+}
+
+// hashUTXOSet hashes the length-prefixed encodings of the ordered UTXOs
+func hashUTXOSet(utxos []*proto.UTXO) []byte {
+	h := sha256.New()
+	for _, utxo := range utxos {
+		b, err := pb.MarshalOptions{Deterministic: true}.Marshal(utxo)
+		if err != nil {
+			panic(err)
+		}
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(b))))
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+// snapshotHash hashes the snapshot without its hash field
+func snapshotHash(snap *proto.UTXOSnapshot) []byte {
+	content := pb.Clone(snap).(*proto.UTXOSnapshot)
+	content.Hash = nil
+	b, err := pb.MarshalOptions{Deterministic: true}.Marshal(content)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(b)
+	return hash[:]
+}
+
+// WriteSnapshot writes the encoded snapshot
+func WriteSnapshot(w io.Writer, snap *proto.UTXOSnapshot) error {
+	b, err := pb.MarshalOptions{Deterministic: true}.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadSnapshot reads the snapshot written by WriteSnapshot and checks its content hash
+func ReadSnapshot(r io.Reader) (*proto.UTXOSnapshot, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	snap := &proto.UTXOSnapshot{}
+	if err := pb.Unmarshal(b, snap); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(snap.Hash, snapshotHash(snap)) {
+		return nil, ErrSnapshotHash
+	}
+	return snap, nil
+}
@@ -0,0 +1,184 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"github.com/yuriykis/microblocknet/node/util"
+)
+
+// addSpendingBlock connects a block with a transaction spending the output
+// of the previous block's transaction, it pays 100 away and the rest back
+func addSpendingBlock(t *testing.T, c *Chain, privKey *crypto.PrivateKey) *proto.Block {
+	block := makeSpendingBlock(t, c, privKey)
+	signBlock(t, c, block, privKey)
+	assert.Nil(t, c.AddBlock(block))
+	return block
+}
+
+// makeSpendingBlock builds the block of addSpendingBlock on top of the tip, not signed yet
+func makeSpendingBlock(t *testing.T, c *Chain, privKey *crypto.PrivateKey) *proto.Block {
+	prevBlock, err := c.GetBlockByHeight(c.Height())
+	assert.Nil(t, err)
+	prevTx := prevBlock.Transactions[len(prevBlock.Transactions)-1]
+	change := prevTx.Outputs[len(prevTx.Outputs)-1]
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{
+			{
+				PublicKey:  privKey.PublicKey().Bytes(),
+				PrevTxHash: []byte(secure.HashTransaction(prevTx)),
+				OutIndex:   int32(len(prevTx.Outputs) - 1),
+			},
+		},
+		Outputs: []*proto.TxOutput{
+			{
+				Value:   100,
+				Address: crypto.GeneratePrivateKey().PublicKey().Address().Bytes(),
+			},
+			{
+				Value:   change.Value - 100,
+				Address: privKey.PublicKey().Address().Bytes(),
+			},
+		},
+	}
+	tx.Inputs[0].Signature = secure.SignTransaction(tx, privKey).Bytes()
+	block := util.RandomBlock()
+	block.Transactions = []*proto.Transaction{tx}
+	block.Header.PrevBlockHash = []byte(secure.HashBlock(prevBlock))
+	block.Header.Height = int32(c.Height() + 1)
+	return block
+}
+
+// signBlock commits the block to the UTXO set it produces on top of the tip and signs it
+func signBlock(t *testing.T, c *Chain, block *proto.Block, privKey *crypto.PrivateKey) {
+	root, err := c.NextUTXORoot(block.Transactions)
+	assert.Nil(t, err)
+	block.Header.UtxoRoot = root
+	secure.SignBlock(block, privKey)
+}
+
+func TestChainSnapshot(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	source := New(store.NewChainMemoryStore())
+	for i := 0; i < 4; i++ {
+		addSpendingBlock(t, source, myPrivKey)
+	}
+
+	snap, err := source.Snapshot(2)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), snap.Height)
+	assert.Equal(t, 3, len(snap.Headers))
+	// the two payments of the first blocks and the change of the second one
+	assert.Equal(t, 3, len(snap.Utxos))
+	for _, utxo := range snap.Utxos {
+		assert.False(t, utxo.Spent)
+	}
+	_, err = source.Snapshot(5)
+	assert.NotNil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteSnapshot(&buf, snap))
+	read, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, snap.Hash, read.Hash)
+
+	tampered := buf.Bytes()
+	tampered[len(tampered)/2] ^= 0xff
+	_, err = ReadSnapshot(bytes.NewReader(tampered))
+	assert.NotNil(t, err)
+
+	s := store.NewChainMemoryStore()
+	assert.Nil(t, ImportSnapshot(s, read))
+	assert.NotNil(t, ImportSnapshot(s, read))
+	imported := New(s)
+	assert.Equal(t, 2, imported.Height())
+	height, err := imported.SnapshotHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, height)
+	assert.Nil(t, imported.CheckID())
+	repaired, err := imported.Repair()
+	assert.Nil(t, err)
+	assert.False(t, repaired)
+	_, err = imported.DisconnectTip()
+	assert.NotNil(t, err)
+
+	// the imported chain continues from the snapshot
+	for h := 3; h <= source.Height(); h++ {
+		block, err := source.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		assert.Nil(t, imported.AddBlock(block))
+	}
+
+	blocks := make([]*proto.Block, 0)
+	for h := 0; h <= source.Height(); h++ {
+		block, err := source.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		blocks = append(blocks, block)
+	}
+	assert.Nil(t, imported.VerifySnapshot(blocks))
+	height, err = imported.SnapshotHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, height)
+
+	// the verified store is the same as the one that connected every block
+	first, err := imported.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[1], first)
+	assert.Equal(t, len(source.Store().UTXOStore(ctx).List(ctx)), len(s.UTXOStore(ctx).List(ctx)))
+	loc, err := imported.TxLocation(secure.HashTransaction(blocks[1].Transactions[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), loc.Height)
+	history, err := imported.AddressHistory(myPrivKey.PublicKey().Address().Bytes(), store.Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(history))
+	_, err = imported.DisconnectTip()
+	assert.Nil(t, err)
+}
+
+func TestChainVerifySnapshotMismatch(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	source := New(store.NewChainMemoryStore())
+	for i := 0; i < 2; i++ {
+		addSpendingBlock(t, source, myPrivKey)
+	}
+	snap, err := source.Snapshot(2)
+	assert.Nil(t, err)
+	s := store.NewChainMemoryStore()
+	assert.Nil(t, ImportSnapshot(s, snap))
+	imported := New(s)
+
+	blocks := make([]*proto.Block, 0)
+	for h := 0; h <= source.Height(); h++ {
+		block, err := source.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		blocks = append(blocks, block)
+	}
+	// a block without its transactions does not verify
+	stripped := &proto.Block{
+		Header:    blocks[1].Header,
+		PublicKey: blocks[1].PublicKey,
+		Signature: blocks[1].Signature,
+	}
+	err = imported.VerifySnapshot([]*proto.Block{blocks[0], stripped, blocks[2]})
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrSnapshotMismatch))
+
+	// the history producing another utxo set exposes the snapshot
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	meta.SnapshotHash = util.RandomHash()
+	assert.Nil(t, s.MetaStore(ctx).Put(ctx, meta))
+	assert.True(t, errors.Is(imported.VerifySnapshot(blocks), ErrSnapshotMismatch))
+	height, err := imported.SnapshotHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, height)
+}
@@ -0,0 +1,303 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+// ErrUTXORootMismatch is returned when the UTXO root of the block header
+// is not the root of the UTXO set the block produces
+var ErrUTXORootMismatch = errors.New("block utxo root does not match the utxo set")
+
+// utxoNode is a node of the sparse Merkle tree committing to the UTXO set,
+// a leaf keeps the path and the value hash of its output. The nodes never
+// change once built, an update copies the nodes on the path, so the tree
+// of the tip stays valid while a block is validated against it.
+type utxoNode struct {
+	hash        []byte
+	left, right *utxoNode
+	key, value  []byte
+}
+
+func (n *utxoNode) isLeaf() bool {
+	return n.key != nil
+}
+
+func newUTXOLeaf(key, value []byte) *utxoNode {
+	return &utxoNode{
+		hash:  secure.HashUTXOTreeLeaf(key, value),
+		key:   key,
+		value: value,
+	}
+}
+
+func newUTXOInner(left, right *utxoNode) *utxoNode {
+	return &utxoNode{
+		hash:  secure.HashUTXOTreeNode(left.hashOrNil(), right.hashOrNil()),
+		left:  left,
+		right: right,
+	}
+}
+
+func (n *utxoNode) hashOrNil() []byte {
+	if n == nil {
+		return nil
+	}
+	return n.hash
+}
+
+// utxoTree is the sparse Merkle tree of the UTXO set, see secure.VerifyUTXOProof
+type utxoTree struct {
+	root *utxoNode
+}
+
+// Root returns the root hash committed to by the block header
+func (t *utxoTree) Root() []byte {
+	if t.root == nil {
+		return secure.EmptyUTXOTreeHash
+	}
+	return t.root.hash
+}
+
+// put returns the tree with the output added or replaced
+func (t *utxoTree) put(utxo *proto.UTXO) *utxoTree {
+	key := secure.UTXOTreeKey(utxo.TxHash, int(utxo.OutIndex))
+	return &utxoTree{root: putUTXONode(t.root, 0, newUTXOLeaf(key, secure.HashUTXOTreeValue(utxo.Output)))}
+}
+
+// delete returns the tree without the output, the same tree if it is not there
+func (t *utxoTree) delete(utxoKey string) *utxoTree {
+	txHash, outIndex, err := secure.ParseUTXOKey(utxoKey)
+	if err != nil {
+		return t
+	}
+	return &utxoTree{root: deleteUTXONode(t.root, 0, secure.UTXOTreeKey(txHash, outIndex))}
+}
+
+// apply returns the tree after the transactions, in the order the block has them
+func (t *utxoTree) apply(txs []*proto.Transaction) *utxoTree {
+	for _, tx := range txs {
+		txHash := []byte(secure.HashTransaction(tx))
+		for index, output := range tx.Outputs {
+			t = t.put(&proto.UTXO{
+				TxHash:   txHash,
+				OutIndex: int32(index),
+				Output:   output,
+			})
+		}
+		for _, input := range tx.Inputs {
+			t = t.delete(secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex)))
+		}
+	}
+	return t
+}
+
+func putUTXONode(n *utxoNode, depth int, leaf *utxoNode) *utxoNode {
+	switch {
+	case n == nil:
+		return leaf
+	case n.isLeaf() && bytes.Equal(n.key, leaf.key):
+		return leaf
+	case n.isLeaf():
+		return joinUTXOLeaves(n, leaf, depth)
+	case secure.UTXOTreeBit(leaf.key, depth) == 0:
+		return newUTXOInner(putUTXONode(n.left, depth+1, leaf), n.right)
+	default:
+		return newUTXOInner(n.left, putUTXONode(n.right, depth+1, leaf))
+	}
+}
+
+// joinUTXOLeaves builds the subtree of the two leaves, the inner nodes
+// go down the common prefix of their paths to the depth they diverge at
+func joinUTXOLeaves(a, b *utxoNode, depth int) *utxoNode {
+	bitA, bitB := secure.UTXOTreeBit(a.key, depth), secure.UTXOTreeBit(b.key, depth)
+	switch {
+	case bitA == bitB && bitA == 0:
+		return newUTXOInner(joinUTXOLeaves(a, b, depth+1), nil)
+	case bitA == bitB:
+		return newUTXOInner(nil, joinUTXOLeaves(a, b, depth+1))
+	case bitA == 0:
+		return newUTXOInner(a, b)
+	default:
+		return newUTXOInner(b, a)
+	}
+}
+
+func deleteUTXONode(n *utxoNode, depth int, key []byte) *utxoNode {
+	if n == nil {
+		return nil
+	}
+	if n.isLeaf() {
+		if bytes.Equal(n.key, key) {
+			return nil
+		}
+		return n
+	}
+	left, right := n.left, n.right
+	if secure.UTXOTreeBit(key, depth) == 0 {
+		left = deleteUTXONode(left, depth+1, key)
+		if left == n.left {
+			return n
+		}
+	} else {
+		right = deleteUTXONode(right, depth+1, key)
+		if right == n.right {
+			return n
+		}
+	}
+	// the subtree left with a single output is the leaf of the output
+	switch {
+	case left == nil && right == nil:
+		return nil
+	case left == nil && right.isLeaf():
+		return right
+	case right == nil && left.isLeaf():
+		return left
+	default:
+		return newUTXOInner(left, right)
+	}
+}
+
+// prove builds the proof of the outpoint against the root of the tree without
+// the output, the tree keeps its hash only. It returns the value hash
+// of the output, nil when the proof is the one of absence.
+func (t *utxoTree) prove(txHash []byte, outIndex int) (*proto.UTXOProof, []byte) {
+	key := secure.UTXOTreeKey(txHash, outIndex)
+	proof := &proto.UTXOProof{
+		TxHash:   txHash,
+		OutIndex: int32(outIndex),
+	}
+	n := t.root
+	for depth := 0; n != nil && !n.isLeaf(); depth++ {
+		if secure.UTXOTreeBit(key, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, n.right.hashOrNil())
+			n = n.left
+		} else {
+			proof.Siblings = append(proof.Siblings, n.left.hashOrNil())
+			n = n.right
+		}
+	}
+	if n == nil {
+		return proof, nil
+	}
+	if bytes.Equal(n.key, key) {
+		return proof, n.value
+	}
+	proof.LeafKey = n.key
+	proof.LeafValueHash = n.value
+	return proof, nil
+}
+
+// loadUTXOTree builds the tree of the stored UTXO set the first time it is needed,
+// after that the tree follows the blocks connected and disconnected by the chain
+func (c *Chain) loadUTXOTree() (*utxoTree, error) {
+	c.utxoTreeLock.Lock()
+	defer c.utxoTreeLock.Unlock()
+
+	if c.utxoTree != nil {
+		return c.utxoTree, nil
+	}
+	ctx := context.Background()
+	tree := &utxoTree{}
+	_, err := c.store.UTXOStore(ctx).Iterate(ctx, store.Cursor{}, func(utxo *proto.UTXO) error {
+		tree = tree.put(utxo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.utxoTree = tree
+	return tree, nil
+}
+
+// setUTXOTree replaces the loaded tree, nil makes the next use load it from the store
+func (c *Chain) setUTXOTree(tree *utxoTree) {
+	c.utxoTreeLock.Lock()
+	defer c.utxoTreeLock.Unlock()
+
+	c.utxoTree = tree
+}
+
+// loadedUTXOTree returns the tree if it is loaded already, nil otherwise
+func (c *Chain) loadedUTXOTree() *utxoTree {
+	c.utxoTreeLock.Lock()
+	defer c.utxoTreeLock.Unlock()
+
+	return c.utxoTree
+}
+
+// NextUTXORoot returns the UTXO root of the block with the transactions
+// on top of the tip, the miner puts it into the header
+func (c *Chain) NextUTXORoot(txs []*proto.Transaction) ([]byte, error) {
+	tree, err := c.loadUTXOTree()
+	if err != nil {
+		return nil, err
+	}
+	return tree.apply(txs).Root(), nil
+}
+
+// UTXOProof returns the proof that the outpoint is in the UTXO set of the tip,
+// or that it is not, the proof is checked against the root of the tip header
+func (c *Chain) UTXOProof(txHash []byte, outIndex int) (*proto.UTXOProof, error) {
+	tree, err := c.loadUTXOTree()
+	if err != nil {
+		return nil, err
+	}
+	proof, value := tree.prove(txHash, outIndex)
+	proof.Height = int32(c.Height())
+	if value == nil {
+		return proof, nil
+	}
+	ctx := context.Background()
+	utxo, err := c.store.UTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(txHash, outIndex))
+	if err != nil {
+		return nil, err
+	}
+	// the block connected in the meantime may have spent it
+	if utxo == nil || !bytes.Equal(secure.HashUTXOTreeValue(utxo.Output), value) {
+		return nil, fmt.Errorf("utxo %x:%d changed while it was proved", txHash, outIndex)
+	}
+	proof.Utxo = utxo
+	return proof, nil
+}
+
+// SetUTXORootHeight sets the height of the first block whose header has to commit
+// to the UTXO set, the blocks below it may come without the root. A node joining
+// a chain that started before the headers carried the root sets the height
+// the chain required it from, by default it is the one recorded when the store
+// was upgraded, or the first block after the genesis for a new store.
+func (c *Chain) SetUTXORootHeight(height int) error {
+	if height < 1 {
+		return fmt.Errorf("utxo root height %d is not positive", height)
+	}
+	c.utxoRootHeight = height
+	return nil
+}
+
+// storedUTXORootHeight returns the utxo root height recorded by the store migration
+func storedUTXORootHeight(s store.Storer) int {
+	ctx := context.Background()
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	if err != nil || meta == nil || meta.UTXORootHeight < 1 {
+		return 1
+	}
+	return int(meta.UTXORootHeight)
+}
+
+// checkUTXORoot compares the UTXO root of the header with the one of the tree,
+// a header below the root height may have no root at all, the genesis one never has
+func checkUTXORoot(header *proto.Header, tree *utxoTree, rootHeight int) error {
+	if len(header.UtxoRoot) == 0 && int(header.Height) < rootHeight {
+		return nil
+	}
+	if !bytes.Equal(header.UtxoRoot, tree.Root()) {
+		return fmt.Errorf("block at height %d: %w", header.Height, ErrUTXORootMismatch)
+	}
+	return nil
+}
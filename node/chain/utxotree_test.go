@@ -0,0 +1,191 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"github.com/yuriykis/microblocknet/node/util"
+	pb "google.golang.org/protobuf/proto"
+)
+
+func TestUTXOTreeRoot(t *testing.T) {
+	utxos := make([]*proto.UTXO, 0)
+	for i := 0; i < 20; i++ {
+		utxos = append(utxos, util.RandomUTXO())
+	}
+	forward, backward := &utxoTree{}, &utxoTree{}
+	for i := range utxos {
+		forward = forward.put(utxos[i])
+		backward = backward.put(utxos[len(utxos)-1-i])
+	}
+	// the root depends on the set only, not on the order it was built in
+	assert.Equal(t, forward.Root(), backward.Root())
+
+	extra := util.RandomUTXO()
+	tree := forward.put(extra)
+	assert.NotEqual(t, forward.Root(), tree.Root())
+	tree = tree.delete(secure.MakeUTXOKey(extra.TxHash, int(extra.OutIndex)))
+	assert.Equal(t, forward.Root(), tree.Root())
+
+	for _, utxo := range utxos {
+		tree = tree.delete(secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	}
+	assert.Equal(t, secure.EmptyUTXOTreeHash, tree.Root())
+}
+
+func TestUTXOTreeProof(t *testing.T) {
+	tree := &utxoTree{}
+	utxos := make([]*proto.UTXO, 0)
+	for i := 0; i < 20; i++ {
+		utxo := util.RandomUTXO()
+		utxos = append(utxos, utxo)
+		tree = tree.put(utxo)
+	}
+	root := tree.Root()
+
+	for _, utxo := range utxos {
+		proof, value := tree.prove(utxo.TxHash, int(utxo.OutIndex))
+		assert.Equal(t, secure.HashUTXOTreeValue(utxo.Output), value)
+		proof.Utxo = utxo
+		included, err := secure.VerifyUTXOProof(root, proof)
+		assert.Nil(t, err)
+		assert.True(t, included)
+	}
+
+	// the absence of the outpoint ends either at an empty subtree or at another leaf
+	for i := 0; i < 20; i++ {
+		proof, value := tree.prove(util.RandomHash(), 0)
+		assert.Nil(t, value)
+		included, err := secure.VerifyUTXOProof(root, proof)
+		assert.Nil(t, err)
+		assert.False(t, included)
+	}
+	proof, _ := (&utxoTree{}).prove(util.RandomHash(), 0)
+	included, err := secure.VerifyUTXOProof(secure.EmptyUTXOTreeHash, proof)
+	assert.Nil(t, err)
+	assert.False(t, included)
+
+	// a proof of the output turned into the one of absence does not match the root
+	utxo := utxos[0]
+	proof, _ = tree.prove(utxo.TxHash, int(utxo.OutIndex))
+	_, err = secure.VerifyUTXOProof(root, proof)
+	assert.True(t, errors.Is(err, secure.ErrUTXOProofInvalid))
+
+	// nor does the one of the output with another value
+	proof.Utxo = pb.Clone(utxo).(*proto.UTXO)
+	proof.Utxo.Output.Value++
+	_, err = secure.VerifyUTXOProof(root, proof)
+	assert.True(t, errors.Is(err, secure.ErrUTXOProofInvalid))
+}
+
+func TestChainUTXORoot(t *testing.T) {
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	blocks := make([]*proto.Block, 0)
+	for i := 0; i < 3; i++ {
+		blocks = append(blocks, addSpendingBlock(t, chain, myPrivKey))
+	}
+
+	// the tree built from the store is the one the chain kept up to date
+	tip, err := chain.GetBlockByHeight(chain.Height())
+	assert.Nil(t, err)
+	restarted := New(s)
+	tree, err := restarted.loadUTXOTree()
+	assert.Nil(t, err)
+	assert.Equal(t, tip.Header.UtxoRoot, tree.Root())
+
+	tx := blocks[2].Transactions[0]
+	txHash := []byte(secure.HashTransaction(tx))
+	proof, err := restarted.UTXOProof(txHash, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), proof.Height)
+	included, err := secure.VerifyUTXOProof(tip.Header.UtxoRoot, proof)
+	assert.Nil(t, err)
+	assert.True(t, included)
+
+	// the output spent by the tip is proved absent
+	spent := tx.Inputs[0]
+	proof, err = restarted.UTXOProof(spent.PrevTxHash, int(spent.OutIndex))
+	assert.Nil(t, err)
+	assert.Nil(t, proof.Utxo)
+	included, err = secure.VerifyUTXOProof(tip.Header.UtxoRoot, proof)
+	assert.Nil(t, err)
+	assert.False(t, included)
+
+	// disconnecting the tip brings the root of the previous block back
+	_, err = restarted.DisconnectTip()
+	assert.Nil(t, err)
+	root, err := restarted.NextUTXORoot(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[1].Header.UtxoRoot, root)
+	assert.Nil(t, restarted.AddBlock(blocks[2]))
+
+	// the block committing to another set is rejected
+	next := &proto.Block{
+		Header: &proto.Header{
+			Version:       1,
+			PrevBlockHash: []byte(secure.HashBlock(tip)),
+			Height:        4,
+			UtxoRoot:      util.RandomHash(),
+		},
+	}
+	secure.SignBlock(next, myPrivKey)
+	err = restarted.ValidateBlock(next)
+	assert.True(t, errors.Is(err, ErrUTXORootMismatch))
+	next.Header.UtxoRoot = tip.Header.UtxoRoot
+	secure.SignBlock(next, myPrivKey)
+	assert.Nil(t, restarted.ValidateBlock(next))
+}
+
+func TestChainUTXORootHeight(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	assert.NotNil(t, chain.SetUTXORootHeight(0))
+	assert.Nil(t, chain.SetUTXORootHeight(3))
+
+	// the blocks below the height were made before the headers carried the root
+	addBlockWithoutRoot := func() error {
+		block := makeSpendingBlock(t, chain, myPrivKey)
+		block.Header.UtxoRoot = nil
+		secure.SignBlock(block, myPrivKey)
+		return chain.AddBlock(block)
+	}
+	assert.Nil(t, addBlockWithoutRoot())
+	assert.Nil(t, addBlockWithoutRoot())
+	assert.True(t, errors.Is(addBlockWithoutRoot(), ErrUTXORootMismatch))
+	addSpendingBlock(t, chain, myPrivKey)
+
+	// the store written before the root records the height above its blocks when it is upgraded
+	assert.Nil(t, store.Migrate(ctx, s))
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), meta.UTXORootHeight)
+	report, err := New(s).Verify()
+	assert.Nil(t, err)
+	assert.True(t, report.Consistent())
+
+	restarted := New(s)
+	assert.Nil(t, restarted.SetUTXORootHeight(1))
+	_, err = restarted.Verify()
+	assert.True(t, errors.Is(err, ErrUTXORootMismatch))
+
+	// the exported chain imports into an empty store with the same height only
+	var buf bytes.Buffer
+	_, err = chain.Export(&buf)
+	assert.Nil(t, err)
+	_, _, err = Import(store.NewChainMemoryStore(), bytes.NewReader(buf.Bytes()), 0)
+	assert.True(t, errors.Is(err, ErrUTXORootMismatch))
+	imported, _, err := Import(store.NewChainMemoryStore(), bytes.NewReader(buf.Bytes()), 3)
+	assert.Nil(t, err)
+	assert.Equal(t, chain.Height(), imported.Height())
+}
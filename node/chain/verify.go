@@ -0,0 +1,342 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// VerifyReport lists the differences between the stores and the state
+// derived from the blocks of the chain, the keys are the UTXO keys
+// and the transaction hashes
+type VerifyReport struct {
+	Blocks int
+	Txs    int
+	// the unspent outputs missing from the UTXO set
+	MissingUTXOs []string
+	// the outputs of the UTXO set the blocks do not leave unspent
+	ExtraUTXOs []string
+	// the outputs of the UTXO set that differ from the ones of the blocks
+	ChangedUTXOs []string
+	// the spent outputs missing from the archive or archived at another height
+	MissingSpent []string
+	// the archived outputs the blocks do not spend
+	ExtraSpent []string
+	// the transactions of the chain missing from the transaction store
+	MissingTxs []string
+	// the transactions of the chain without their location, or located elsewhere
+	MissingLocations []string
+	// the transactions out of the chain still located in a block
+	ExtraLocations []string
+}
+
+// Consistent reports whether the stores match the blocks
+func (r *VerifyReport) Consistent() bool {
+	return len(r.MissingUTXOs) == 0 && len(r.ExtraUTXOs) == 0 && len(r.ChangedUTXOs) == 0 &&
+		len(r.MissingSpent) == 0 && len(r.ExtraSpent) == 0 && len(r.MissingTxs) == 0 &&
+		len(r.MissingLocations) == 0 && len(r.ExtraLocations) == 0
+}
+
+// replayState is the state of the stores derived from the blocks,
+// the orders keep the keys as the blocks created them
+type replayState struct {
+	utxos     map[string]*proto.UTXO
+	utxoOrder []string
+	spentAt   map[string]int32
+	txs       map[string]*proto.Transaction
+	txOrder   []string
+	locations map[string]*store.TxLocation
+	addresses []*store.AddressTx
+}
+
+// Verify replays the blocks from the genesis to the tip, checking every block
+// and transaction again, and compares the stores with the state the blocks produce.
+// An invalid block stops it with an error, the differences of the stores are reported.
+func (c *Chain) Verify() (*VerifyReport, error) {
+	state, err := c.replay()
+	if err != nil {
+		return nil, err
+	}
+	return c.compare(state)
+}
+
+// Reindex verifies the chain and rebuilds the UTXO set, the archive,
+// the transaction store and the indexes from the blocks in one batch.
+// It returns the report of the differences found before the rebuild.
+func (c *Chain) Reindex() (*VerifyReport, error) {
+	ctx := context.Background()
+	state, err := c.replay()
+	if err != nil {
+		return nil, err
+	}
+	report, err := c.compare(state)
+	if err != nil {
+		return nil, err
+	}
+	batch := store.NewBatch()
+	for _, key := range state.utxoOrder {
+		utxo := state.utxos[key]
+		if !utxo.Spent {
+			batch.PutUTXO(utxo)
+			continue
+		}
+		batch.PutSpentUTXO(&store.SpentUTXO{
+			UTXO:   utxo,
+			Height: state.spentAt[key],
+		})
+	}
+	for _, key := range report.ExtraUTXOs {
+		batch.DeleteUTXO(key)
+	}
+	for _, key := range report.ExtraSpent {
+		batch.DeleteSpentUTXO(key)
+	}
+	for _, txHash := range state.txOrder {
+		batch.PutTx(state.txs[txHash])
+		batch.PutTxLocation(state.locations[txHash])
+	}
+	for _, entry := range state.addresses {
+		batch.PutAddressTx(entry)
+	}
+	for _, txHash := range report.ExtraLocations {
+		batch.DeleteTxLocation(txHash)
+		tx, err := c.store.TxStore(ctx).Get(ctx, txHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range c.txAddresses(ctx, state, tx) {
+			batch.DeleteAddressTx(&store.AddressTx{
+				Address: address,
+				TxHash:  []byte(txHash),
+			})
+		}
+	}
+	if err := c.store.Commit(ctx, batch); err != nil {
+		return nil, err
+	}
+	// the tree follows the rebuilt set from now on
+	c.setUTXOTree(nil)
+	return report, nil
+}
+
+// replay validates the blocks of the chain in order and derives the state
+// they produce, the outputs spent by the blocks stay in the UTXO map marked spent
+func (c *Chain) replay() (*replayState, error) {
+	base, err := c.SnapshotHeight()
+	if err != nil {
+		return nil, err
+	}
+	if base > 0 {
+		return nil, fmt.Errorf("the history below the imported snapshot at height %d is not verified yet", base)
+	}
+	if err := c.checkNotPruned(1); err != nil {
+		return nil, err
+	}
+	state := &replayState{
+		utxos:     make(map[string]*proto.UTXO),
+		spentAt:   make(map[string]int32),
+		txs:       make(map[string]*proto.Transaction),
+		locations: make(map[string]*store.TxLocation),
+	}
+	lookup := func(input *proto.TxInput) (*proto.UTXO, bool, error) {
+		utxo := state.utxos[secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))]
+		if utxo == nil {
+			return nil, false, nil
+		}
+		return utxo, utxo.Spent, nil
+	}
+	var prevHash string
+	tree := &utxoTree{}
+	for h := 0; h <= c.Height(); h++ {
+		block, err := c.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		blockHash := secure.HashBlock(block)
+		if int(block.Header.Height) != h || (h > 0 && !bytes.Equal(block.Header.PrevBlockHash, []byte(prevHash))) {
+			return nil, fmt.Errorf("block at height %d does not link to the previous one", h)
+		}
+		// a block without its transactions still passes the signature check
+		if !secure.VerifyBlock(block) || (len(block.Transactions) == 0 && len(block.Header.MerkleRoot) > 0) {
+			return nil, fmt.Errorf("block at height %d is not valid", h)
+		}
+		prevHash = blockHash
+		tree = tree.apply(block.Transactions)
+		if err := checkUTXORoot(block.Header, tree, c.utxoRootHeight); err != nil {
+			return nil, err
+		}
+		for i, tx := range block.Transactions {
+			// the genesis transaction creates the coins, it is not validated by the chain either
+			if h > 0 {
+				if _, err := checkTransaction(tx, lookup); err != nil {
+					return nil, fmt.Errorf("transaction %d of block at height %d: %w", i, h, err)
+				}
+			}
+			txHash := []byte(secure.HashTransaction(tx))
+			state.txs[string(txHash)] = tx
+			state.txOrder = append(state.txOrder, string(txHash))
+			state.locations[string(txHash)] = &store.TxLocation{
+				TxHash:    txHash,
+				BlockHash: []byte(blockHash),
+				Height:    int32(h),
+				Position:  int32(i),
+			}
+			addresses := newAddressEntries(txHash, int32(h), i)
+			for index, output := range tx.Outputs {
+				utxoKey := secure.MakeUTXOKey(txHash, index)
+				state.utxoOrder = append(state.utxoOrder, utxoKey)
+				state.utxos[utxoKey] = &proto.UTXO{
+					TxHash:   txHash,
+					OutIndex: int32(index),
+					Output:   output,
+				}
+				addresses.credit(output)
+			}
+			for _, input := range tx.Inputs {
+				utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+				utxo := state.utxos[utxoKey]
+				utxo.Spent = true
+				state.spentAt[utxoKey] = int32(h)
+				addresses.debit(utxo.Output)
+			}
+			state.addresses = append(state.addresses, addresses.list()...)
+		}
+	}
+	return state, nil
+}
+
+// compare checks the stores against the replayed state, the stored transactions
+// out of the chain lead to the archived outputs and the locations left behind
+func (c *Chain) compare(state *replayState) (*VerifyReport, error) {
+	ctx := context.Background()
+	report := &VerifyReport{
+		Blocks: c.Height() + 1,
+		Txs:    len(state.txs),
+	}
+	for _, key := range state.utxoOrder {
+		utxo := state.utxos[key]
+		stored, err := c.store.UTXOStore(ctx).Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if utxo.Spent {
+			if stored != nil {
+				report.ExtraUTXOs = append(report.ExtraUTXOs, key)
+			}
+			archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if archived == nil || archived.Height != state.spentAt[key] {
+				report.MissingSpent = append(report.MissingSpent, key)
+			}
+			continue
+		}
+		switch {
+		case stored == nil:
+			report.MissingUTXOs = append(report.MissingUTXOs, key)
+		case stored.Spent || !pb.Equal(stored.Output, utxo.Output):
+			report.ChangedUTXOs = append(report.ChangedUTXOs, key)
+		}
+	}
+	_, err := c.store.UTXOStore(ctx).Iterate(ctx, store.Cursor{}, func(utxo *proto.UTXO) error {
+		key := secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))
+		if _, ok := state.utxos[key]; !ok {
+			report.ExtraUTXOs = append(report.ExtraUTXOs, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, txHash := range state.txOrder {
+		tx, err := c.store.TxStore(ctx).Get(ctx, txHash)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			report.MissingTxs = append(report.MissingTxs, txHash)
+		}
+		loc, err := c.store.TxLocationStore(ctx).Get(ctx, txHash)
+		if err != nil {
+			return nil, err
+		}
+		if loc == nil || !sameLocation(loc, state.locations[txHash]) {
+			report.MissingLocations = append(report.MissingLocations, txHash)
+		}
+	}
+	// every archived output was created by a stored transaction,
+	// the ones of the chain are checked above
+	stale := make([]*proto.Transaction, 0)
+	_, err = c.store.TxStore(ctx).Iterate(ctx, store.Cursor{}, func(tx *proto.Transaction) error {
+		if _, ok := state.txs[secure.HashTransaction(tx)]; !ok {
+			stale = append(stale, tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range stale {
+		txHash := secure.HashTransaction(tx)
+		for index := range tx.Outputs {
+			key := secure.MakeUTXOKey([]byte(txHash), index)
+			archived, err := c.store.SpentUTXOStore(ctx).Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if archived != nil {
+				report.ExtraSpent = append(report.ExtraSpent, key)
+			}
+		}
+		loc, err := c.store.TxLocationStore(ctx).Get(ctx, txHash)
+		if err != nil {
+			return nil, err
+		}
+		if loc != nil {
+			report.ExtraLocations = append(report.ExtraLocations, txHash)
+		}
+	}
+	sort.Strings(report.ExtraUTXOs)
+	sort.Strings(report.ExtraSpent)
+	sort.Strings(report.ExtraLocations)
+	return report, nil
+}
+
+// txAddresses returns the addresses the transaction pays to or spends from,
+// the spent outputs are looked up in the replayed state and the stored transactions
+func (c *Chain) txAddresses(ctx context.Context, state *replayState, tx *proto.Transaction) [][]byte {
+	seen := make(map[string]bool)
+	addresses := make([][]byte, 0)
+	add := func(address []byte) {
+		if !seen[string(address)] {
+			seen[string(address)] = true
+			addresses = append(addresses, address)
+		}
+	}
+	for _, output := range tx.Outputs {
+		add(output.Address)
+	}
+	for _, input := range tx.Inputs {
+		if utxo, ok := state.utxos[secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))]; ok {
+			add(utxo.Output.Address)
+			continue
+		}
+		prev, err := c.store.TxStore(ctx).Get(ctx, string(input.PrevTxHash))
+		if err == nil && prev != nil && int(input.OutIndex) < len(prev.Outputs) {
+			add(prev.Outputs[input.OutIndex].Address)
+		}
+	}
+	return addresses
+}
+
+func sameLocation(a, b *store.TxLocation) bool {
+	return bytes.Equal(a.TxHash, b.TxHash) && bytes.Equal(a.BlockHash, b.BlockHash) &&
+		a.Height == b.Height && a.Position == b.Position
+}
@@ -0,0 +1,86 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"github.com/yuriykis/microblocknet/node/util"
+	pb "google.golang.org/protobuf/proto"
+)
+
+func TestChainVerifyReindex(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	blocks := make([]*proto.Block, 0)
+	for i := 0; i < 3; i++ {
+		blocks = append(blocks, addSpendingBlock(t, chain, myPrivKey))
+	}
+	report, err := chain.Verify()
+	assert.Nil(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 4, report.Blocks)
+	assert.Equal(t, 4, report.Txs)
+
+	// the block disconnected from the chain leaves its transaction stored only
+	disconnected, err := chain.DisconnectTip()
+	assert.Nil(t, err)
+	report, err = chain.Verify()
+	assert.Nil(t, err)
+	assert.True(t, report.Consistent())
+	assert.Nil(t, chain.AddBlock(disconnected))
+
+	tx := blocks[1].Transactions[0]
+	txHash := secure.HashTransaction(tx)
+	unspent := secure.MakeUTXOKey([]byte(txHash), 0)
+	spent := secure.MakeUTXOKey(tx.Inputs[0].PrevTxHash, int(tx.Inputs[0].OutIndex))
+	extra := util.RandomUTXO()
+	assert.Nil(t, s.UTXOStore(ctx).Delete(ctx, unspent))
+	assert.Nil(t, s.UTXOStore(ctx).Put(ctx, extra))
+	assert.Nil(t, s.SpentUTXOStore(ctx).Delete(ctx, spent))
+	assert.Nil(t, s.TxLocationStore(ctx).Delete(ctx, txHash))
+
+	report, err = chain.Verify()
+	assert.Nil(t, err)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, []string{unspent}, report.MissingUTXOs)
+	assert.Equal(t, []string{secure.MakeUTXOKey(extra.TxHash, int(extra.OutIndex))}, report.ExtraUTXOs)
+	assert.Equal(t, []string{spent}, report.MissingSpent)
+	assert.Equal(t, []string{txHash}, report.MissingLocations)
+
+	report, err = chain.Reindex()
+	assert.Nil(t, err)
+	assert.False(t, report.Consistent())
+	report, err = chain.Verify()
+	assert.Nil(t, err)
+	assert.True(t, report.Consistent())
+	loc, err := s.TxLocationStore(ctx).Get(ctx, txHash)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), loc.Height)
+	archived, err := s.SpentUTXOStore(ctx).Get(ctx, spent)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), archived.Height)
+}
+
+func TestChainVerifyInvalidTransaction(t *testing.T) {
+	ctx := context.Background()
+	myPrivKey := crypto.PrivateKeyFromString(godSeed)
+	s := store.NewChainMemoryStore()
+	chain := New(s)
+	block := addSpendingBlock(t, chain, myPrivKey)
+
+	// the stored block is replaced with the one spending more than its input has
+	assert.Nil(t, s.BlockStore(ctx).Delete(ctx, secure.HashBlock(block)))
+	block = pb.Clone(block).(*proto.Block)
+	block.Transactions[0].Outputs[0].Value += 1000000
+	secure.SignBlock(block, myPrivKey)
+	assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+	_, err := New(s).Verify()
+	assert.NotNil(t, err)
+}
@@ -9,6 +9,9 @@ import (
 type Client interface {
 	Handshake(ctx context.Context, v *proto.Version) (*proto.Version, error)
 	NewTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
+	StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
 	NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, error)
 	GetBlocks(ctx context.Context, v *proto.Version) (*proto.Blocks, error)
+	GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error)
+	GetMempoolTransactions(ctx context.Context, h *proto.TxHashes) (*proto.Transactions, error)
 }
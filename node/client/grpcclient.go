@@ -36,6 +36,10 @@ func (c *GRPCClient) NewTransaction(ctx context.Context, t *proto.Transaction) (
 	return c.client.NewTransaction(ctx, t)
 }
 
+func (c *GRPCClient) StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error) {
+	return c.client.StemTransaction(ctx, t)
+}
+
 func (c *GRPCClient) NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, error) {
 	return c.client.NewBlock(ctx, b)
 }
@@ -43,3 +47,11 @@ func (c *GRPCClient) NewBlock(ctx context.Context, b *proto.Block) (*proto.Block
 func (c *GRPCClient) GetBlocks(ctx context.Context, v *proto.Version) (*proto.Blocks, error) {
 	return c.client.GetBlocks(ctx, v)
 }
+
+func (c *GRPCClient) GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error) {
+	return c.client.GetMempool(ctx, v)
+}
+
+func (c *GRPCClient) GetMempoolTransactions(ctx context.Context, h *proto.TxHashes) (*proto.Transactions, error) {
+	return c.client.GetMempoolTransactions(ctx, h)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+const snapshotUsage = `usage:
+  node snapshot export <file> [height]  export the UTXO set at the height, the tip by default
+  node snapshot import <file>           import the snapshot into an empty store`
+
+const chainUsage = `usage:
+  node chain export <file>  write the blocks from the genesis to the tip
+  node chain import <file>  validate and connect the blocks of the file
+  node chain verify         check the stored blocks again and compare the stores with them
+  node chain reindex        verify and rebuild the utxo set and the indexes from the blocks`
+
+// runCommand runs the subcommand named by the first argument,
+// it returns false when there is none and the node should start
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "snapshot":
+		return true, snapshotCommand(args[1:])
+	case "chain":
+		return true, chainCommand(args[1:])
+	default:
+		return true, fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// openCommandStore opens the store configured in the environment, the commands
+// run while the node is stopped, so they need a store that persists the chain
+func openCommandStore() (store.Storer, error) {
+	conf, err := storeConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if conf.Type == defaultStoreType {
+		return nil, fmt.Errorf("the %s store does not persist the chain, set STORE_TYPE", conf.Type)
+	}
+	st, err := store.NewChainStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Migrate(context.Background(), st); err != nil {
+		closeCommandStore(st)
+		return nil, err
+	}
+	return st, nil
+}
+
+func closeCommandStore(st store.Storer) {
+	if closer, ok := st.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// storedChain returns the chain of the store for the commands that only read it,
+// an empty store fails instead of getting a new genesis block
+func storedChain(st store.Storer) (*chain.Chain, error) {
+	ctx := context.Background()
+	n, err := st.BlockStore(ctx).Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("the store holds no blocks, check STORE_TYPE and STORE_PATH")
+	}
+	return chain.New(st), nil
+}
+
+func snapshotCommand(args []string) error {
+	if len(args) < 2 {
+		return errors.New(snapshotUsage)
+	}
+	switch args[0] {
+	case "export":
+		height := -1
+		if len(args) > 2 {
+			h, err := strconv.Atoi(args[2])
+			if err != nil {
+				return fmt.Errorf("invalid height %q: %w", args[2], err)
+			}
+			height = h
+		}
+		return exportSnapshot(args[1], height)
+	case "import":
+		return importSnapshot(args[1])
+	default:
+		return errors.New(snapshotUsage)
+	}
+}
+
+// exportSnapshot writes the snapshot at the height to the file, a negative height is the tip
+func exportSnapshot(path string, height int) error {
+	st, err := openCommandStore()
+	if err != nil {
+		return err
+	}
+	defer closeCommandStore(st)
+	c, err := storedChain(st)
+	if err != nil {
+		return err
+	}
+	if height < 0 {
+		height = c.Height()
+	}
+	snap, err := c.Snapshot(height)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := chain.WriteSnapshot(f, snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d utxos at height %d to %s, hash %x\n", len(snap.Utxos), snap.Height, path, snap.Hash)
+	return nil
+}
+
+// importSnapshot fills the empty store with the snapshot in the file,
+// the node started on the store syncs from the snapshot height
+func importSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	snap, err := chain.ReadSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot %s: %w", path, err)
+	}
+	st, err := openCommandStore()
+	if err != nil {
+		return err
+	}
+	defer closeCommandStore(st)
+	if err := chain.ImportSnapshot(st, snap); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d utxos at height %d from %s\n", len(snap.Utxos), snap.Height, path)
+	return nil
+}
+
+func chainCommand(args []string) error {
+	if len(args) == 1 {
+		switch args[0] {
+		case "verify":
+			return verifyChain(false)
+		case "reindex":
+			return verifyChain(true)
+		}
+	}
+	if len(args) != 2 {
+		return errors.New(chainUsage)
+	}
+	switch args[0] {
+	case "export":
+		return exportChain(args[1])
+	case "import":
+		return importChain(args[1])
+	default:
+		return errors.New(chainUsage)
+	}
+}
+
+// exportChain writes all the blocks of the chain to the file
+func exportChain(path string) error {
+	st, err := openCommandStore()
+	if err != nil {
+		return err
+	}
+	defer closeCommandStore(st)
+	c, err := storedChain(st)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := c.Export(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d blocks to %s\n", n, path)
+	return nil
+}
+
+// importChain connects the blocks of the file, an empty store takes the chain of the file
+func importChain(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := openCommandStore()
+	if err != nil {
+		return err
+	}
+	defer closeCommandStore(st)
+	utxoRootHeight := 0
+	if err := intFromEnv("UTXO_ROOT_HEIGHT", &utxoRootHeight); err != nil {
+		return err
+	}
+	c, n, err := chain.Import(st, f, utxoRootHeight)
+	if err != nil {
+		return fmt.Errorf("import stopped after %d blocks: %w", n, err)
+	}
+	fmt.Printf("imported %d blocks from %s, chain height %d\n", n, path, c.Height())
+	return nil
+}
+
+// verifyChain prints the differences between the stores and the blocks of the chain,
+// with reindex the stores are rebuilt from the blocks after that. It fails
+// when the stores do not match and they are not rebuilt.
+func verifyChain(reindex bool) error {
+	st, err := openCommandStore()
+	if err != nil {
+		return err
+	}
+	defer closeCommandStore(st)
+	c, err := storedChain(st)
+	if err != nil {
+		return err
+	}
+	utxoRootHeight := 0
+	if err := intFromEnv("UTXO_ROOT_HEIGHT", &utxoRootHeight); err != nil {
+		return err
+	}
+	if utxoRootHeight != 0 {
+		if err := c.SetUTXORootHeight(utxoRootHeight); err != nil {
+			return err
+		}
+	}
+	var report *chain.VerifyReport
+	if reindex {
+		report, err = c.Reindex()
+	} else {
+		report, err = c.Verify()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("verified %d blocks with %d transactions\n", report.Blocks, report.Txs)
+	printMismatches("unspent outputs missing from the utxo set", report.MissingUTXOs, utxoKeyString)
+	printMismatches("outputs in the utxo set not left unspent by the blocks", report.ExtraUTXOs, utxoKeyString)
+	printMismatches("outputs in the utxo set different from the blocks", report.ChangedUTXOs, utxoKeyString)
+	printMismatches("spent outputs missing from the archive", report.MissingSpent, utxoKeyString)
+	printMismatches("archived outputs not spent by the blocks", report.ExtraSpent, utxoKeyString)
+	printMismatches("transactions missing from the store", report.MissingTxs, hashString)
+	printMismatches("transactions with a missing or wrong location", report.MissingLocations, hashString)
+	printMismatches("transactions out of the chain still located", report.ExtraLocations, hashString)
+	switch {
+	case report.Consistent():
+		fmt.Println("the stores match the blocks")
+	case reindex:
+		fmt.Println("the stores are rebuilt from the blocks")
+	default:
+		return errors.New("the stores do not match the blocks, run node chain reindex to rebuild them")
+	}
+	return nil
+}
+
+func printMismatches(title string, keys []string, format func(string) string) {
+	if len(keys) == 0 {
+		return
+	}
+	fmt.Printf("%d %s:\n", len(keys), title)
+	for _, key := range keys {
+		fmt.Printf("  %s\n", format(key))
+	}
+}
+
+func hashString(hash string) string {
+	return hex.EncodeToString([]byte(hash))
+}
+
+func utxoKeyString(key string) string {
+	txHash, index, err := secure.ParseUTXOKey(key)
+	if err != nil {
+		return key
+	}
+	return fmt.Sprintf("%x:%d", txHash, index)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuriykis/microblocknet/node/service"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+// storeConfigFromEnv reads the store backend, its location and the sizes
+// of its caches from the environment, a zero size disables the cache
+func storeConfigFromEnv() (store.Config, error) {
+	conf := store.Config{
+		Type:          os.Getenv("STORE_TYPE"),
+		Path:          os.Getenv("STORE_PATH"),
+		MongoURI:      os.Getenv("MONGO_URI"),
+		MongoDatabase: os.Getenv("MONGO_DB"),
+		Cache:         store.DefaultCacheConfig(),
+	}
+	if conf.Type == "" {
+		conf.Type = defaultStoreType
+	}
+	if conf.Path == "" {
+		conf.Path = defaultStorePath
+	}
+	if err := intFromEnv("STORE_CACHE_BLOCKS", &conf.Cache.Blocks); err != nil {
+		return conf, err
+	}
+	if err := intFromEnv("STORE_CACHE_TXS", &conf.Cache.Txs); err != nil {
+		return conf, err
+	}
+	if err := intFromEnv("STORE_CACHE_UTXOS", &conf.Cache.UTXOs); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// mempoolConfigFromEnv reads the mempool policy from the environment,
+// the variables that are not set keep their default values
+func mempoolConfigFromEnv() (service.MempoolConfig, error) {
+	conf := service.DefaultMempoolConfig()
+	if err := boolFromEnv("MEMPOOL_RBF", &conf.ReplaceByFee); err != nil {
+		return conf, err
+	}
+	if err := intFromEnv("MEMPOOL_MAX_BYTES", &conf.MaxBytes); err != nil {
+		return conf, err
+	}
+	if err := intFromEnv("MEMPOOL_MAX_TXS", &conf.MaxTxs); err != nil {
+		return conf, err
+	}
+	if err := durationFromEnv("MEMPOOL_EXPIRY", &conf.Expiry); err != nil {
+		return conf, err
+	}
+	if err := intFromEnv("MEMPOOL_MAX_ANCESTORS", &conf.MaxAncestors); err != nil {
+		return conf, err
+	}
+	if err := intFromEnv("MEMPOOL_MAX_DESCENDANTS", &conf.MaxDescendants); err != nil {
+		return conf, err
+	}
+	if err := policyFromEnv(&conf.Policy); err != nil {
+		return conf, err
+	}
+	conf.File = os.Getenv("MEMPOOL_FILE")
+	if conf.File == "" {
+		conf.File = defaultMempoolFile
+	}
+	return conf, nil
+}
+
+// policyFromEnv reads the standardness rules, POLICY_OUTPUT_TYPES
+// is a comma separated list of the relayed output types
+func policyFromEnv(p *service.Policy) error {
+	if err := int64FromEnv("POLICY_DUST_THRESHOLD", &p.DustThreshold); err != nil {
+		return err
+	}
+	if err := intFromEnv("POLICY_MAX_TX_SIZE", &p.MaxTxSize); err != nil {
+		return err
+	}
+	if err := intFromEnv("POLICY_MAX_INPUTS", &p.MaxInputs); err != nil {
+		return err
+	}
+	if err := intFromEnv("POLICY_MAX_OUTPUTS", &p.MaxOutputs); err != nil {
+		return err
+	}
+	if err := int64FromEnv("POLICY_MIN_FEE_RATE", &p.MinFeeRate); err != nil {
+		return err
+	}
+	if s := os.Getenv("POLICY_OUTPUT_TYPES"); s != "" {
+		p.AllowedOutputTypes = nil
+		for _, name := range strings.Split(s, ",") {
+			t, err := service.ParseOutputType(strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			p.AllowedOutputTypes = append(p.AllowedOutputTypes, t)
+		}
+	}
+	return nil
+}
+
+// dandelionConfigFromEnv reads the private transaction relay settings
+func dandelionConfigFromEnv() (service.DandelionConfig, error) {
+	conf := service.DefaultDandelionConfig()
+	if err := boolFromEnv("DANDELION", &conf.Enabled); err != nil {
+		return conf, err
+	}
+	if err := float64FromEnv("DANDELION_FLUFF_PROBABILITY", &conf.FluffProbability); err != nil {
+		return conf, err
+	}
+	if err := durationFromEnv("DANDELION_EPOCH", &conf.Epoch); err != nil {
+		return conf, err
+	}
+	if err := durationFromEnv("DANDELION_EMBARGO_TIMEOUT", &conf.EmbargoTimeout); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+func boolFromEnv(name string, v *bool) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = b
+	return nil
+}
+
+func intFromEnv(name string, v *int) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = i
+	return nil
+}
+
+func int64FromEnv(name string, v *int64) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*v = i
+	return nil
+}
+
+func float64FromEnv(name string, v *float64) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v = f
+	return nil
+}
+
+func durationFromEnv(name string, v *time.Duration) error {
+	s := os.Getenv(name)
+	if s == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = d
+	return nil
+}
@@ -6,10 +6,13 @@ require (
 	github.com/cbergoon/merkletree v0.2.0
 	github.com/confluentinc/confluent-kafka-go/v2 v2.3.0
 	github.com/hashicorp/consul/api v1.26.1
+	github.com/hashicorp/golang-lru v0.5.4
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/prometheus/client_golang v1.17.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.8.4
 	github.com/yuriykis/microblocknet/common v0.0.0-20231111140205-8159a58c80c1
+	go.etcd.io/bbolt v1.3.8
 )
 
 require (
@@ -24,7 +27,6 @@ require (
 	github.com/hashicorp/go-immutable-radix v1.3.1 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/hashicorp/go-rootcerts v1.0.2 // indirect
-	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/hashicorp/serf v0.10.1 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -55,7 +57,7 @@ require (
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20230822172742-b8732ec3820d // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230822172742-b8732ec3820d
 	google.golang.org/grpc v1.59.0
 	google.golang.org/protobuf v1.31.0
 	gopkg.in/yaml.v3 v3.0.1 // indirect
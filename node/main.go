@@ -3,19 +3,23 @@ package main
 import (
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/yuriykis/microblocknet/node/boot"
 )
 
 const (
-	defaultListenAddr = ":4000"
-	defaultAPIAddr    = ":8000"
-	defaultGateway    = "http://localhost:6000"
-	defaultConsulAddr = "127.0.0.1:10000"
-	defaultStoreType  = "memory"
+	defaultListenAddr  = ":4000"
+	defaultAPIAddr     = ":8000"
+	defaultGateway     = "http://localhost:6000"
+	defaultConsulAddr  = "127.0.0.1:10000"
+	defaultStoreType   = "memory"
+	defaultMempoolFile = "mempool.dat"
+	defaultStorePath   = "chain.db"
 )
 
 const godSeed = "41b84a2eff9a47393471748fbbdff9d20c14badab3d2de59fd8b5e98edd34d1c577c4c3515c6c19e5b9fdfba39528b1be755aae4d6a75fc851d3a17fbf51f1bc"
@@ -27,6 +31,13 @@ func main() {
 		return
 	}
 
+	if ok, err := runCommand(os.Args[1:]); ok {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var (
 		listenAddr        = os.Getenv("LISTEN_ADDR")
 		apiListenAddr     = os.Getenv("API_LISTEN_ADDR")
@@ -34,7 +45,6 @@ func main() {
 		consulServiceAddr = os.Getenv("CONSUL_SERVICE_ADDR")
 		bootstrapNodesVar = os.Getenv("BOOTSTRAP_NODES")
 		isMinerStr        = os.Getenv("IS_MINER")
-		storeType         = os.Getenv("STORE_TYPE")
 		bootstrapNodes    []string
 	)
 	if listenAddr == "" {
@@ -52,10 +62,6 @@ func main() {
 		consulServiceAddr = defaultConsulAddr
 	}
 
-	if storeType == "" {
-		storeType = defaultStoreType
-	}
-
 	isMiner, err := strconv.ParseBool(isMinerStr)
 	if err != nil {
 		log.Fatal(err)
@@ -65,21 +71,68 @@ func main() {
 		gatewayAddress = "http://localhost:6000"
 	}
 
+	mempoolConf, err := mempoolConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dandelionConf, err := dandelionConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storeConf, err := storeConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the node keeps the bodies of the PRUNE_DEPTH most recent blocks only
+	pruneDepth := 0
+	if err := intFromEnv("PRUNE_DEPTH", &pruneDepth); err != nil {
+		log.Fatal(err)
+	}
+
+	// a node joining a chain started before the headers carried the utxo root
+	// requires the root from UTXO_ROOT_HEIGHT, the height the chain activated it at
+	utxoRootHeight := 0
+	if err := intFromEnv("UTXO_ROOT_HEIGHT", &utxoRootHeight); err != nil {
+		log.Fatal(err)
+	}
+
 	nb := NewNodeBuilder(
 		listenAddr,
 		apiListenAddr,
 		gatewayAddress,
 		consulServiceAddr,
 		bootstrapNodes,
-		storeType,
+		storeConf.Type,
 		isMiner,
-	)
+	).WithMempoolConfig(mempoolConf).
+		WithDandelionConfig(dandelionConf).
+		WithStoreConfig(storeConf).
+		WithPruneDepth(pruneDepth).
+		WithUTXORootHeight(utxoRootHeight)
 	err = nb.Build()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Fatal(boot.BootNode(nb.bootOpts, nb.node, nb.apiServer, nb.grpcServer))
+	// stop the node gracefully on interrupt, so the mempool is saved before exit
+	stoppedCh := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		<-sigCh
+		if err := boot.StopNode(nb.node, nb.apiServer, nb.grpcServer); err != nil {
+			log.Println(err)
+		}
+		close(stoppedCh)
+	}()
+
+	if err := boot.BootNode(nb.bootOpts, nb.node, nb.apiServer, nb.grpcServer); err != nil {
+		log.Fatal(err)
+	}
+	<-stoppedCh
 }
 
 // for debugging
@@ -13,8 +13,11 @@ import (
 type NodeServer interface {
 	Handshake(ctx context.Context, v *proto.Version) (*proto.Version, error)
 	NewTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
+	StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
 	NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, error)
 	GetBlocks(ctx context.Context, v *proto.Version) (*proto.Blocks, error)
+	GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error)
+	GetMempoolTransactions(ctx context.Context, h *proto.TxHashes) (*proto.Transactions, error)
 	String() string
 }
 
@@ -51,6 +54,10 @@ func (s *GRPCNodeServer) NewTransaction(ctx context.Context, t *proto.Transactio
 	return s.node.NewTransaction(ctx, t)
 }
 
+func (s *GRPCNodeServer) StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error) {
+	return s.node.StemTransaction(ctx, t)
+}
+
 func (s *GRPCNodeServer) NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, error) {
 	return s.node.NewBlock(ctx, b)
 }
@@ -59,6 +66,17 @@ func (s *GRPCNodeServer) GetBlocks(ctx context.Context, v *proto.Version) (*prot
 	return s.node.GetBlocks(ctx, v)
 }
 
+func (s *GRPCNodeServer) GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error) {
+	return s.node.GetMempool(ctx, v)
+}
+
+func (s *GRPCNodeServer) GetMempoolTransactions(
+	ctx context.Context,
+	h *proto.TxHashes,
+) (*proto.Transactions, error) {
+	return s.node.GetMempoolTransactions(ctx, h)
+}
+
 func (s *GRPCNodeServer) String() string {
 	return s.nodeListenAddr[len(s.nodeListenAddr)-4:]
 }
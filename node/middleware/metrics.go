@@ -20,6 +20,10 @@ type MetricsMiddleware struct {
 	newTransactionLatency prometheus.Histogram
 	newTransactionError   prometheus.Counter
 
+	stemTransactionCount   prometheus.Counter
+	stemTransactionLatency prometheus.Histogram
+	stemTransactionError   prometheus.Counter
+
 	newBlockCount   prometheus.Counter
 	newBlockLatency prometheus.Histogram
 	newBlockError   prometheus.Counter
@@ -28,6 +32,14 @@ type MetricsMiddleware struct {
 	getBlocksLatency prometheus.Histogram
 	getBlocksError   prometheus.Counter
 
+	getMempoolCount   prometheus.Counter
+	getMempoolLatency prometheus.Histogram
+	getMempoolError   prometheus.Counter
+
+	getMempoolTransactionsCount   prometheus.Counter
+	getMempoolTransactionsLatency prometheus.Histogram
+	getMempoolTransactionsError   prometheus.Counter
+
 	next NodeServer
 }
 
@@ -65,6 +77,23 @@ func NewMetricsMiddleware(next NodeServer) NodeServer {
 	},
 	)
 
+	stemTransactionCount := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("stem_transaction_count_%s", next),
+		Help: "Number of stem transactions",
+	},
+	)
+	stemTransactionLatency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    fmt.Sprintf("stem_transaction_latency_%s", next),
+		Help:    "Latency of stem transactions",
+		Buckets: prometheus.LinearBuckets(0, 1, 10),
+	},
+	)
+	stemTransactionError := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("stem_transaction_error_%s", next),
+		Help: "Number of stem transaction errors",
+	},
+	)
+
 	newBlockCount := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: fmt.Sprintf("new_block_count_%s", next),
 		Help: "Number of new blocks",
@@ -99,6 +128,40 @@ func NewMetricsMiddleware(next NodeServer) NodeServer {
 	},
 	)
 
+	getMempoolCount := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("get_mempool_count_%s", next),
+		Help: "Number of get mempool",
+	},
+	)
+	getMempoolLatency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    fmt.Sprintf("get_mempool_latency_%s", next),
+		Help:    "Latency of get mempool",
+		Buckets: prometheus.LinearBuckets(0, 1, 10),
+	},
+	)
+	getMempoolError := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("get_mempool_error_%s", next),
+		Help: "Number of get mempool errors",
+	},
+	)
+
+	getMempoolTransactionsCount := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("get_mempool_transactions_count_%s", next),
+		Help: "Number of get mempool transactions",
+	},
+	)
+	getMempoolTransactionsLatency := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    fmt.Sprintf("get_mempool_transactions_latency_%s", next),
+		Help:    "Latency of get mempool transactions",
+		Buckets: prometheus.LinearBuckets(0, 1, 10),
+	},
+	)
+	getMempoolTransactionsError := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("get_mempool_transactions_error_%s", next),
+		Help: "Number of get mempool transactions errors",
+	},
+	)
+
 	prometheus.MustRegister(handshakeCount)
 	prometheus.MustRegister(handshakeLatency)
 	prometheus.MustRegister(handshakeErrorCount)
@@ -107,6 +170,10 @@ func NewMetricsMiddleware(next NodeServer) NodeServer {
 	prometheus.MustRegister(newTransactionLatency)
 	prometheus.MustRegister(newTransactionError)
 
+	prometheus.MustRegister(stemTransactionCount)
+	prometheus.MustRegister(stemTransactionLatency)
+	prometheus.MustRegister(stemTransactionError)
+
 	prometheus.MustRegister(newBlockCount)
 	prometheus.MustRegister(newBlockLatency)
 	prometheus.MustRegister(newBlockError)
@@ -115,6 +182,14 @@ func NewMetricsMiddleware(next NodeServer) NodeServer {
 	prometheus.MustRegister(getBlocksLatency)
 	prometheus.MustRegister(getBlocksError)
 
+	prometheus.MustRegister(getMempoolCount)
+	prometheus.MustRegister(getMempoolLatency)
+	prometheus.MustRegister(getMempoolError)
+
+	prometheus.MustRegister(getMempoolTransactionsCount)
+	prometheus.MustRegister(getMempoolTransactionsLatency)
+	prometheus.MustRegister(getMempoolTransactionsError)
+
 	return &MetricsMiddleware{
 		handshakeCount:      handshakeCount,
 		handshakeLatency:    handshakeLatency,
@@ -124,6 +199,10 @@ func NewMetricsMiddleware(next NodeServer) NodeServer {
 		newTransactionLatency: newTransactionLatency,
 		newTransactionError:   newTransactionError,
 
+		stemTransactionCount:   stemTransactionCount,
+		stemTransactionLatency: stemTransactionLatency,
+		stemTransactionError:   stemTransactionError,
+
 		newBlockCount:   newBlockCount,
 		newBlockLatency: newBlockLatency,
 		newBlockError:   newBlockError,
@@ -132,6 +211,14 @@ func NewMetricsMiddleware(next NodeServer) NodeServer {
 		getBlocksLatency: getBlocksLatency,
 		getBlocksError:   getBlocksError,
 
+		getMempoolCount:   getMempoolCount,
+		getMempoolLatency: getMempoolLatency,
+		getMempoolError:   getMempoolError,
+
+		getMempoolTransactionsCount:   getMempoolTransactionsCount,
+		getMempoolTransactionsLatency: getMempoolTransactionsLatency,
+		getMempoolTransactionsError:   getMempoolTransactionsError,
+
 		next: next,
 	}
 }
@@ -161,6 +248,20 @@ func (m *MetricsMiddleware) NewTransaction(
 	return m.next.NewTransaction(ctx, t)
 }
 
+func (m *MetricsMiddleware) StemTransaction(
+	ctx context.Context,
+	t *proto.Transaction,
+) (_ *proto.Transaction, err error) {
+	defer func(begin time.Time) {
+		m.stemTransactionCount.Inc()
+		m.stemTransactionLatency.Observe(time.Since(begin).Seconds())
+		if err != nil {
+			m.stemTransactionError.Inc()
+		}
+	}(time.Now())
+	return m.next.StemTransaction(ctx, t)
+}
+
 func (m *MetricsMiddleware) NewBlock(ctx context.Context, b *proto.Block) (_ *proto.Block, err error) {
 	defer func(begin time.Time) {
 		m.newBlockCount.Inc()
@@ -183,6 +284,31 @@ func (m *MetricsMiddleware) GetBlocks(ctx context.Context, v *proto.Version) (_
 	return m.next.GetBlocks(ctx, v)
 }
 
+func (m *MetricsMiddleware) GetMempool(ctx context.Context, v *proto.Version) (_ *proto.TxHashes, err error) {
+	defer func(begin time.Time) {
+		m.getMempoolCount.Inc()
+		m.getMempoolLatency.Observe(time.Since(begin).Seconds())
+		if err != nil {
+			m.getMempoolError.Inc()
+		}
+	}(time.Now())
+	return m.next.GetMempool(ctx, v)
+}
+
+func (m *MetricsMiddleware) GetMempoolTransactions(
+	ctx context.Context,
+	h *proto.TxHashes,
+) (_ *proto.Transactions, err error) {
+	defer func(begin time.Time) {
+		m.getMempoolTransactionsCount.Inc()
+		m.getMempoolTransactionsLatency.Observe(time.Since(begin).Seconds())
+		if err != nil {
+			m.getMempoolTransactionsError.Inc()
+		}
+	}(time.Now())
+	return m.next.GetMempoolTransactions(ctx, h)
+}
+
 func (m *MetricsMiddleware) String() string {
 	return fmt.Sprintf("metrics(%s)", m.next)
 }
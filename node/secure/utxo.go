@@ -1,7 +1,25 @@
 package secure
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 func MakeUTXOKey(txHash []byte, index int) string {
 	return fmt.Sprintf("%s:%d", txHash, index)
 }
+
+// ParseUTXOKey splits the key made by MakeUTXOKey, the hash may contain
+// the separator itself, so the index follows the last one
+func ParseUTXOKey(key string) ([]byte, int, error) {
+	i := strings.LastIndex(key, ":")
+	if i < 0 {
+		return nil, 0, fmt.Errorf("invalid utxo key %q", key)
+	}
+	index, err := strconv.Atoi(key[i+1:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid utxo key %q: %w", key, err)
+	}
+	return []byte(key[:i]), index, nil
+}
@@ -0,0 +1,20 @@
+package secure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUTXOKey(t *testing.T) {
+	txHash := []byte("hash:with:separators")
+	txHashOut, index, err := ParseUTXOKey(MakeUTXOKey(txHash, 12))
+	assert.Nil(t, err)
+	assert.Equal(t, txHash, txHashOut)
+	assert.Equal(t, 12, index)
+
+	_, _, err = ParseUTXOKey("no separator")
+	assert.NotNil(t, err)
+	_, _, err = ParseUTXOKey("hash:index")
+	assert.NotNil(t, err)
+}
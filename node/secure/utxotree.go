@@ -0,0 +1,112 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+)
+
+// The UTXO set is committed to by the root of a sparse Merkle tree. The path
+// of an output is the hash of its UTXO key, a subtree holding a single output
+// is the leaf of the output itself, so the tree of the same set is the same
+// whatever the order the outputs were added in. The empty subtree hashes
+// to the zero hash.
+
+const utxoTreeDepth = sha256.Size * 8
+
+var (
+	// EmptyUTXOTreeHash is the root of the empty UTXO set
+	EmptyUTXOTreeHash = make([]byte, sha256.Size)
+
+	ErrUTXOProofInvalid = errors.New("utxo proof does not match the root")
+)
+
+// UTXOTreeKey returns the path of the output in the UTXO tree
+func UTXOTreeKey(txHash []byte, outIndex int) []byte {
+	key := sha256.Sum256([]byte(MakeUTXOKey(txHash, outIndex)))
+	return key[:]
+}
+
+// UTXOTreeBit returns the branch taken by the path at the depth, 0 for the left one
+func UTXOTreeBit(key []byte, depth int) byte {
+	return (key[depth/8] >> (7 - depth%8)) & 1
+}
+
+// HashUTXOTreeValue hashes the output kept by the leaf
+func HashUTXOTreeValue(output *proto.TxOutput) []byte {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(output.GetValue())))
+	h.Write(output.GetAddress())
+	return h.Sum(nil)
+}
+
+// HashUTXOTreeLeaf hashes the leaf of the output at the path
+func HashUTXOTreeLeaf(key, valueHash []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(key)
+	h.Write(valueHash)
+	return h.Sum(nil)
+}
+
+// HashUTXOTreeNode hashes the inner node of the subtrees, a nil hash is the empty subtree
+func HashUTXOTreeNode(left, right []byte) []byte {
+	if left == nil {
+		left = EmptyUTXOTreeHash
+	}
+	if right == nil {
+		right = EmptyUTXOTreeHash
+	}
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// VerifyUTXOProof checks the proof against the UTXO tree root of a block header.
+// It returns true when the proof shows the output in the set and false when it
+// shows the outpoint is not there, either spent or never created.
+func VerifyUTXOProof(root []byte, proof *proto.UTXOProof) (bool, error) {
+	if len(proof.Siblings) > utxoTreeDepth {
+		return false, fmt.Errorf("%w: %d siblings", ErrUTXOProofInvalid, len(proof.Siblings))
+	}
+	key := UTXOTreeKey(proof.TxHash, int(proof.OutIndex))
+	depth := len(proof.Siblings)
+	var hash []byte
+	switch {
+	case proof.Utxo != nil:
+		if !bytes.Equal(proof.Utxo.TxHash, proof.TxHash) || proof.Utxo.OutIndex != proof.OutIndex {
+			return false, fmt.Errorf("%w: the output is not the one of the outpoint", ErrUTXOProofInvalid)
+		}
+		hash = HashUTXOTreeLeaf(key, HashUTXOTreeValue(proof.Utxo.Output))
+	case len(proof.LeafKey) > 0:
+		// the path ends at the leaf of another output sharing the prefix
+		if len(proof.LeafKey) != len(key) || bytes.Equal(proof.LeafKey, key) {
+			return false, fmt.Errorf("%w: the leaf is not of another output", ErrUTXOProofInvalid)
+		}
+		for d := 0; d < depth; d++ {
+			if UTXOTreeBit(proof.LeafKey, d) != UTXOTreeBit(key, d) {
+				return false, fmt.Errorf("%w: the leaf is off the path", ErrUTXOProofInvalid)
+			}
+		}
+		hash = HashUTXOTreeLeaf(proof.LeafKey, proof.LeafValueHash)
+	default:
+		hash = EmptyUTXOTreeHash
+	}
+	for d := depth - 1; d >= 0; d-- {
+		if UTXOTreeBit(key, d) == 0 {
+			hash = HashUTXOTreeNode(hash, proof.Siblings[d])
+		} else {
+			hash = HashUTXOTreeNode(proof.Siblings[d], hash)
+		}
+	}
+	if !bytes.Equal(hash, root) {
+		return false, ErrUTXOProofInvalid
+	}
+	return proof.Utxo != nil, nil
+}
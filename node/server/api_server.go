@@ -9,8 +9,8 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/yuriykis/microblocknet/common/requests"
-	"github.com/yuriykis/microblocknet/node/client"
 	"github.com/yuriykis/microblocknet/node/service"
+	"github.com/yuriykis/microblocknet/node/store"
 	grpcPeer "google.golang.org/grpc/peer"
 )
 
@@ -21,10 +21,24 @@ func StartApiTrasport(s *ApiNodeServer) error {
 			makeHTTPHandlerFunc(handleGetBlockByHeight(s.node))(w, r)
 		case "/utxo":
 			makeHTTPHandlerFunc(handleGetUTXOsByAddress(s.node))(w, r)
+		case "/utxo/proof":
+			makeHTTPHandlerFunc(handleGetUTXOProof(s.node))(w, r)
 		case "/transaction":
-			makeHTTPHandlerFunc(handleNewTransaction(s.node, s.grpcClient))(w, r)
+			makeHTTPHandlerFunc(handleNewTransaction(s.node))(w, r)
+		case "/transaction/validate":
+			makeHTTPHandlerFunc(handleValidateTransaction(s.node))(w, r)
+		case "/transaction/status":
+			makeHTTPHandlerFunc(handleGetTransactionStatus(s.node))(w, r)
+		case "/address/transactions":
+			makeHTTPHandlerFunc(handleGetAddressTransactions(s.node))(w, r)
 		case "/height":
 			makeHTTPHandlerFunc(handleGetCurrentHeight(s.node))(w, r)
+		case "/mempool":
+			makeHTTPHandlerFunc(handleGetMempool(s.node))(w, r)
+		case "/mempool/transaction":
+			makeHTTPHandlerFunc(handleGetMempoolTransaction(s.node))(w, r)
+		case "/mempool/stats":
+			makeHTTPHandlerFunc(handleGetMempoolStats(s.node))(w, r)
 		case "/healthcheck":
 			makeHTTPHandlerFunc(handleHealthCheck(s.node))(w, r)
 		case "/metrics":
@@ -49,26 +63,19 @@ func StopApiTransport(s *ApiNodeServer) error {
 type ApiNodeServer struct {
 	apiListenAddr string
 	httpServer    *http.Server
-	grpcClient    *client.GRPCClient
 	node          service.Api
 }
 
 func NewApiServer(
-	grpcListenAddress string,
 	apiListenAddr string,
 	node service.Api,
 ) (*ApiNodeServer, error) {
 	httpServer := &http.Server{
 		Addr: apiListenAddr,
 	}
-	grpcClient, err := client.NewGRPCClient(grpcListenAddress)
-	if err != nil {
-		return nil, err
-	}
 	return &ApiNodeServer{
 		apiListenAddr: apiListenAddr,
 		httpServer:    httpServer,
-		grpcClient:    grpcClient,
 		node:          node,
 	}, nil
 }
@@ -162,9 +169,11 @@ func handleGetUTXOsByAddress(node service.Api) HTTPFunc {
 	}
 }
 
-func handleNewTransaction(node service.Api, c *client.GRPCClient) HTTPFunc {
+// handleGetUTXOProof proves the outpoint is in the UTXO set of the tip or is not,
+// the header lets the client check the proof against the root it commits to
+func handleGetUTXOProof(node service.Api) HTTPFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		req := requests.NewTransactionRequest{}
+		req := requests.GetUTXOProofRequest{}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			fmt.Println(err)
 			return APIError{
@@ -172,17 +181,52 @@ func handleNewTransaction(node service.Api, c *client.GRPCClient) HTTPFunc {
 				Err:  fmt.Errorf("failed to decode request body: %w", err),
 			}
 		}
-		ctx := grpcPeer.NewContext(context.Background(), &grpcPeer.Peer{
-			Addr: &net.IPAddr{
-				IP: net.ParseIP(""),
-			},
+		proof, err := node.Chain().UTXOProof(req.TxHash, req.OutIndex)
+		if err != nil {
+			fmt.Println(err)
+			return APIError{
+				Code: http.StatusInternalServerError,
+				Err:  fmt.Errorf("failed to prove utxo: %w", err),
+			}
+		}
+		block, err := node.Chain().GetBlockByHeight(int(proof.Height))
+		if err != nil {
+			fmt.Println(err)
+			return APIError{
+				Code: http.StatusInternalServerError,
+				Err:  fmt.Errorf("failed to get block by height: %w", err),
+			}
+		}
+		return writeJSON(w, http.StatusOK, requests.GetUTXOProofResponse{
+			Proof:  proof,
+			Header: block.Header,
 		})
-		tx, err := c.NewTransaction(ctx, req.Transaction)
+	}
+}
+
+// handleNewTransaction submits the transaction created by the node's user,
+// it is relayed privately if the node has the stem phase enabled
+func handleNewTransaction(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		req := requests.NewTransactionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fmt.Println(err)
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("failed to decode request body: %w", err),
+			}
+		}
+		tx, err := node.SubmitTransaction(req.Transaction)
+		if rejectErr, ok := service.RejectFromError(err); ok {
+			return writeJSON(w, http.StatusBadRequest, requests.NewTransactionResponse{
+				Reject: rejectErr.TxReject(),
+			})
+		}
 		if err != nil {
 			fmt.Println(err)
 			return APIError{
 				Code: http.StatusInternalServerError,
-				Err:  fmt.Errorf("failed to get utxos by address: %w", err),
+				Err:  fmt.Errorf("failed to submit transaction: %w", err),
 			}
 		}
 
@@ -192,6 +236,108 @@ func handleNewTransaction(node service.Api, c *client.GRPCClient) HTTPFunc {
 	}
 }
 
+const (
+	defaultAddressTxsPageSize = 100
+	maxAddressTxsPageSize     = 1000
+)
+
+// handleGetAddressTransactions returns a page of the confirmed transactions
+// paying to the address or spending its outputs, the most recent first
+func handleGetAddressTransactions(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		req := requests.GetAddressTransactionsRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("failed to decode request body: %w", err),
+			}
+		}
+		if len(req.Address) == 0 || req.Offset < 0 || req.Limit < 0 {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("address is required and the offset and limit must not be negative"),
+			}
+		}
+		limit := req.Limit
+		if limit == 0 {
+			limit = defaultAddressTxsPageSize
+		}
+		if limit > maxAddressTxsPageSize {
+			limit = maxAddressTxsPageSize
+		}
+		entries, err := node.Chain().AddressHistory(req.Address, store.Page{
+			Offset: req.Offset,
+			Limit:  limit,
+		})
+		if err != nil {
+			return APIError{
+				Code: http.StatusInternalServerError,
+				Err:  fmt.Errorf("failed to get address transactions: %w", err),
+			}
+		}
+		res := requests.GetAddressTransactionsResponse{
+			Transactions: make([]*requests.AddressTransaction, 0, len(entries)),
+		}
+		for _, e := range entries {
+			res.Transactions = append(res.Transactions, &requests.AddressTransaction{
+				Hash:     e.TxHash,
+				Height:   e.Height,
+				Position: e.Position,
+				Received: e.Received,
+				Sent:     e.Sent,
+			})
+		}
+		if len(entries) == limit {
+			res.NextOffset = req.Offset + limit
+		}
+		return writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// handleGetTransactionStatus looks the transaction up in the main chain and then
+// in the mempool, a transaction still in the dandelion stem phase is reported
+// as unknown, so the status does not reveal the node that relays it
+func handleGetTransactionStatus(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		req := requests.GetTransactionStatusRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("failed to decode request body: %w", err),
+			}
+		}
+		if len(req.Hash) == 0 {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("hash is required"),
+			}
+		}
+		loc, err := node.Chain().TxLocation(string(req.Hash))
+		if err != nil {
+			return APIError{
+				Code: http.StatusInternalServerError,
+				Err:  fmt.Errorf("failed to get transaction location: %w", err),
+			}
+		}
+		if loc != nil {
+			return writeJSON(w, http.StatusOK, requests.GetTransactionStatusResponse{
+				Status:        requests.TxStatusConfirmed,
+				Confirmations: node.Chain().Confirmations(loc),
+				BlockHash:     loc.BlockHash,
+				Height:        loc.Height,
+				Position:      loc.Position,
+			})
+		}
+		status := requests.TxStatusUnknown
+		if node.Mempool().Transaction(req.Hash) != nil {
+			status = requests.TxStatusPending
+		}
+		return writeJSON(w, http.StatusOK, requests.GetTransactionStatusResponse{
+			Status: status,
+		})
+	}
+}
+
 func handleGetCurrentHeight(node service.Api) HTTPFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		height := node.Chain().Height()
@@ -201,6 +347,70 @@ func handleGetCurrentHeight(node service.Api) HTTPFunc {
 	}
 }
 
+// handleValidateTransaction runs the admission checks on the transaction
+// without adding it to the mempool or relaying it to the peers
+func handleValidateTransaction(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		req := requests.ValidateTransactionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("failed to decode request body: %w", err),
+			}
+		}
+		if req.Transaction == nil {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("transaction is required"),
+			}
+		}
+		res, err := node.Mempool().Validate(node.Chain(), req.Transaction)
+		if err != nil {
+			return APIError{
+				Code: http.StatusInternalServerError,
+				Err:  err,
+			}
+		}
+		return writeJSON(w, http.StatusOK, res)
+	}
+}
+
+func handleGetMempool(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return writeJSON(w, http.StatusOK, requests.GetMempoolResponse{
+			Transactions: node.Mempool().Transactions(),
+		})
+	}
+}
+
+func handleGetMempoolTransaction(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		req := requests.GetMempoolTransactionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return APIError{
+				Code: http.StatusBadRequest,
+				Err:  fmt.Errorf("failed to decode request body: %w", err),
+			}
+		}
+		tx := node.Mempool().Transaction(req.Hash)
+		if tx == nil {
+			return APIError{
+				Code: http.StatusNotFound,
+				Err:  fmt.Errorf("transaction %x not found in mempool", req.Hash),
+			}
+		}
+		return writeJSON(w, http.StatusOK, requests.GetMempoolTransactionResponse{
+			Transaction: tx,
+		})
+	}
+}
+
+func handleGetMempoolStats(node service.Api) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return writeJSON(w, http.StatusOK, node.Mempool().Stats())
+	}
+}
+
 func handleHealthCheck(node service.Api) HTTPFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		node.Gate().SetConnected(true)
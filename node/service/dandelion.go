@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/client"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultFluffProbability = 0.1
+	defaultDandelionEpoch   = 10 * time.Minute
+	defaultEmbargoTimeout   = 30 * time.Second
+
+	// maxStemRelays is the number of peers chosen every epoch to relay the stem transactions
+	maxStemRelays = 2
+	// localSource is the source of the transactions submitted through the node API
+	localSource = ""
+
+	// every peer can stem stemTxsBurst transactions in a row
+	// and then stemTxsRate transactions per second
+	stemTxsRate  = 10
+	stemTxsBurst = 100
+)
+
+// DandelionConfig configures the private transaction relay. In the stem phase
+// the transaction is passed to a single peer, every node on the path either passes
+// it further or starts the fluff phase, i.e. the usual broadcast to all the peers.
+type DandelionConfig struct {
+	Enabled bool
+	// FluffProbability is the chance a node fluffs all the stem transactions
+	// it receives during an epoch, so the stem is 1/FluffProbability hops long on average
+	FluffProbability float64
+	// Epoch is how long the node keeps its relays and fluff decision
+	Epoch time.Duration
+	// EmbargoTimeout is the minimum time a node waits for a stem transaction
+	// to be fluffed by another node before it fluffs the transaction itself,
+	// a random delay up to the same duration is added to it
+	EmbargoTimeout time.Duration
+}
+
+func DefaultDandelionConfig() DandelionConfig {
+	return DandelionConfig{
+		Enabled:          false,
+		FluffProbability: defaultFluffProbability,
+		Epoch:            defaultDandelionEpoch,
+		EmbargoTimeout:   defaultEmbargoTimeout,
+	}
+}
+
+// dandelion routes the stem transactions and keeps the embargo timers
+type dandelion struct {
+	conf DandelionConfig
+
+	lock       sync.Mutex
+	rand       *rand.Rand
+	epochStart time.Time
+	fluffMode  bool
+	relays     []client.Client
+	routes     map[string]client.Client // [source]relay
+	embargoes  map[string]*time.Timer   // [hash of the stem transaction]
+
+	// limits protect the node from the peers relaying too many stem transactions
+	limits *rateLimiter
+}
+
+func newDandelion(conf DandelionConfig) *dandelion {
+	return &dandelion{
+		conf:      conf,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		routes:    make(map[string]client.Client),
+		embargoes: make(map[string]*time.Timer),
+		limits:    newRateLimiter(stemTxsRate, stemTxsBurst),
+	}
+}
+
+// newStempool returns the mempool keeping the stem transactions until they are fluffed,
+// it applies the limits of the mempool, so relaying a transaction in the stem phase
+// costs the same as in the fluff phase
+func newStempool(conf MempoolConfig, base *Mempool) *Mempool {
+	stempool := NewMempool(conf)
+	stempool.base = base
+	return stempool
+}
+
+// route returns the peer the stem transaction received from source is passed to,
+// or false if the node should fluff the transaction. All the transactions
+// from the same source take the same route during an epoch, so the peers
+// cannot learn more by sending many transactions through the node.
+func (d *dandelion) route(source string, peers []client.Client, now time.Time) (client.Client, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.epochStart.IsZero() || now.Sub(d.epochStart) > d.conf.Epoch {
+		d.newEpoch(now)
+	}
+	// the local transactions are never fluffed right away,
+	// otherwise the node would be the first one to broadcast them
+	if d.fluffMode && source != localSource {
+		return nil, false
+	}
+	d.relays = connectedRelays(d.relays, peers)
+	if len(d.relays) < maxStemRelays {
+		d.pickRelays(peers)
+	}
+	if len(d.relays) == 0 {
+		return nil, false
+	}
+	relay, ok := d.routes[source]
+	if !ok || !containsClient(d.relays, relay) {
+		relay = d.relays[d.rand.Intn(len(d.relays))]
+		d.routes[source] = relay
+	}
+	return relay, true
+}
+
+// newEpoch draws the fluff mode and forgets the relays, the caller must hold the lock
+func (d *dandelion) newEpoch(now time.Time) {
+	d.epochStart = now
+	d.fluffMode = d.rand.Float64() < d.conf.FluffProbability
+	d.relays = nil
+	d.routes = make(map[string]client.Client)
+}
+
+// pickRelays adds random peers to the relays, the caller must hold the lock
+func (d *dandelion) pickRelays(peers []client.Client) {
+	for _, i := range d.rand.Perm(len(peers)) {
+		if len(d.relays) >= maxStemRelays {
+			return
+		}
+		if !containsClient(d.relays, peers[i]) {
+			d.relays = append(d.relays, peers[i])
+		}
+	}
+}
+
+// embargo starts the timer fluffing the transaction if nobody else does it first,
+// it returns false if the transaction is already under embargo
+func (d *dandelion) embargo(hashTx string, fluff func()) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.embargoes[hashTx]; ok {
+		return false
+	}
+	timeout := d.conf.EmbargoTimeout
+	if timeout > 0 {
+		timeout += time.Duration(d.rand.Int63n(int64(timeout)))
+	}
+	d.embargoes[hashTx] = time.AfterFunc(timeout, func() {
+		d.lock.Lock()
+		delete(d.embargoes, hashTx)
+		d.lock.Unlock()
+		fluff()
+	})
+	return true
+}
+
+// clearEmbargo stops the timer once the transaction has been fluffed
+func (d *dandelion) clearEmbargo(hashTx string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if timer, ok := d.embargoes[hashTx]; ok {
+		timer.Stop()
+		delete(d.embargoes, hashTx)
+	}
+}
+
+func (d *dandelion) stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for hashTx, timer := range d.embargoes {
+		timer.Stop()
+		delete(d.embargoes, hashTx)
+	}
+}
+
+func connectedRelays(relays []client.Client, peers []client.Client) []client.Client {
+	connected := make([]client.Client, 0, len(relays))
+	for _, r := range relays {
+		if containsClient(peers, r) {
+			connected = append(connected, r)
+		}
+	}
+	return connected
+}
+
+func containsClient(clients []client.Client, c client.Client) bool {
+	for _, other := range clients {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmitTransaction accepts the transaction created by the node's user,
+// it starts the stem phase if the private relay is enabled
+func (n *Node) SubmitTransaction(t *proto.Transaction) (*proto.Transaction, error) {
+	if err := n.stemTransaction(t, localSource); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// StemTransaction receives the transaction in the stem phase from a peer
+func (n *Node) StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error) {
+	source, err := n.peerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dandelion.limits.allow(source, 1, time.Now()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "stem transactions relayed too often")
+	}
+	if err := n.stemTransaction(t, source); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// stemTransaction passes the transaction to the stem relay chosen for its source.
+// The transaction is not added to the mempool, so the node does not reveal it
+// until the fluff phase, it waits in the stempool instead. It is fluffed right away
+// if the private relay is disabled, the node is in the fluff mode or has no peers.
+func (n *Node) stemTransaction(t *proto.Transaction, source string) error {
+	if !n.DandelionConfig.Enabled {
+		return n.fluffTransaction(t)
+	}
+	res, err := n.Mempool().Validate(n.Chain(), t)
+	if err != nil {
+		return err
+	}
+	if !res.Valid {
+		return newRejectError(RejectCode(res.Reject.Code), res.Reject.Reason)
+	}
+	relay, ok := n.dandelion.route(source, n.nm.peers.clients(), time.Now())
+	if !ok {
+		return n.fluffTransaction(t)
+	}
+	if err := n.stempool.Admit(n.Chain(), t); err != nil {
+		if rejectErr, ok := RejectFromError(err); ok && rejectErr.Code == RejectDuplicate {
+			// the transaction has already passed the node, relaying it again would make a loop
+			return nil
+		}
+		return err
+	}
+	hashTx := secure.HashTransaction(t)
+	if !n.dandelion.embargo(hashTx, func() { n.fluffEmbargoed(t) }) {
+		// the transaction has already passed the node, relaying it again would make a loop
+		return nil
+	}
+	go func() {
+		if _, err := relay.StemTransaction(context.Background(), t); err != nil {
+			// the embargo timer fluffs the transaction later
+			n.logger.Errorf("Node: %s, failed to relay stem transaction to %s: %v", n, relay, err)
+		}
+	}()
+	return nil
+}
+
+// fluffEmbargoed fluffs the stem transaction nobody has fluffed before the embargo expired
+func (n *Node) fluffEmbargoed(t *proto.Transaction) {
+	n.stempool.Remove(t)
+	if n.Mempool().Contains(t) {
+		return
+	}
+	n.logger.Infof("Node: %s, embargo expired, fluffing transaction %x", n, secure.HashTransaction(t))
+	if err := n.fluffTransaction(t); err != nil {
+		n.logger.Errorf("Node: %s, failed to fluff transaction: %v", n, err)
+	}
+}
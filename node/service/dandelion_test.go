@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/client"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordingClient is a peer remembering the transactions sent to it
+type recordingClient struct {
+	client.Client
+
+	lock  sync.Mutex
+	stem  []*proto.Transaction
+	fluff []*proto.Transaction
+}
+
+func (c *recordingClient) StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stem = append(c.stem, t)
+	return t, nil
+}
+
+func (c *recordingClient) NewTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fluff = append(c.fluff, t)
+	return t, nil
+}
+
+func (c *recordingClient) sent() (int, int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.stem), len(c.fluff)
+}
+
+func TestDandelionRoute(t *testing.T) {
+	conf := DefaultDandelionConfig()
+	conf.FluffProbability = 0
+	d := newDandelion(conf)
+	peers := []client.Client{&recordingClient{}, &recordingClient{}, &recordingClient{}}
+	now := time.Now()
+
+	relay, ok := d.route("10.0.0.1", peers, now)
+	assert.True(t, ok)
+	// the same source takes the same route during the epoch
+	for i := 0; i < 10; i++ {
+		again, _ := d.route("10.0.0.1", peers, now)
+		assert.Equal(t, relay, again)
+	}
+	assert.LessOrEqual(t, len(d.relays), maxStemRelays)
+
+	_, ok = d.route("10.0.0.1", nil, now)
+	assert.False(t, ok)
+}
+
+func TestDandelionFluffMode(t *testing.T) {
+	conf := DefaultDandelionConfig()
+	conf.FluffProbability = 1
+	d := newDandelion(conf)
+	peers := []client.Client{&recordingClient{}}
+
+	_, ok := d.route("10.0.0.1", peers, time.Now())
+	assert.False(t, ok)
+	// the local transactions always start with the stem phase
+	_, ok = d.route(localSource, peers, time.Now())
+	assert.True(t, ok)
+}
+
+func TestDandelionEmbargo(t *testing.T) {
+	conf := DefaultDandelionConfig()
+	conf.EmbargoTimeout = time.Millisecond
+	d := newDandelion(conf)
+
+	fluffed := make(chan struct{})
+	assert.True(t, d.embargo("tx", func() { close(fluffed) }))
+	assert.False(t, d.embargo("tx", func() {}))
+	select {
+	case <-fluffed:
+	case <-time.After(time.Second):
+		t.Fatal("embargo timer did not fire")
+	}
+
+	assert.True(t, d.embargo("other", func() { t.Error("cleared embargo fired") }))
+	d.clearEmbargo("other")
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestNodeStemTransaction(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	n := newTestNode(c)
+	n.DandelionConfig = DefaultDandelionConfig()
+	n.DandelionConfig.Enabled = true
+	n.DandelionConfig.EmbargoTimeout = 20 * time.Millisecond
+	n.dandelion = newDandelion(n.DandelionConfig)
+	defer n.dandelion.stop()
+	peer := &recordingClient{}
+	n.nm.peers.addPeer(peer, &proto.Version{})
+
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+	tx := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	_, err := n.SubmitTransaction(tx)
+	assert.Nil(t, err)
+	// the stem transaction is kept out of the mempool, it waits in the stempool
+	assert.False(t, n.Mempool().Contains(tx))
+	assert.True(t, n.stempool.Contains(tx))
+	assert.Eventually(t, func() bool {
+		stem, _ := peer.sent()
+		return stem == 1
+	}, time.Second, time.Millisecond)
+
+	// nobody fluffed the transaction, so the node does it when the embargo expires
+	assert.Eventually(t, func() bool {
+		_, fluff := peer.sent()
+		return n.Mempool().Contains(tx) && fluff == 1
+	}, time.Second, time.Millisecond)
+	assert.False(t, n.stempool.Contains(tx))
+}
+
+func TestNodeStemTransactionPolicy(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	n := newTestNode(c)
+	n.DandelionConfig = DefaultDandelionConfig()
+	n.DandelionConfig.Enabled = true
+	n.DandelionConfig.FluffProbability = 0
+	n.dandelion = newDandelion(n.DandelionConfig)
+	defer n.dandelion.stop()
+	n.nm.peers.addPeer(&recordingClient{}, &proto.Version{})
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, n.Mempool().Admit(c, parent))
+	parentHash := []byte(secure.HashTransaction(parent))
+
+	// the stem transaction may spend the mempool outputs
+	tx := makeSignedTx(privKey, parentHash, 1, 97000)
+	_, err := n.SubmitTransaction(tx)
+	assert.Nil(t, err)
+	assert.True(t, n.stempool.Contains(tx))
+
+	// a double spend of a stem transaction is rejected without the replace by fee
+	doubleSpend := makeSignedTx(privKey, parentHash, 1, 96000)
+	_, err = n.SubmitTransaction(doubleSpend)
+	rejectErr, ok := RejectFromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, RejectMempoolConflict, rejectErr.Code)
+	assert.False(t, n.stempool.Contains(doubleSpend))
+
+	// the confirmed transactions leave the stempool
+	n.clearMempool(&proto.Block{Transactions: []*proto.Transaction{parent, tx}})
+	assert.False(t, n.stempool.Contains(tx))
+}
+
+func TestNodeStemTransactionRateLimit(t *testing.T) {
+	n := newTestNode(chain.New(store.NewChainMemoryStore()))
+	n.DandelionConfig.Enabled = true
+	ctx := localClient{n}.ctx(context.Background())
+
+	// the invalid transactions count as well
+	for i := 0; i < stemTxsBurst; i++ {
+		_, err := n.StemTransaction(ctx, &proto.Transaction{})
+		assert.NotEqual(t, codes.ResourceExhausted, status.Code(err))
+	}
+	_, err := n.StemTransaction(ctx, &proto.Transaction{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -1,28 +1,418 @@
 package service
 
 import (
+	"container/heap"
+	"math"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
 	"github.com/yuriykis/microblocknet/node/secure"
+	pb "google.golang.org/protobuf/proto"
 )
 
+const (
+	defaultMinReplacementFeeBump = 100
+	maxReplacementEvictions      = 100
+
+	defaultMempoolMaxBytes         = 5_000_000
+	defaultMempoolMaxTxs           = 5000
+	defaultMempoolExpiry           = 24 * time.Hour
+	defaultIncrementalRelayFeeRate = 1000
+	defaultMaxAncestors            = 25
+	defaultMaxDescendants          = 25
+
+	// minFeeRateHalfLife is how fast the minimum fee rate raised
+	// by the evictions goes back to the configured one
+	minFeeRateHalfLife = 10 * time.Minute
+)
+
+// MempoolConfig holds the mempool relay policy of the node
+type MempoolConfig struct {
+	// ReplaceByFee allows a transaction conflicting with the mempool to replace
+	// the conflicting transactions and their descendants if it pays a higher fee
+	ReplaceByFee bool
+	// MinReplacementFeeBump is how much more the replacement has to pay
+	// on top of the fees of all the transactions it evicts
+	MinReplacementFeeBump int64
+	// MaxBytes and MaxTxs cap the mempool size, zero means no limit
+	MaxBytes int
+	MaxTxs   int
+	// Expiry is how long a transaction can wait in the mempool, zero means forever
+	Expiry time.Duration
+	// Policy is checked before the transaction is admitted,
+	// its MinFeeRate is also the floor of the rolling minimum fee rate
+	Policy Policy
+	// IncrementalRelayFeeRate is added to the fee rate of an evicted transaction
+	// to get the minimum fee rate required from the new transactions
+	IncrementalRelayFeeRate int64
+	// File is where the mempool is saved on shutdown and loaded from on start,
+	// empty means the mempool is not persisted
+	File string
+	// MaxAncestors and MaxDescendants limit the length of the chains of unconfirmed
+	// transactions, both counts include the transaction itself
+	MaxAncestors   int
+	MaxDescendants int
+}
+
+func DefaultMempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		ReplaceByFee:            false,
+		MinReplacementFeeBump:   defaultMinReplacementFeeBump,
+		MaxBytes:                defaultMempoolMaxBytes,
+		MaxTxs:                  defaultMempoolMaxTxs,
+		Expiry:                  defaultMempoolExpiry,
+		Policy:                  DefaultPolicy(),
+		IncrementalRelayFeeRate: defaultIncrementalRelayFeeRate,
+		MaxAncestors:            defaultMaxAncestors,
+		MaxDescendants:          defaultMaxDescendants,
+	}
+}
+
+// mempoolEntry is a transaction waiting in the mempool
+// together with the data computed when it was admitted
+type mempoolEntry struct {
+	hash  string
+	tx    *proto.Transaction
+	fee   int64
+	size  int
+	added time.Time
+	seq   uint64 // admission order, parents are always admitted before children
+
+	// parents are the hashes of the unconfirmed transactions this one spends from
+	parents map[string]struct{}
+	// descFee and descSize sum the transaction and its mempool descendants,
+	// ancFee and ancSize the transaction and its mempool ancestors,
+	// evictIndex is the position of the entry in the eviction heap
+	descFee    int64
+	descSize   int
+	ancFee     int64
+	ancSize    int
+	evictIndex int
+}
+
+// feeRate returns the fee paid per 1000 bytes of the transaction
+func (e *mempoolEntry) feeRate() int64 {
+	return feeRate(e.fee, e.size)
+}
+
+func feeRate(fee int64, size int) int64 {
+	if size == 0 {
+		return 0
+	}
+	return fee * 1000 / int64(size)
+}
+
 type Mempool struct {
-	lock sync.RWMutex
-	txs  map[string]*proto.Transaction
+	lock   sync.RWMutex
+	conf   MempoolConfig
+	txs    map[string]*mempoolEntry
+	spends map[string]string // [utxoKey]hash of the mempool transaction spending it
+	seq    uint64
+	bytes  int
+	// evictions ranks the entries for trim
+	evictions evictionHeap
+	// journal collects the entries removed while it is not nil,
+	// so a failed admission can put them back
+	journal []*mempoolEntry
+
+	// rollingMinFeeRate is raised when transactions are evicted
+	// because the mempool is full and decays back over time
+	rollingMinFeeRate float64
+	lastRollingUpdate time.Time
+
+	// base is the mempool whose outputs the transactions may spend, nil for the mempool itself,
+	// the stempool of the dandelion relay is built on top of the mempool
+	base *Mempool
 }
 
-func NewMempool() *Mempool {
+func NewMempool(conf MempoolConfig) *Mempool {
 	return &Mempool{
-		txs: make(map[string]*proto.Transaction),
+		conf:   conf,
+		txs:    make(map[string]*mempoolEntry),
+		spends: make(map[string]string),
 	}
 }
 
-func (m *Mempool) Add(tx *proto.Transaction) {
+// Admit runs the admission checks for the transaction and adds it to the mempool
+// if all of them pass. The checks and the insertion are done under the same lock,
+// so two conflicting transactions can never be admitted concurrently.
+func (m *Mempool) Admit(c *chain.Chain, tx *proto.Transaction) error {
+	return m.admit(c, tx, time.Now())
+}
+
+func (m *Mempool) admit(c *chain.Chain, tx *proto.Transaction, added time.Time) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	hashTx := string(secure.HashTransaction(tx))
-	m.txs[hashTx] = tx
+	if m.base != nil {
+		m.base.lock.RLock()
+		defer m.base.lock.RUnlock()
+	}
+
+	hashTx := secure.HashTransaction(tx)
+	if _, ok := m.txs[hashTx]; ok {
+		return newRejectError(RejectDuplicate, "transaction already exists in mempool")
+	}
+	if err := checkTransactionSanity(tx); err != nil {
+		return err
+	}
+	size := txSize(tx)
+	if err := m.conf.Policy.checkStandard(tx, size); err != nil {
+		return err
+	}
+	conflicts := m.conflictsOf(tx)
+	if err := m.checkConflicts(tx, conflicts); err != nil {
+		return err
+	}
+	evicted := m.withDescendants(conflicts)
+	fee, err := c.CheckTransaction(tx, mempoolView{m: m, replaced: evicted})
+	if err != nil {
+		return rejectFromChainError(err)
+	}
+	if err := m.checkChainLimits(tx, evicted); err != nil {
+		return err
+	}
+	if err := m.conf.Policy.checkFee(tx, size, fee); err != nil {
+		return err
+	}
+	if err := m.checkFeeRate(fee, size); err != nil {
+		return err
+	}
+	if len(evicted) > 0 {
+		if err := m.checkReplacement(fee, evicted); err != nil {
+			return err
+		}
+	}
+	// the replaced transactions and the ones trimmed to make room come back
+	// if the transaction is trimmed itself, the mempool is left as it was
+	rollingMinFeeRate, lastRollingUpdate := m.rollingMinFeeRate, m.lastRollingUpdate
+	m.journal = make([]*mempoolEntry, 0, len(evicted))
+	defer func() {
+		m.journal = nil
+	}()
+	for h := range evicted {
+		m.remove(h)
+	}
+	m.add(hashTx, tx, fee, size, added)
+	m.trim()
+	if _, ok := m.txs[hashTx]; !ok {
+		removed := m.journal
+		m.journal = nil
+		restored := make([]*mempoolEntry, 0, len(removed))
+		for _, e := range removed {
+			if e.hash != hashTx {
+				restored = append(restored, e)
+			}
+		}
+		m.restore(restored)
+		m.rollingMinFeeRate, m.lastRollingUpdate = rollingMinFeeRate, lastRollingUpdate
+		return newRejectError(RejectMempoolFull, "mempool is full and the transaction fee rate is too low")
+	}
+	return nil
+}
+
+// checkConflicts refuses the transaction spending the outputs already spent
+// by the mempool transactions, unless replace-by-fee is enabled,
+// the caller must hold the lock
+func (m *Mempool) checkConflicts(tx *proto.Transaction, conflicts map[string]struct{}) error {
+	if len(conflicts) == 0 || m.conf.ReplaceByFee {
+		return nil
+	}
+	for _, input := range tx.Inputs {
+		utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+		if spender, ok := m.spends[utxoKey]; ok {
+			return newRejectErrorf(
+				RejectMempoolConflict,
+				"utxo %x:%d is already spent by mempool transaction %x",
+				input.PrevTxHash,
+				input.OutIndex,
+				spender,
+			)
+		}
+	}
+	return nil
+}
+
+// checkFeeRate checks if the transaction pays at least the mempool minimum fee rate,
+// the caller must hold the lock
+func (m *Mempool) checkFeeRate(fee int64, size int) error {
+	if minFeeRate := m.minFeeRate(time.Now()); feeRate(fee, size) < minFeeRate {
+		return newRejectErrorf(
+			RejectInsufficientFee,
+			"fee rate %d is below the mempool minimum fee rate %d",
+			feeRate(fee, size),
+			minFeeRate,
+		)
+	}
+	return nil
+}
+
+// checkChainLimits checks if adding the transaction keeps the chains of unconfirmed
+// transactions within the limits, the transactions that are going to be evicted
+// are not counted, the caller must hold the lock
+func (m *Mempool) checkChainLimits(tx *proto.Transaction, evicted map[string]struct{}) error {
+	parents := make(map[string]struct{})
+	for h := range m.parentsOf(tx) {
+		if _, ok := evicted[h]; !ok {
+			parents[h] = struct{}{}
+		}
+	}
+	ancestors := m.withAncestors(parents)
+	if m.conf.MaxAncestors > 0 && len(ancestors)+1 > m.conf.MaxAncestors {
+		return newRejectErrorf(
+			RejectTooLongChain,
+			"transaction would have %d unconfirmed ancestors, the limit is %d",
+			len(ancestors),
+			m.conf.MaxAncestors-1,
+		)
+	}
+	if m.conf.MaxDescendants <= 0 {
+		return nil
+	}
+	for a := range ancestors {
+		descendants := 0
+		for d := range m.withDescendants(map[string]struct{}{a: {}}) {
+			if _, ok := evicted[d]; !ok {
+				descendants++
+			}
+		}
+		if descendants+1 > m.conf.MaxDescendants {
+			return newRejectErrorf(
+				RejectTooLongChain,
+				"mempool transaction %x would have %d descendants, the limit is %d",
+				a,
+				descendants+1,
+				m.conf.MaxDescendants,
+			)
+		}
+	}
+	return nil
+}
+
+// minFeeRate returns the fee rate required from the new transactions, the caller must hold the lock
+func (m *Mempool) minFeeRate(now time.Time) int64 {
+	if m.rollingMinFeeRate > 0 {
+		elapsed := now.Sub(m.lastRollingUpdate)
+		m.rollingMinFeeRate *= math.Pow(0.5, elapsed.Seconds()/minFeeRateHalfLife.Seconds())
+		m.lastRollingUpdate = now
+		// once it drops below the increment, it is not worth keeping
+		if m.rollingMinFeeRate < float64(m.conf.IncrementalRelayFeeRate)/2 {
+			m.rollingMinFeeRate = 0
+		}
+	}
+	if rolling := int64(m.rollingMinFeeRate); rolling > m.conf.Policy.MinFeeRate {
+		return rolling
+	}
+	return m.conf.Policy.MinFeeRate
+}
+
+// isFull tells if the mempool is over any of its caps, the caller must hold the lock
+func (m *Mempool) isFull() bool {
+	return (m.conf.MaxTxs > 0 && len(m.txs) > m.conf.MaxTxs) ||
+		(m.conf.MaxBytes > 0 && m.bytes > m.conf.MaxBytes)
+}
+
+// Expire removes the transactions waiting in the mempool longer than the configured expiry,
+// along with their descendants, and returns the number of removed transactions
+func (m *Mempool) Expire(now time.Time) int {
+	if m.conf.Expiry <= 0 {
+		return 0
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	expired := make(map[string]struct{})
+	for h, e := range m.txs {
+		if now.Sub(e.added) > m.conf.Expiry {
+			expired[h] = struct{}{}
+		}
+	}
+	removed := 0
+	for h := range m.withDescendants(expired) {
+		if _, ok := m.txs[h]; ok {
+			m.remove(h)
+			removed++
+		}
+	}
+	return removed
+}
+
+// checkReplacement checks if the transaction paying fee can evict the given transactions,
+// the caller must hold the lock
+func (m *Mempool) checkReplacement(fee int64, evicted map[string]struct{}) error {
+	if len(evicted) > maxReplacementEvictions {
+		return newRejectErrorf(
+			RejectMempoolConflict,
+			"replacement would evict %d transactions, the limit is %d",
+			len(evicted),
+			maxReplacementEvictions,
+		)
+	}
+	evictedFee := int64(0)
+	for h := range evicted {
+		evictedFee += m.txs[h].fee
+	}
+	if fee < evictedFee+m.conf.MinReplacementFeeBump {
+		return newRejectErrorf(
+			RejectInsufficientFee,
+			"replacement fee %d is less than the replaced fee %d plus the bump %d",
+			fee,
+			evictedFee,
+			m.conf.MinReplacementFeeBump,
+		)
+	}
+	return nil
+}
+
+func (m *Mempool) add(hashTx string, tx *proto.Transaction, fee int64, size int, added time.Time) {
+	m.seq++
+	m.insert(&mempoolEntry{
+		hash:  hashTx,
+		tx:    tx,
+		fee:   fee,
+		size:  size,
+		added: added,
+		seq:   m.seq,
+	})
+}
+
+// insert adds the entry without descendants in the mempool, the caller must hold the lock
+func (m *Mempool) insert(e *mempoolEntry) {
+	e.parents = m.parentsOf(e.tx)
+	m.link(e)
+	m.txs[e.hash] = e
+	m.bytes += e.size
+	for _, input := range e.tx.Inputs {
+		m.spends[secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))] = e.hash
+	}
+	heap.Push(&m.evictions, e)
+}
+
+func (m *Mempool) remove(hashTx string) {
+	e, ok := m.txs[hashTx]
+	if !ok {
+		return
+	}
+	m.unlink(e)
+	for _, input := range e.tx.Inputs {
+		utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+		if m.spends[utxoKey] == hashTx {
+			delete(m.spends, utxoKey)
+		}
+	}
+	// the children stay in the mempool if the transaction got confirmed
+	for index := range e.tx.Outputs {
+		if child, ok := m.txs[m.spends[secure.MakeUTXOKey([]byte(hashTx), index)]]; ok {
+			delete(child.parents, hashTx)
+		}
+	}
+	heap.Remove(&m.evictions, e.evictIndex)
+	m.bytes -= e.size
+	delete(m.txs, hashTx)
+	if m.journal != nil {
+		m.journal = append(m.journal, e)
+	}
 }
 
 func (m *Mempool) Contains(tx *proto.Transaction) bool {
@@ -36,22 +426,191 @@ func (m *Mempool) Contains(tx *proto.Transaction) bool {
 func (m *Mempool) Clear() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.txs = make(map[string]*proto.Transaction)
+	m.txs = make(map[string]*mempoolEntry)
+	m.spends = make(map[string]string)
+	m.evictions = nil
+	m.bytes = 0
 }
 
 func (m *Mempool) Remove(tx *proto.Transaction) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	hashTx := string(secure.HashTransaction(tx))
-	delete(m.txs, hashTx)
+	m.remove(secure.HashTransaction(tx))
 }
 
+// RemoveBlockTransactions removes the transactions included in the block,
+// along with the mempool transactions double spending them and their descendants
+func (m *Mempool) RemoveBlockTransactions(b *proto.Block) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, tx := range b.Transactions {
+		m.remove(secure.HashTransaction(tx))
+	}
+	for _, tx := range b.Transactions {
+		for h := range m.withDescendants(m.conflictsOf(tx)) {
+			m.remove(h)
+		}
+	}
+}
+
+// List returns the mempool transactions in admission order,
+// so every transaction comes after the transactions it spends from
 func (m *Mempool) List() []*proto.Transaction {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
-	txs := make([]*proto.Transaction, 0)
-	for _, tx := range m.txs {
-		txs = append(txs, tx)
+	entries := m.sortedEntries()
+	txs := make([]*proto.Transaction, 0, len(entries))
+	for _, e := range entries {
+		txs = append(txs, e.tx)
 	}
 	return txs
 }
+
+// sortedEntries returns the mempool entries in admission order, the caller must hold the lock
+func (m *Mempool) sortedEntries() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(m.txs))
+	for _, e := range m.txs {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	return entries
+}
+
+// conflictsOf returns the hashes of the mempool transactions
+// spending any of the tx inputs, the caller must hold the lock
+func (m *Mempool) conflictsOf(tx *proto.Transaction) map[string]struct{} {
+	conflicts := make(map[string]struct{})
+	for _, input := range tx.Inputs {
+		utxoKey := secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))
+		if spender, ok := m.spends[utxoKey]; ok {
+			conflicts[spender] = struct{}{}
+		}
+	}
+	return conflicts
+}
+
+// parentsOf returns the hashes of the mempool transactions
+// the tx spends from, the caller must hold the lock
+func (m *Mempool) parentsOf(tx *proto.Transaction) map[string]struct{} {
+	parents := make(map[string]struct{})
+	for _, input := range tx.Inputs {
+		if _, ok := m.txs[string(input.PrevTxHash)]; ok {
+			parents[string(input.PrevTxHash)] = struct{}{}
+		}
+	}
+	return parents
+}
+
+// withAncestors returns the given transactions together with all the mempool
+// transactions they spend from, directly or not, the caller must hold the lock
+func (m *Mempool) withAncestors(hashes map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{}, len(hashes))
+	queue := make([]string, 0, len(hashes))
+	for h := range hashes {
+		result[h] = struct{}{}
+		queue = append(queue, h)
+	}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		e, ok := m.txs[h]
+		if !ok {
+			continue
+		}
+		for parent := range e.parents {
+			if _, ok := result[parent]; ok {
+				continue
+			}
+			result[parent] = struct{}{}
+			queue = append(queue, parent)
+		}
+	}
+	return result
+}
+
+// withDescendants returns the given transactions together with all the mempool
+// transactions spending their outputs, directly or not, the caller must hold the lock
+func (m *Mempool) withDescendants(hashes map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{}, len(hashes))
+	queue := make([]string, 0, len(hashes))
+	for h := range hashes {
+		result[h] = struct{}{}
+		queue = append(queue, h)
+	}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		e, ok := m.txs[h]
+		if !ok {
+			continue
+		}
+		for index := range e.tx.Outputs {
+			child, ok := m.spends[secure.MakeUTXOKey([]byte(h), index)]
+			if !ok {
+				continue
+			}
+			if _, ok := result[child]; ok {
+				continue
+			}
+			result[child] = struct{}{}
+			queue = append(queue, child)
+		}
+	}
+	return result
+}
+
+func txSize(tx *proto.Transaction) int {
+	return pb.Size(tx)
+}
+
+// checkTransactionSanity runs the context free consensus checks of the transaction,
+// the shape the node does not relay, e.g. no inputs, is left to the policy
+func checkTransactionSanity(tx *proto.Transaction) error {
+	for i, output := range tx.Outputs {
+		if output.Value < 0 {
+			return newRejectErrorf(RejectMalformed, "output %d has negative value %d", i, output.Value)
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// mempoolView exposes the mempool outputs to the chain validation,
+// leaving out the transactions that are about to be replaced.
+// The outputs of the base mempool are exposed too, their spends are not,
+// as the conflicts with the base are checked by the base itself.
+// It does not lock the mempools, as it is used while the locks are already held.
+type mempoolView struct {
+	m        *Mempool
+	replaced map[string]struct{}
+}
+
+func (v mempoolView) UTXO(txHash []byte, outIndex int) *proto.UTXO {
+	if _, ok := v.replaced[string(txHash)]; ok {
+		return nil
+	}
+	e, ok := v.m.txs[string(txHash)]
+	if !ok && v.m.base != nil {
+		e, ok = v.m.base.txs[string(txHash)]
+	}
+	if !ok || outIndex < 0 || outIndex >= len(e.tx.Outputs) {
+		return nil
+	}
+	return &proto.UTXO{
+		TxHash:   txHash,
+		OutIndex: int32(outIndex),
+		Output:   e.tx.Outputs[outIndex],
+	}
+}
+
+func (v mempoolView) IsSpent(utxoKey string) bool {
+	spender, ok := v.m.spends[utxoKey]
+	if !ok {
+		return false
+	}
+	_, replaced := v.replaced[spender]
+	return !replaced
+}
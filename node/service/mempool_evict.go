@@ -0,0 +1,129 @@
+package service
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// evictionHeap orders the mempool entries by their descendant score, the lowest first,
+// so the full mempool finds the transaction to evict without scanning all of them.
+// The entries keep their position in the heap, it is fixed whenever the totals
+// of their descendants change.
+type evictionHeap []*mempoolEntry
+
+func (h evictionHeap) Len() int {
+	return len(h)
+}
+
+// Less puts the newer transaction first when the scores tie
+func (h evictionHeap) Less(i, j int) bool {
+	left, right := h[i].descendantScore(), h[j].descendantScore()
+	if left != right {
+		return left < right
+	}
+	return h[i].seq > h[j].seq
+}
+
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].evictIndex = i
+	h[j].evictIndex = j
+}
+
+func (h *evictionHeap) Push(x any) {
+	e := x.(*mempoolEntry)
+	e.evictIndex = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *evictionHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.evictIndex = -1
+	return e
+}
+
+// descendantScore returns the higher of the transaction fee rate and the fee rate
+// of the transaction together with its descendants, so a parent paying a low fee
+// is not evicted while its children make it worth mining
+func (e *mempoolEntry) descendantScore() int64 {
+	own := e.feeRate()
+	if pkg := feeRate(e.descFee, e.descSize); pkg > own {
+		return pkg
+	}
+	return own
+}
+
+// link adds the new entry to the descendant totals of its mempool ancestors
+// and sums the ancestors into its own ancestor totals, the caller must hold the lock
+func (m *Mempool) link(e *mempoolEntry) {
+	e.descFee, e.descSize = e.fee, e.size
+	e.ancFee, e.ancSize = e.fee, e.size
+	for h := range m.withAncestors(e.parents) {
+		a, ok := m.txs[h]
+		if !ok {
+			continue
+		}
+		a.descFee += e.fee
+		a.descSize += e.size
+		heap.Fix(&m.evictions, a.evictIndex)
+		e.ancFee += a.fee
+		e.ancSize += a.size
+	}
+}
+
+// unlink takes the leaving entry out of the totals of its mempool ancestors
+// and descendants, the caller must hold the lock
+func (m *Mempool) unlink(e *mempoolEntry) {
+	for h := range m.withAncestors(e.parents) {
+		a, ok := m.txs[h]
+		if !ok {
+			continue
+		}
+		a.descFee -= e.fee
+		a.descSize -= e.size
+		heap.Fix(&m.evictions, a.evictIndex)
+	}
+	for h := range m.withDescendants(map[string]struct{}{e.hash: {}}) {
+		d, ok := m.txs[h]
+		if !ok || h == e.hash {
+			continue
+		}
+		d.ancFee -= e.fee
+		d.ancSize -= e.size
+	}
+}
+
+// trim evicts the transactions with the lowest descendant score, along with their
+// descendants, until the mempool fits its caps, the caller must hold the lock
+func (m *Mempool) trim() {
+	for m.isFull() {
+		lowest := m.evictions[0]
+		// the new transactions have to pay more than the evicted one
+		rate := float64(lowest.descendantScore() + m.conf.IncrementalRelayFeeRate)
+		if rate > m.rollingMinFeeRate {
+			m.rollingMinFeeRate = rate
+			m.lastRollingUpdate = time.Now()
+		}
+		for h := range m.withDescendants(map[string]struct{}{lowest.hash: {}}) {
+			m.remove(h)
+		}
+	}
+}
+
+// restore puts the removed entries back in admission order, the set has to include
+// the descendants of every entry in it, as the replaced and the trimmed sets do,
+// the caller must hold the lock
+func (m *Mempool) restore(entries []*mempoolEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	for _, e := range entries {
+		if _, ok := m.txs[e.hash]; !ok {
+			m.insert(e)
+		}
+	}
+}
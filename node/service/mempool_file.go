@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// mempoolFileVersion is written at the beginning of the mempool dump,
+// it has to be bumped whenever the record layout changes
+const mempoolFileVersion = 1
+
+// maxMempoolRecordSize protects the loader from allocating huge buffers
+// when the dump is corrupted
+const maxMempoolRecordSize = 1 << 20
+
+// Save writes the mempool transactions to w in admission order.
+// Every record holds the time the transaction was added
+// and the length-prefixed protobuf encoded transaction.
+func (m *Mempool) Save(w io.Writer) error {
+	m.lock.RLock()
+	entries := m.sortedEntries()
+	m.lock.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.BigEndian, uint32(mempoolFileVersion)); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		b, err := pb.Marshal(e.tx)
+		if err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.BigEndian, e.added.UnixNano()); err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.BigEndian, uint32(len(b))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Load reads the transactions written by Save and runs them through the admission
+// checks against the current chain. The transactions that are no longer valid
+// or have already expired are dropped. It returns the number of the loaded
+// and the dropped transactions.
+func (m *Mempool) Load(c *chain.Chain, r io.Reader) (int, int, error) {
+	br := bufio.NewReader(r)
+	var version uint32
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return 0, 0, err
+	}
+	if version != mempoolFileVersion {
+		return 0, 0, fmt.Errorf("unsupported mempool file version %d", version)
+	}
+	loaded, dropped := 0, 0
+	for {
+		var (
+			addedNano int64
+			size      uint32
+		)
+		if err := binary.Read(br, binary.BigEndian, &addedNano); err != nil {
+			if errors.Is(err, io.EOF) {
+				return loaded, dropped, nil
+			}
+			return loaded, dropped, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return loaded, dropped, err
+		}
+		if size > maxMempoolRecordSize {
+			return loaded, dropped, fmt.Errorf("mempool record size %d exceeds the limit", size)
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return loaded, dropped, err
+		}
+		tx := &proto.Transaction{}
+		if err := pb.Unmarshal(b, tx); err != nil {
+			return loaded, dropped, err
+		}
+		added := time.Unix(0, addedNano)
+		if m.conf.Expiry > 0 && time.Since(added) > m.conf.Expiry {
+			dropped++
+			continue
+		}
+		if err := m.admit(c, tx, added); err != nil {
+			dropped++
+			continue
+		}
+		loaded++
+	}
+}
+
+// SaveFile writes the mempool to the file at path, the file is replaced atomically
+func (m *Mempool) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := m.Save(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile loads the mempool from the file at path, a missing file is not an error
+func (m *Mempool) LoadFile(c *chain.Chain, path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	defer f.Close()
+	return m.Load(c, f)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+func TestMempoolSaveLoad(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, parent))
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(parent)), 1, 97000)
+	assert.Nil(t, m.Admit(c, child))
+
+	path := filepath.Join(t.TempDir(), "mempool.dat")
+	assert.Nil(t, m.SaveFile(path))
+
+	loadedMempool := NewMempool(DefaultMempoolConfig())
+	loaded, dropped, err := loadedMempool.LoadFile(c, path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, loaded)
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, len(m.List()), len(loadedMempool.List()))
+	for i, tx := range m.List() {
+		assert.Equal(t, secure.HashTransaction(tx), secure.HashTransaction(loadedMempool.List()[i]))
+	}
+	assert.Equal(t,
+		m.txs[secure.HashTransaction(parent)].added.UnixNano(),
+		loadedMempool.txs[secure.HashTransaction(parent)].added.UnixNano(),
+	)
+}
+
+func TestMempoolLoadRevalidates(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	tx := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, tx))
+	var buf bytes.Buffer
+	assert.Nil(t, m.Save(&buf))
+
+	// while the node was down, the chain confirmed a double spend of the transaction
+	doubleSpend := makeSignedTx(privKey, genesisTxHash(t, c), 0, 99000)
+	genesis, err := c.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	block := &proto.Block{
+		Header: &proto.Header{
+			Height:        1,
+			PrevBlockHash: []byte(secure.HashBlock(genesis)),
+		},
+		Transactions: []*proto.Transaction{doubleSpend},
+	}
+	block.Header.UtxoRoot, err = c.NextUTXORoot(block.Transactions)
+	assert.Nil(t, err)
+	secure.SignBlock(block, privKey)
+	assert.Nil(t, c.AddBlock(block))
+
+	loadedMempool := NewMempool(DefaultMempoolConfig())
+	loaded, dropped, err := loadedMempool.Load(c, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, loaded)
+	assert.Equal(t, 1, dropped)
+	assert.Empty(t, loadedMempool.List())
+}
+
+func TestMempoolLoadMissingFile(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	loaded, dropped, err := m.LoadFile(c, filepath.Join(t.TempDir(), "missing.dat"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, loaded+dropped)
+}
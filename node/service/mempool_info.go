@@ -0,0 +1,88 @@
+package service
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/yuriykis/microblocknet/common/requests"
+)
+
+// feeRateBuckets are the lower bounds of the fee rate histogram buckets
+var feeRateBuckets = []int64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000}
+
+// Transactions returns the details of all the transactions waiting in the mempool, in admission order
+func (m *Mempool) Transactions() []*requests.MempoolTransaction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	entries := m.sortedEntries()
+	txs := make([]*requests.MempoolTransaction, 0, len(entries))
+	for _, e := range entries {
+		txs = append(txs, e.info())
+	}
+	return txs
+}
+
+// Transaction returns the details of the mempool transaction with the given hash,
+// or nil if there is no such transaction in the mempool
+func (m *Mempool) Transaction(hashTx []byte) *requests.MempoolTransaction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	e, ok := m.txs[string(hashTx)]
+	if !ok {
+		return nil
+	}
+	return e.info()
+}
+
+// Stats returns the summary of the mempool contents
+func (m *Mempool) Stats() *requests.GetMempoolStatsResponse {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats := &requests.GetMempoolStatsResponse{
+		Count:            len(m.txs),
+		Bytes:            m.bytes,
+		MinFeeRate:       m.minFeeRate(time.Now()),
+		FeeRateHistogram: make([]requests.MempoolFeeRateBucket, len(feeRateBuckets)),
+	}
+	for i, minFeeRate := range feeRateBuckets {
+		stats.FeeRateHistogram[i].MinFeeRate = minFeeRate
+	}
+	var oldest *mempoolEntry
+	for _, e := range m.txs {
+		// the index of the first bucket with the lower bound above the fee rate
+		i := sort.Search(len(feeRateBuckets), func(i int) bool {
+			return feeRateBuckets[i] > e.feeRate()
+		})
+		if i > 0 {
+			stats.FeeRateHistogram[i-1].Count++
+			stats.FeeRateHistogram[i-1].Bytes += e.size
+		}
+		if oldest == nil || e.added.Before(oldest.added) {
+			oldest = e
+		}
+	}
+	if oldest != nil {
+		stats.Oldest = oldest.info()
+	}
+	return stats
+}
+
+func (e *mempoolEntry) info() *requests.MempoolTransaction {
+	parents := make([][]byte, 0, len(e.parents))
+	for h := range e.parents {
+		parents = append(parents, []byte(h))
+	}
+	sort.Slice(parents, func(i, j int) bool {
+		return bytes.Compare(parents[i], parents[j]) < 0
+	})
+	return &requests.MempoolTransaction{
+		Hash:        []byte(e.hash),
+		Transaction: e.tx,
+		Fee:         e.fee,
+		Size:        e.size,
+		FeeRate:     e.feeRate(),
+		Added:       e.added,
+		Parents:     parents,
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+func TestMempoolTransactionInfo(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, parent))
+	parentHash := []byte(secure.HashTransaction(parent))
+	child := makeSignedTx(privKey, parentHash, 1, 97000)
+	assert.Nil(t, m.Admit(c, child))
+
+	info := m.Transaction([]byte(secure.HashTransaction(child)))
+	assert.NotNil(t, info)
+	assert.Equal(t, int64(1000), info.Fee)
+	assert.Equal(t, txSize(child), info.Size)
+	assert.Equal(t, [][]byte{parentHash}, info.Parents)
+	assert.Nil(t, m.Transaction([]byte("unknown")))
+
+	txs := m.Transactions()
+	assert.Len(t, txs, 2)
+	assert.Equal(t, parentHash, txs[0].Hash)
+}
+
+func TestMempoolStats(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	stats := m.Stats()
+	assert.Equal(t, 0, stats.Count)
+	assert.Nil(t, stats.Oldest)
+
+	tx := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, tx))
+
+	stats = m.Stats()
+	assert.Equal(t, 1, stats.Count)
+	assert.Equal(t, txSize(tx), stats.Bytes)
+	assert.Equal(t, []byte(secure.HashTransaction(tx)), stats.Oldest.Hash)
+	total := 0
+	for _, b := range stats.FeeRateHistogram {
+		total += b.Count
+		if b.Count > 0 {
+			assert.LessOrEqual(t, b.MinFeeRate, stats.Oldest.FeeRate)
+		}
+	}
+	assert.Equal(t, 1, total)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/client"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"google.golang.org/grpc/codes"
+	grpcPeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxMempoolInventory is the maximum number of the transaction hashes
+	// announced to a peer or accepted from a peer
+	maxMempoolInventory = 50_000
+	// maxMempoolSyncBatch is the maximum number of the transactions requested at once
+	maxMempoolSyncBatch = 500
+	mempoolSyncTimeout  = 30 * time.Second
+
+	// every peer can ask for the mempool inventory twice in a row
+	// and then once per mempoolAnnounceInterval
+	mempoolAnnounceInterval = 30 * time.Second
+	mempoolAnnounceBurst    = 2
+	// every peer can fetch mempoolSyncTxsBurst transactions in a row
+	// and then mempoolSyncTxsRate transactions per second
+	mempoolSyncTxsRate  = 1000
+	mempoolSyncTxsBurst = 5 * maxMempoolSyncBatch
+)
+
+// mempoolSyncLimits protect the node from the peers asking for its mempool too often
+type mempoolSyncLimits struct {
+	announce *rateLimiter
+	txs      *rateLimiter
+}
+
+func newMempoolSyncLimits() *mempoolSyncLimits {
+	return &mempoolSyncLimits{
+		announce: newRateLimiter(1/mempoolAnnounceInterval.Seconds(), mempoolAnnounceBurst),
+		txs:      newRateLimiter(mempoolSyncTxsRate, mempoolSyncTxsBurst),
+	}
+}
+
+// Hashes returns the hashes of at most limit mempool transactions in admission order,
+// so the parents always come before their children
+func (m *Mempool) Hashes(limit int) [][]byte {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	entries := m.sortedEntries()
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	hashes := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		hashes = append(hashes, []byte(e.hash))
+	}
+	return hashes
+}
+
+// Missing returns the hashes that are not in the mempool, the duplicates are skipped
+func (m *Mempool) Missing(hashes [][]byte) [][]byte {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	seen := make(map[string]struct{}, len(hashes))
+	missing := make([][]byte, 0)
+	for _, h := range hashes {
+		if _, ok := seen[string(h)]; ok {
+			continue
+		}
+		seen[string(h)] = struct{}{}
+		if _, ok := m.txs[string(h)]; !ok {
+			missing = append(missing, h)
+		}
+	}
+	return missing
+}
+
+// Get returns the mempool transactions with the given hashes,
+// the hashes not found in the mempool are skipped
+func (m *Mempool) Get(hashes [][]byte) []*proto.Transaction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	txs := make([]*proto.Transaction, 0, len(hashes))
+	for _, h := range hashes {
+		if e, ok := m.txs[string(h)]; ok {
+			txs = append(txs, e.tx)
+		}
+	}
+	return txs
+}
+
+// GetMempool announces the hashes of the transactions waiting in the mempool
+func (n *Node) GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error) {
+	key, err := n.peerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mempoolSyncLimits.announce.allow(key, 1, time.Now()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "mempool inventory requested too often")
+	}
+	return &proto.TxHashes{
+		Hashes: n.Mempool().Hashes(maxMempoolInventory),
+	}, nil
+}
+
+// GetMempoolTransactions sends the requested mempool transactions to the peer
+func (n *Node) GetMempoolTransactions(ctx context.Context, h *proto.TxHashes) (*proto.Transactions, error) {
+	if len(h.Hashes) > maxMempoolSyncBatch {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"too many transactions requested: %d, limit %d",
+			len(h.Hashes),
+			maxMempoolSyncBatch,
+		)
+	}
+	key, err := n.peerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mempoolSyncLimits.txs.allow(key, len(h.Hashes), time.Now()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "mempool transactions requested too often")
+	}
+	return &proto.Transactions{
+		Transactions: n.Mempool().Get(h.Hashes),
+	}, nil
+}
+
+func (n *Node) peerKey(ctx context.Context) (string, error) {
+	peer, ok := grpcPeer.FromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("Node: %s, failed to get peer from context", n)
+	}
+	return peerHost(peer.Addr.String()), nil
+}
+
+// syncMempool fetches the transactions the peer has in its mempool and the node does not.
+// It is run once for every new peer, right after the handshake.
+func (n *Node) syncMempool(c client.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), mempoolSyncTimeout)
+	defer cancel()
+
+	added, err := n.fetchMempool(ctx, c)
+	if err != nil {
+		n.logger.Errorf("Node: %s, failed to sync mempool with %s: %v", n, c, err)
+	}
+	if added > 0 {
+		n.logger.Infof("Node: %s, %d transactions added to mempool from %s", n, added, c)
+	}
+}
+
+func (n *Node) fetchMempool(ctx context.Context, c client.Client) (int, error) {
+	inv, err := c.GetMempool(ctx, n.nm.version())
+	if err != nil {
+		return 0, err
+	}
+	hashes := inv.Hashes
+	if len(hashes) > maxMempoolInventory {
+		hashes = hashes[:maxMempoolInventory]
+	}
+	missing := n.Mempool().Missing(hashes)
+
+	added := 0
+	for start := 0; start < len(missing); start += maxMempoolSyncBatch {
+		end := min(start+maxMempoolSyncBatch, len(missing))
+		batch := missing[start:end]
+		res, err := c.GetMempoolTransactions(ctx, &proto.TxHashes{Hashes: batch})
+		if err != nil {
+			return added, err
+		}
+		requested := make(map[string]struct{}, len(batch))
+		for _, h := range batch {
+			requested[string(h)] = struct{}{}
+		}
+		for _, tx := range res.Transactions {
+			// the peer cannot push the transactions nobody asked for
+			if _, ok := requested[secure.HashTransaction(tx)]; !ok {
+				continue
+			}
+			if err := n.Mempool().Admit(n.Chain(), tx); err != nil {
+				continue
+			}
+			added++
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	grpcPeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// localClient calls the node directly, as if the requests came from a peer at 127.0.0.1
+type localClient struct {
+	*Node
+}
+
+func (c localClient) ctx(ctx context.Context) context.Context {
+	return grpcPeer.NewContext(ctx, &grpcPeer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000},
+	})
+}
+
+func (c localClient) GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error) {
+	return c.Node.GetMempool(c.ctx(ctx), v)
+}
+
+func (c localClient) GetMempoolTransactions(ctx context.Context, h *proto.TxHashes) (*proto.Transactions, error) {
+	return c.Node.GetMempoolTransactions(c.ctx(ctx), h)
+}
+
+func newTestNode(c *chain.Chain) *Node {
+	logger := zap.NewNop().Sugar()
+	mempool := NewMempool(DefaultMempoolConfig())
+	return &Node{
+		logger:            logger,
+		nm:                NewNetworkManager("", logger),
+		chain:             c,
+		mempool:           mempool,
+		stempool:          newStempool(DefaultMempoolConfig(), mempool),
+		mempoolSyncLimits: newMempoolSyncLimits(),
+		dandelion:         newDandelion(DefaultDandelionConfig()),
+	}
+}
+
+func TestSyncMempool(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	remote := newTestNode(c)
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, remote.Mempool().Admit(c, parent))
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(parent)), 1, 97000)
+	assert.Nil(t, remote.Mempool().Admit(c, child))
+
+	local := newTestNode(c)
+	assert.Nil(t, local.Mempool().Admit(c, parent))
+
+	added, err := local.fetchMempool(context.Background(), localClient{remote})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []*proto.Transaction{parent, child}, local.Mempool().List())
+}
+
+func TestGetMempoolRateLimit(t *testing.T) {
+	n := localClient{newTestNode(chain.New(store.NewChainMemoryStore()))}
+	ctx := context.Background()
+
+	for i := 0; i < mempoolAnnounceBurst; i++ {
+		_, err := n.GetMempool(ctx, &proto.Version{})
+		assert.Nil(t, err)
+	}
+	_, err := n.GetMempool(ctx, &proto.Version{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = n.GetMempoolTransactions(ctx, &proto.TxHashes{
+		Hashes: make([][]byte, maxMempoolSyncBatch+1),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
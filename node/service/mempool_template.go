@@ -0,0 +1,136 @@
+package service
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+)
+
+// templateEntry is a mempool transaction while the block template is built,
+// ancFee and ancSize sum the transaction and its ancestors not selected yet,
+// i.e. the package the transaction would bring into the block
+type templateEntry struct {
+	e       *mempoolEntry
+	ancFee  int64
+	ancSize int
+	index   int
+}
+
+// betterThan compares the packages by fee rate, the older package wins a tie
+func (t *templateEntry) betterThan(other *templateEntry) bool {
+	// compare fee/size without the integer division rounding
+	left := t.ancFee * int64(other.ancSize)
+	right := other.ancFee * int64(t.ancSize)
+	if left != right {
+		return left > right
+	}
+	return t.e.seq < other.e.seq
+}
+
+// templateHeap puts the transaction with the best package first
+type templateHeap []*templateEntry
+
+func (h templateHeap) Len() int {
+	return len(h)
+}
+
+func (h templateHeap) Less(i, j int) bool {
+	return h[i].betterThan(h[j])
+}
+
+func (h templateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *templateHeap) Push(x any) {
+	t := x.(*templateEntry)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *templateHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.index = -1
+	return t
+}
+
+// BlockTransactions selects the mempool transactions for the next block.
+// The transactions are ranked by the fee rate of their package, i.e. the transaction
+// together with its ancestors not selected yet, so a child paying a high fee pulls
+// its low fee parent into the block. Every transaction is placed after its parents.
+// maxBytes limits the total size of the selected transactions, zero means no limit.
+// The package totals start from the ones the mempool keeps, selecting a package
+// updates only the totals of the descendants of its transactions.
+func (m *Mempool) BlockTransactions(maxBytes int) []*proto.Transaction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var (
+		entries  = make(map[string]*templateEntry, len(m.txs))
+		ranking  = make(templateHeap, 0, len(m.txs))
+		selected = make(map[string]struct{}, len(m.txs))
+		txs      = make([]*proto.Transaction, 0, len(m.txs))
+		bytes    = 0
+	)
+	for h, e := range m.txs {
+		t := &templateEntry{e: e, ancFee: e.ancFee, ancSize: e.ancSize}
+		entries[h] = t
+		ranking = append(ranking, t)
+		t.index = len(ranking) - 1
+	}
+	heap.Init(&ranking)
+	for ranking.Len() > 0 {
+		best := heap.Pop(&ranking).(*templateEntry)
+		if maxBytes > 0 && bytes+best.ancSize > maxBytes {
+			// the package does not fit, neither does any package including it
+			continue
+		}
+		pkg := m.packageOf(best.e.hash, selected)
+		for _, h := range pkg {
+			selected[h] = struct{}{}
+			txs = append(txs, m.txs[h].tx)
+			t := entries[h]
+			if t.index >= 0 {
+				heap.Remove(&ranking, t.index)
+			}
+		}
+		bytes += best.ancSize
+		for _, h := range pkg {
+			e := m.txs[h]
+			for d := range m.withDescendants(map[string]struct{}{h: {}}) {
+				if _, ok := selected[d]; ok {
+					continue
+				}
+				t := entries[d]
+				t.ancFee -= e.fee
+				t.ancSize -= e.size
+				if t.index >= 0 {
+					heap.Fix(&ranking, t.index)
+				}
+			}
+		}
+	}
+	return txs
+}
+
+// packageOf returns the hashes of the transaction and its ancestors that are not
+// selected yet, parents first, the caller must hold the lock
+func (m *Mempool) packageOf(hashTx string, selected map[string]struct{}) []string {
+	pkg := make([]string, 0)
+	for h := range m.withAncestors(map[string]struct{}{hashTx: {}}) {
+		if _, ok := selected[h]; !ok {
+			pkg = append(pkg, h)
+		}
+	}
+	// parents are always admitted before their children
+	sort.Slice(pkg, func(i, j int) bool {
+		return m.txs[pkg[i]].seq < m.txs[pkg[j]].seq
+	})
+	return pkg
+}
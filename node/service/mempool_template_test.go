@@ -0,0 +1,101 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+func TestBlockTransactionsChildPaysForParent(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	funding := makeSignedTx(privKey, genesisTxHash(t, c), 0, 50000, 50000)
+	assert.Nil(t, m.Admit(c, funding))
+	fundingHash := []byte(secure.HashTransaction(funding))
+
+	// the parent pays almost nothing, its child pays a lot
+	parent := makeSignedTx(privKey, fundingHash, 0, 49999)
+	assert.Nil(t, m.Admit(c, parent))
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(parent)), 0, 40000)
+	assert.Nil(t, m.Admit(c, child))
+	middle := makeSignedTx(privKey, fundingHash, 1, 49000)
+	assert.Nil(t, m.Admit(c, middle))
+
+	txs := m.BlockTransactions(0)
+	assert.Equal(t, []*proto.Transaction{funding, parent, child, middle}, txs)
+
+	// with room for three transactions only, the funding and the child package win
+	limit := txSize(funding) + txSize(parent) + txSize(child)
+	txs = m.BlockTransactions(limit)
+	assert.Equal(t, []*proto.Transaction{funding, parent, child}, txs)
+}
+
+func TestMempoolChainLimits(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	conf := DefaultMempoolConfig()
+	conf.MaxAncestors = 3
+	conf.MaxDescendants = 3
+	m := NewMempool(conf)
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	prev := makeSignedTx(privKey, genesisTxHash(t, c), 0, 90000)
+	assert.Nil(t, m.Admit(c, prev))
+	for value := int64(80000); value > 60000; value -= 10000 {
+		tx := makeSignedTx(privKey, []byte(secure.HashTransaction(prev)), 0, value)
+		assert.Nil(t, m.Admit(c, tx))
+		prev = tx
+	}
+	tooLong := makeSignedTx(privKey, []byte(secure.HashTransaction(prev)), 0, 50000)
+	rejectErr, ok := RejectFromError(m.Admit(c, tooLong))
+	assert.True(t, ok)
+	assert.Equal(t, RejectTooLongChain, rejectErr.Code)
+
+	// once the first transaction is confirmed, the chain gets shorter
+	m.RemoveBlockTransactions(&proto.Block{Transactions: m.List()[:1]})
+	assertEvictionIndex(t, m)
+	assert.Nil(t, m.Admit(c, tooLong))
+}
+
+func BenchmarkBlockTransactions(b *testing.B) {
+	// a few thousand transactions in chains as long as the default limits allow
+	const chains = 120
+	c := chain.New(store.NewChainMemoryStore())
+	conf := DefaultMempoolConfig()
+	conf.MaxTxs = 0
+	conf.MaxBytes = 0
+	// every chain descends from the same funding transaction
+	conf.MaxDescendants = 0
+	m := NewMempool(conf)
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	values := make([]int64, 0, chains)
+	for i := 0; i < chains; i++ {
+		values = append(values, 800)
+	}
+	funding := makeSignedTx(privKey, genesisTxHash(b, c), 0, values...)
+	assert.Nil(b, m.Admit(c, funding))
+	for i := 0; i < chains; i++ {
+		prev, value := funding, values[i]
+		index := int32(i)
+		for j := 1; j < conf.MaxAncestors; j++ {
+			// vary the fees, so the packages keep changing their ranking
+			value -= int64((i+j)%7 + 1)
+			tx := makeSignedTx(privKey, []byte(secure.HashTransaction(prev)), index, value)
+			assert.Nil(b, m.Admit(c, tx))
+			prev, index = tx, 0
+		}
+	}
+	assert.Equal(b, chains*(conf.MaxAncestors-1)+1, len(m.List()))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.BlockTransactions(0)
+	}
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/store"
+	"google.golang.org/grpc/status"
+)
+
+const testGodSeed = "41b84a2eff9a47393471748fbbdff9d20c14badab3d2de59fd8b5e98edd34d1c577c4c3515c6c19e5b9fdfba39528b1be755aae4d6a75fc851d3a17fbf51f1bc"
+
+// genesisTxHash returns the hash of the transaction funding the god address
+func genesisTxHash(t testing.TB, c *chain.Chain) []byte {
+	genesis, err := c.GetBlockByHeight(0)
+	assert.Nil(t, err)
+	return []byte(secure.HashTransaction(genesis.Transactions[0]))
+}
+
+func makeSignedTx(
+	privKey *crypto.PrivateKey,
+	prevTxHash []byte,
+	outIndex int32,
+	values ...int64,
+) *proto.Transaction {
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{
+			{
+				PublicKey:  privKey.PublicKey().Bytes(),
+				PrevTxHash: prevTxHash,
+				OutIndex:   outIndex,
+			},
+		},
+	}
+	for _, v := range values {
+		tx.Outputs = append(tx.Outputs, &proto.TxOutput{
+			Value:   v,
+			Address: privKey.PublicKey().Address().Bytes(),
+		})
+	}
+	tx.Inputs[0].Signature = secure.SignTransaction(tx, privKey).Bytes()
+	return tx
+}
+
+func TestMempoolAdmit(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	tx := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, tx))
+	assert.True(t, m.Contains(tx))
+	assert.Equal(t, int64(1000), m.txs[secure.HashTransaction(tx)].fee)
+}
+
+func TestMempoolAdmitChild(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 99000)
+	assert.Nil(t, m.Admit(c, parent))
+
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(parent)), 1, 98000)
+	assert.Nil(t, m.Admit(c, child))
+	assert.Equal(t, []*proto.Transaction{parent, child}, m.List())
+}
+
+func TestMempoolAdmitReject(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+	genesisHash := genesisTxHash(t, c)
+
+	badSig := makeSignedTx(privKey, genesisHash, 0, 1000)
+	badSig.Inputs[0].Signature = crypto.GeneratePrivateKey().Sign("other").Bytes()
+
+	tests := []struct {
+		name string
+		txs  []*proto.Transaction
+		code RejectCode
+	}{
+		{
+			name: "duplicate",
+			txs:  []*proto.Transaction{makeSignedTx(privKey, genesisHash, 0, 1000)},
+			code: RejectDuplicate,
+		},
+		{
+			name: "mempool conflict",
+			txs: []*proto.Transaction{
+				makeSignedTx(privKey, genesisHash, 0, 1000),
+				makeSignedTx(privKey, genesisHash, 0, 2000),
+			},
+			code: RejectMempoolConflict,
+		},
+		{
+			name: "missing inputs",
+			txs:  []*proto.Transaction{makeSignedTx(privKey, []byte("unknown"), 0, 1000)},
+			code: RejectMissingInputs,
+		},
+		{
+			name: "insufficient value",
+			txs:  []*proto.Transaction{makeSignedTx(privKey, genesisHash, 0, 100001)},
+			code: RejectInsufficientValue,
+		},
+		{
+			name: "bad signature",
+			txs:  []*proto.Transaction{badSig},
+			code: RejectBadSignature,
+		},
+		{
+			name: "not owned inputs",
+			txs:  []*proto.Transaction{makeSignedTx(crypto.GeneratePrivateKey(), genesisHash, 0, 1000)},
+			code: RejectNotOwned,
+		},
+		{
+			name: "malformed",
+			txs:  []*proto.Transaction{makeSignedTx(privKey, genesisHash, 0, -1, 2000)},
+			code: RejectMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMempool(DefaultMempoolConfig())
+			var err error
+			// the first transactions set up the mempool, the last one is rejected
+			for _, tx := range tt.txs {
+				assert.Nil(t, err)
+				err = m.Admit(c, tx)
+			}
+			if tt.code == RejectDuplicate {
+				err = m.Admit(c, tt.txs[0])
+			}
+			rejectErr, ok := RejectFromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.code, rejectErr.Code)
+		})
+	}
+}
+
+func TestRejectFromGRPCError(t *testing.T) {
+	err := status.Convert(newRejectError(RejectMissingInputs, "utxo not found")).Err()
+	rejectErr, ok := RejectFromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, RejectMissingInputs, rejectErr.Code)
+	assert.Equal(t, "utxo not found", rejectErr.Reason)
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	conf := DefaultMempoolConfig()
+	conf.ReplaceByFee = true
+	m := NewMempool(conf)
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+	genesisHash := genesisTxHash(t, c)
+
+	original := makeSignedTx(privKey, genesisHash, 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, original))
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(original)), 1, 97500)
+	assert.Nil(t, m.Admit(c, child))
+
+	// the replacement has to pay more than the original and its child together
+	lowFee := makeSignedTx(privKey, genesisHash, 0, 98500)
+	rejectErr, ok := RejectFromError(m.Admit(c, lowFee))
+	assert.True(t, ok)
+	assert.Equal(t, RejectInsufficientFee, rejectErr.Code)
+
+	replacement := makeSignedTx(privKey, genesisHash, 0, 98000)
+	assert.Nil(t, m.Admit(c, replacement))
+	assert.Equal(t, []*proto.Transaction{replacement}, m.List())
+	assert.Len(t, m.spends, 1)
+}
+
+func TestMempoolRemoveBlockTransactions(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+	genesisHash := genesisTxHash(t, c)
+
+	tx := makeSignedTx(privKey, genesisHash, 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, tx))
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(tx)), 1, 97000)
+	assert.Nil(t, m.Admit(c, child))
+
+	// the block confirms a double spend of tx, so tx and its child are dropped
+	doubleSpend := makeSignedTx(privKey, genesisHash, 0, 90000)
+	m.RemoveBlockTransactions(&proto.Block{
+		Transactions: []*proto.Transaction{doubleSpend},
+	})
+	assert.Empty(t, m.List())
+	assert.Empty(t, m.spends)
+}
+
+func TestMempoolEvictsLowestFeeRate(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	conf := DefaultMempoolConfig()
+	conf.MaxTxs = 2
+	m := NewMempool(conf)
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 10000, 20000, 70000)
+	assert.Nil(t, m.Admit(c, parent))
+	parentHash := []byte(secure.HashTransaction(parent))
+
+	lowFee := makeSignedTx(privKey, parentHash, 0, 9990)
+	assert.Nil(t, m.Admit(c, lowFee))
+
+	// the mempool is over the cap, the transaction paying the lowest fee rate is evicted
+	highFee := makeSignedTx(privKey, parentHash, 1, 15000)
+	assert.Nil(t, m.Admit(c, highFee))
+	assert.False(t, m.Contains(lowFee))
+	assert.True(t, m.Contains(parent))
+	assert.True(t, m.Contains(highFee))
+
+	// the minimum fee rate went up, so a transaction paying as little is refused
+	rejectErr, ok := RejectFromError(m.Admit(c, makeSignedTx(privKey, parentHash, 2, 69990)))
+	assert.True(t, ok)
+	assert.Equal(t, RejectInsufficientFee, rejectErr.Code)
+	assert.Equal(t, m.bytes, txSize(parent)+txSize(highFee))
+	assertEvictionIndex(t, m)
+}
+
+func TestMempoolMinFeeRateDecays(t *testing.T) {
+	m := NewMempool(DefaultMempoolConfig())
+	now := time.Now()
+	m.rollingMinFeeRate = 8000
+	m.lastRollingUpdate = now
+
+	assert.Equal(t, int64(4000), m.minFeeRate(now.Add(minFeeRateHalfLife)))
+	assert.Equal(t, int64(0), m.minFeeRate(now.Add(10*minFeeRateHalfLife)))
+}
+
+func TestMempoolExpire(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	assert.Nil(t, m.Admit(c, parent))
+	child := makeSignedTx(privKey, []byte(secure.HashTransaction(parent)), 1, 97000)
+	assert.Nil(t, m.Admit(c, child))
+
+	assert.Equal(t, 0, m.Expire(time.Now()))
+	assert.Equal(t, 2, m.Expire(time.Now().Add(defaultMempoolExpiry+time.Second)))
+	assert.Empty(t, m.List())
+	assert.Equal(t, 0, m.bytes)
+}
+
+// assertEvictionIndex checks the descendant totals kept for trim against the ones computed from scratch
+func assertEvictionIndex(t *testing.T, m *Mempool) {
+	assert.Equal(t, len(m.txs), m.evictions.Len())
+	for h, e := range m.txs {
+		fee, size := int64(0), 0
+		for d := range m.withDescendants(map[string]struct{}{h: {}}) {
+			fee += m.txs[d].fee
+			size += m.txs[d].size
+		}
+		assert.Equal(t, fee, e.descFee)
+		assert.Equal(t, size, e.descSize)
+		fee, size = 0, 0
+		for a := range m.withAncestors(map[string]struct{}{h: {}}) {
+			fee += m.txs[a].fee
+			size += m.txs[a].size
+		}
+		assert.Equal(t, fee, e.ancFee)
+		assert.Equal(t, size, e.ancSize)
+		assert.Equal(t, e, m.evictions[e.evictIndex])
+	}
+}
+
+func TestMempoolReplacementTrimmedRestoresReplaced(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	conf := DefaultMempoolConfig()
+	conf.ReplaceByFee = true
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	parent := makeSignedTx(privKey, genesisTxHash(t, c), 0, 40000, 40000)
+	parentHash := []byte(secure.HashTransaction(parent))
+	original := makeSignedTx(privKey, parentHash, 0, 39000)
+	sibling := makeSignedTx(privKey, parentHash, 1, 30000)
+	// the replacement pays more, but it is so large its fee rate is the lowest
+	values := make([]int64, 0)
+	for i := 0; i < 20; i++ {
+		values = append(values, 1900)
+	}
+	replacement := makeSignedTx(privKey, parentHash, 0, values...)
+	conf.MaxBytes = txSize(parent) + txSize(original) + txSize(sibling) + 100
+	m := NewMempool(conf)
+	for _, tx := range []*proto.Transaction{parent, original, sibling} {
+		assert.Nil(t, m.Admit(c, tx))
+	}
+	assertEvictionIndex(t, m)
+
+	rejectErr, ok := RejectFromError(m.Admit(c, replacement))
+	assert.True(t, ok)
+	assert.Equal(t, RejectMempoolFull, rejectErr.Code)
+	assert.Equal(t, []*proto.Transaction{parent, original, sibling}, m.List())
+	assert.Equal(t, txSize(parent)+txSize(original)+txSize(sibling), m.bytes)
+	assert.Equal(t, 0.0, m.rollingMinFeeRate)
+	assertEvictionIndex(t, m)
+
+	// the original is still the one spending the output
+	rejectErr, ok = RejectFromError(m.Admit(c, makeSignedTx(privKey, parentHash, 0, 38950)))
+	assert.True(t, ok)
+	assert.Equal(t, RejectInsufficientFee, rejectErr.Code)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/common/requests"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/secure"
+)
+
+// chainRules are the consensus rules in the order they are checked by chain.CheckTransaction,
+// the error returned by the check tells which of them has failed
+var chainRules = []struct {
+	name string
+	err  error
+}{
+	{"valid-signature", chain.ErrInvalidSignature},
+	{"unique-inputs", chain.ErrDuplicateInput},
+	{"inputs-exist", chain.ErrUTXONotFound},
+	{"inputs-owned", chain.ErrInputNotOwned},
+	{"inputs-unspent", chain.ErrUTXOSpent},
+	{"non-negative-outputs", chain.ErrNegativeOutput},
+	{"inputs-cover-outputs", chain.ErrInsufficientInputs},
+}
+
+// validationReport collects the results of the rules checked by Validate
+type validationReport struct {
+	*requests.ValidateTransactionResponse
+}
+
+func (r validationReport) pass(rule string, class RejectClass) {
+	r.Rules = append(r.Rules, requests.ValidationRule{
+		Rule:   rule,
+		Class:  string(class),
+		Status: requests.ValidationPass,
+	})
+}
+
+func (r validationReport) skip(rule string, class RejectClass) {
+	r.Rules = append(r.Rules, requests.ValidationRule{
+		Rule:   rule,
+		Class:  string(class),
+		Status: requests.ValidationSkip,
+	})
+}
+
+// check records the outcome of the rule, the first failure
+// is reported as the reason the transaction would be rejected
+func (r validationReport) check(rule string, class RejectClass, err error) bool {
+	if err == nil {
+		r.pass(rule, class)
+		return true
+	}
+	rejectErr, ok := RejectFromError(err)
+	if !ok {
+		rejectErr = rejectFromChainError(err)
+	}
+	r.Rules = append(r.Rules, requests.ValidationRule{
+		Rule:   rule,
+		Class:  string(class),
+		Status: requests.ValidationFail,
+		Reason: rejectErr.Reason,
+	})
+	if r.Valid {
+		r.Valid = false
+		r.Reject = rejectErr.TxReject()
+	}
+	return false
+}
+
+// checkStandard reports the standardness rules that do not need the fee
+func (r validationReport) checkStandard(p *Policy, tx *proto.Transaction) {
+	for _, rule := range policyRules {
+		if !rule.needsFee {
+			r.check(rule.name, RejectPolicy, rule.check(p, tx, r.Size, 0))
+		}
+	}
+}
+
+// checkPolicyFee reports the standardness rules that need the fee,
+// they are skipped when the fee is not known
+func (r validationReport) checkPolicyFee(p *Policy, tx *proto.Transaction, fee int64, feeKnown bool) {
+	for _, rule := range policyRules {
+		switch {
+		case !rule.needsFee:
+		case !feeKnown:
+			r.skip(rule.name, RejectPolicy)
+		default:
+			r.check(rule.name, RejectPolicy, rule.check(p, tx, r.Size, fee))
+		}
+	}
+}
+
+// Validate runs the consensus and the mempool policy checks on the transaction
+// without adding it to the mempool and reports the outcome of every rule.
+// The error is returned only when the checks could not be run at all.
+func (m *Mempool) Validate(c *chain.Chain, tx *proto.Transaction) (*requests.ValidateTransactionResponse, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	hashTx := secure.HashTransaction(tx)
+	size := txSize(tx)
+	r := validationReport{&requests.ValidateTransactionResponse{
+		Hash:  []byte(hashTx),
+		Valid: true,
+		Size:  size,
+	}}
+
+	var duplicateErr error
+	if _, ok := m.txs[hashTx]; ok {
+		duplicateErr = newRejectError(RejectDuplicate, "transaction already exists in mempool")
+	}
+	r.check("not-in-mempool", RejectPolicy, duplicateErr)
+	r.check("well-formed", RejectConsensus, checkTransactionSanity(tx))
+	r.checkStandard(&m.conf.Policy, tx)
+	conflicts := m.conflictsOf(tx)
+	r.check("no-mempool-conflicts", RejectPolicy, m.checkConflicts(tx, conflicts))
+
+	evicted := m.withDescendants(conflicts)
+	fee, err := c.CheckTransaction(tx, mempoolView{m: m, replaced: evicted})
+	if err := r.checkChainRules(err); err != nil {
+		return nil, err
+	}
+	r.check("chain-limits", RejectPolicy, m.checkChainLimits(tx, evicted))
+	if err != nil {
+		// the fee is not known
+		r.checkPolicyFee(&m.conf.Policy, tx, 0, false)
+		r.skip("mempool-min-fee-rate", RejectPolicy)
+		r.skip("replacement-fee", RejectPolicy)
+		return r.ValidateTransactionResponse, nil
+	}
+	r.Fee = fee
+	r.FeeRate = feeRate(fee, size)
+	r.checkPolicyFee(&m.conf.Policy, tx, fee, true)
+	r.check("mempool-min-fee-rate", RejectPolicy, m.checkFeeRate(fee, size))
+	if len(evicted) > 0 {
+		r.check("replacement-fee", RejectPolicy, m.checkReplacement(fee, evicted))
+	} else {
+		r.pass("replacement-fee", RejectPolicy)
+	}
+	return r.ValidateTransactionResponse, nil
+}
+
+// checkChainRules reports the chain rules up to the one that failed with err as passed,
+// the rules after it cannot be checked. The errors that are not caused
+// by the transaction itself, e.g. the store failures, are returned.
+func (r validationReport) checkChainRules(err error) error {
+	failed := len(chainRules)
+	if err != nil {
+		failed = -1
+		for i, rule := range chainRules {
+			if errors.Is(err, rule.err) {
+				failed = i
+				break
+			}
+		}
+		if failed < 0 {
+			return fmt.Errorf("failed to validate transaction: %w", err)
+		}
+	}
+	for i, rule := range chainRules {
+		switch {
+		case i < failed:
+			r.pass(rule.name, RejectConsensus)
+		case i == failed:
+			r.check(rule.name, RejectConsensus, err)
+		default:
+			r.skip(rule.name, RejectConsensus)
+		}
+	}
+	return nil
+}
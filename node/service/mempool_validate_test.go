@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/requests"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/store"
+)
+
+func ruleStatuses(res *requests.ValidateTransactionResponse) map[string]requests.ValidationStatus {
+	statuses := make(map[string]requests.ValidationStatus, len(res.Rules))
+	for _, r := range res.Rules {
+		statuses[r.Rule] = r.Status
+	}
+	return statuses
+}
+
+func TestMempoolValidate(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	tx := makeSignedTx(privKey, genesisTxHash(t, c), 0, 1000, 98000)
+	res, err := m.Validate(c, tx)
+	assert.Nil(t, err)
+	assert.True(t, res.Valid)
+	assert.Nil(t, res.Reject)
+	assert.Equal(t, int64(1000), res.Fee)
+	assert.Equal(t, txSize(tx), res.Size)
+	for rule, status := range ruleStatuses(res) {
+		assert.Equal(t, requests.ValidationPass, status, rule)
+	}
+	// the dry run leaves the mempool untouched
+	assert.Empty(t, m.List())
+}
+
+func TestMempoolValidateReportsFailedRule(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	m := NewMempool(DefaultMempoolConfig())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+
+	tx := makeSignedTx(privKey, []byte("unknown"), 0, 1000)
+	res, err := m.Validate(c, tx)
+	assert.Nil(t, err)
+	assert.False(t, res.Valid)
+	assert.Equal(t, string(RejectMissingInputs), res.Reject.Code)
+
+	statuses := ruleStatuses(res)
+	assert.Equal(t, requests.ValidationPass, statuses["valid-signature"])
+	assert.Equal(t, requests.ValidationPass, statuses["unique-inputs"])
+	assert.Equal(t, requests.ValidationFail, statuses["inputs-exist"])
+	assert.Equal(t, requests.ValidationSkip, statuses["inputs-unspent"])
+	assert.Equal(t, requests.ValidationSkip, statuses["min-fee-rate"])
+	assert.Equal(t, requests.ValidationPass, statuses["chain-limits"])
+}
@@ -22,6 +22,13 @@ type networkManager struct {
 	peers         *peersMap
 	knownAddrs    *knownAddrs
 	logger        *zap.SugaredLogger
+	// onPeerAdded is called after the handshake with a new peer
+	onPeerAdded func(c client.Client)
+	// pruned is advertised to the peers, so they do not ask the node for the whole history
+	pruned bool
+	// prunedHeight returns the height of the last block the node keeps without its body,
+	// the peers do not ask the node for the blocks up to it
+	prunedHeight func() int
 
 	quit
 }
@@ -94,11 +101,16 @@ func (m *networkManager) dialRemote(address string) (client.Client, *proto.Versi
 }
 
 func (m *networkManager) version() *proto.Version {
-	return &proto.Version{
+	v := &proto.Version{
 		Version:       "0.0.1",
 		ListenAddress: m.ListenAddress,
 		Peers:         m.peersAddrs(context.TODO()),
+		Pruned:        m.pruned,
 	}
+	if m.prunedHeight != nil {
+		v.PrunedHeight = int32(m.prunedHeight())
+	}
+	return v
 }
 
 func (m *networkManager) Peers() map[client.Client]*peer {
@@ -131,6 +143,9 @@ func (m *networkManager) addPeer(c client.Client, v *proto.Version) {
 		return
 	}
 	m.peers.addPeer(c, v)
+	if m.onPeerAdded != nil {
+		m.onPeerAdded(c)
+	}
 
 	if len(v.Peers) > 0 {
 		go func() {
@@ -234,7 +249,7 @@ func (m *networkManager) ping(quitCh chan struct{}, logging bool) {
 			return
 		default:
 			for c, p := range m.peers.peersForPing() {
-				_, err := m.handshakeClient(c)
+				version, err := m.handshakeClient(c)
 				if err != nil {
 					if logging {
 						m.logger.Errorf("node: %s, failed to ping %s: %v\n", m, c, err)
@@ -243,7 +258,7 @@ func (m *networkManager) ping(quitCh chan struct{}, logging bool) {
 					m.peers.removePeer(c)
 					continue
 				}
-				m.peers.updateLastPingTime(c)
+				m.peers.updatePing(c, version)
 			}
 			time.Sleep(pingInterval)
 		}
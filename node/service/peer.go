@@ -22,6 +22,12 @@ func newPeer(v *proto.Version) *peer {
 	}
 }
 
+// servesBlocksFrom reports whether the peer keeps the bodies of the blocks
+// from the height up, the genesis block is never pruned
+func (p *peer) servesBlocksFrom(height int) bool {
+	return int(p.GetPrunedHeight()) < max(height, 1)
+}
+
 // ---------------------------------------------------------------------------
 
 type peersMap struct {
@@ -62,6 +68,16 @@ func (pm *peersMap) list() map[client.Client]*peer {
 	return pm.peers
 }
 
+func (pm *peersMap) clients() []client.Client {
+	pm.lock.RLock()
+	defer pm.lock.RUnlock()
+	clients := make([]client.Client, 0, len(pm.peers))
+	for c := range pm.peers {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
 func (pm *peersMap) peersForPing() map[client.Client]*peer {
 	pm.lock.RLock()
 	defer pm.lock.RUnlock()
@@ -74,10 +90,14 @@ func (pm *peersMap) peersForPing() map[client.Client]*peer {
 	return peers
 }
 
-func (pm *peersMap) updateLastPingTime(c client.Client) {
+// updatePing records the ping of the peer with the version it answered, the pruned
+// height of a peer grows with its chain
+func (pm *peersMap) updatePing(c client.Client, v *proto.Version) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
-	pm.peers[c].lastPing = time.Now()
+	if _, ok := pm.peers[c]; ok {
+		pm.peers[c] = newPeer(v)
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+)
+
+const (
+	defaultDustThreshold = 100
+	defaultMaxTxSize     = 100_000
+	defaultMaxInputs     = 1000
+	defaultMaxOutputs    = 1000
+)
+
+// OutputType tells what kind of destination the transaction output pays to
+type OutputType string
+
+const (
+	// OutputTypeAddress pays to the address of a public key
+	OutputTypeAddress OutputType = "address"
+	// OutputTypeEmpty has no address, nobody can spend it
+	OutputTypeEmpty OutputType = "empty"
+	// OutputTypeNonStandard has an address of an unknown format
+	OutputTypeNonStandard OutputType = "nonstandard"
+)
+
+func outputTypeOf(output *proto.TxOutput) OutputType {
+	switch len(output.Address) {
+	case crypto.AddressLength:
+		return OutputTypeAddress
+	case 0:
+		return OutputTypeEmpty
+	default:
+		return OutputTypeNonStandard
+	}
+}
+
+// Policy holds the standardness rules of the node. A transaction breaking them
+// is valid, but the node does not keep it in its mempool nor relay it.
+// A zero limit disables the rule.
+type Policy struct {
+	// DustThreshold is the lowest value of an output, the smaller outputs are dust
+	DustThreshold int64
+	// MaxTxSize is the maximum size of the encoded transaction in bytes
+	MaxTxSize  int
+	MaxInputs  int
+	MaxOutputs int
+	// MinFeeRate is the lowest fee per 1000 bytes relayed by the node
+	MinFeeRate int64
+	// AllowedOutputTypes lists the output types relayed by the node, empty allows all
+	AllowedOutputTypes []OutputType
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		DustThreshold:      defaultDustThreshold,
+		MaxTxSize:          defaultMaxTxSize,
+		MaxInputs:          defaultMaxInputs,
+		MaxOutputs:         defaultMaxOutputs,
+		MinFeeRate:         0,
+		AllowedOutputTypes: []OutputType{OutputTypeAddress},
+	}
+}
+
+// policyRule is a single standardness rule. The rules needing the fee
+// are checked only after the transaction passes the consensus validation.
+type policyRule struct {
+	name     string
+	needsFee bool
+	check    func(p *Policy, tx *proto.Transaction, size int, fee int64) error
+}
+
+var policyRules = []policyRule{
+	{name: "has-inputs", check: checkHasInputs},
+	{name: "has-outputs", check: checkHasOutputs},
+	{name: "positive-outputs", check: checkPositiveOutputs},
+	{name: "max-tx-size", check: checkMaxTxSize},
+	{name: "max-inputs", check: checkMaxInputs},
+	{name: "max-outputs", check: checkMaxOutputs},
+	{name: "output-types", check: checkOutputTypes},
+	{name: "dust", check: checkDust},
+	{name: "min-fee-rate", needsFee: true, check: checkMinFeeRate},
+}
+
+// checkStandard runs the rules that do not need the fee
+func (p *Policy) checkStandard(tx *proto.Transaction, size int) error {
+	for _, rule := range policyRules {
+		if rule.needsFee {
+			continue
+		}
+		if err := rule.check(p, tx, size, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFee runs the rules that need the fee
+func (p *Policy) checkFee(tx *proto.Transaction, size int, fee int64) error {
+	for _, rule := range policyRules {
+		if !rule.needsFee {
+			continue
+		}
+		if err := rule.check(p, tx, size, fee); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHasInputs refuses the transaction without inputs, the chain takes it
+// if it creates no value, but it only bloats the blocks
+func checkHasInputs(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if len(tx.Inputs) == 0 {
+		return newRejectError(RejectNonStandard, "transaction has no inputs")
+	}
+	return nil
+}
+
+func checkHasOutputs(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if len(tx.Outputs) == 0 {
+		return newRejectError(RejectNonStandard, "transaction has no outputs")
+	}
+	return nil
+}
+
+// checkPositiveOutputs refuses the zero value outputs also when the dust rule is disabled
+func checkPositiveOutputs(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	for i, output := range tx.Outputs {
+		if output.Value == 0 {
+			return newRejectErrorf(RejectNonStandard, "output %d has zero value", i)
+		}
+	}
+	return nil
+}
+
+func checkMaxTxSize(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if p.MaxTxSize > 0 && size > p.MaxTxSize {
+		return newRejectErrorf(RejectTxSize, "transaction size %d exceeds the limit %d", size, p.MaxTxSize)
+	}
+	return nil
+}
+
+func checkMaxInputs(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if p.MaxInputs > 0 && len(tx.Inputs) > p.MaxInputs {
+		return newRejectErrorf(
+			RejectTooManyInputs,
+			"transaction has %d inputs, the limit is %d",
+			len(tx.Inputs),
+			p.MaxInputs,
+		)
+	}
+	return nil
+}
+
+func checkMaxOutputs(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if p.MaxOutputs > 0 && len(tx.Outputs) > p.MaxOutputs {
+		return newRejectErrorf(
+			RejectTooManyOutputs,
+			"transaction has %d outputs, the limit is %d",
+			len(tx.Outputs),
+			p.MaxOutputs,
+		)
+	}
+	return nil
+}
+
+func checkOutputTypes(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if len(p.AllowedOutputTypes) == 0 {
+		return nil
+	}
+outputs:
+	for i, output := range tx.Outputs {
+		outputType := outputTypeOf(output)
+		for _, allowed := range p.AllowedOutputTypes {
+			if outputType == allowed {
+				continue outputs
+			}
+		}
+		return newRejectErrorf(RejectOutputType, "output %d has type %s, which is not relayed", i, outputType)
+	}
+	return nil
+}
+
+func checkDust(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	for i, output := range tx.Outputs {
+		if output.Value < p.DustThreshold {
+			return newRejectErrorf(
+				RejectDust,
+				"output %d value %d is below the dust threshold %d",
+				i,
+				output.Value,
+				p.DustThreshold,
+			)
+		}
+	}
+	return nil
+}
+
+func checkMinFeeRate(p *Policy, tx *proto.Transaction, size int, fee int64) error {
+	if rate := feeRate(fee, size); rate < p.MinFeeRate {
+		return newRejectErrorf(
+			RejectInsufficientFee,
+			"fee rate %d is below the minimum relay fee rate %d",
+			rate,
+			p.MinFeeRate,
+		)
+	}
+	return nil
+}
+
+// ParseOutputType parses the output type name, e.g. read from the node configuration
+func ParseOutputType(s string) (OutputType, error) {
+	switch t := OutputType(s); t {
+	case OutputTypeAddress, OutputTypeEmpty, OutputTypeNonStandard:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown output type %q", s)
+	}
+}
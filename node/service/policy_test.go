@@ -0,0 +1,112 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMempoolPolicy(t *testing.T) {
+	c := chain.New(store.NewChainMemoryStore())
+	privKey := crypto.PrivateKeyFromString(testGodSeed)
+	genesisHash := genesisTxHash(t, c)
+
+	emptyOutput := makeSignedTx(privKey, genesisHash, 0, 1000)
+	emptyOutput.Outputs[0].Address = nil
+	// the standardness rules are checked before the signature
+	twoInputs := makeSignedTx(privKey, genesisHash, 0, 98000)
+	twoInputs.Inputs = append(twoInputs.Inputs, twoInputs.Inputs[0])
+
+	tests := []struct {
+		name   string
+		policy func(p *Policy)
+		tx     *proto.Transaction
+		code   RejectCode
+	}{
+		{
+			name:   "dust",
+			policy: func(p *Policy) { p.DustThreshold = 5000 },
+			tx:     makeSignedTx(privKey, genesisHash, 0, 1000, 98000),
+			code:   RejectDust,
+		},
+		{
+			name:   "max tx size",
+			policy: func(p *Policy) { p.MaxTxSize = 10 },
+			tx:     makeSignedTx(privKey, genesisHash, 0, 98000),
+			code:   RejectTxSize,
+		},
+		{
+			name:   "max outputs",
+			policy: func(p *Policy) { p.MaxOutputs = 2 },
+			tx:     makeSignedTx(privKey, genesisHash, 0, 1000, 1000, 1000),
+			code:   RejectTooManyOutputs,
+		},
+		{
+			name:   "max inputs",
+			policy: func(p *Policy) { p.MaxInputs = 1 },
+			tx:     twoInputs,
+			code:   RejectTooManyInputs,
+		},
+		{
+			name:   "output type",
+			policy: func(p *Policy) {},
+			tx:     emptyOutput,
+			code:   RejectOutputType,
+		},
+		{
+			name:   "zero value output",
+			policy: func(p *Policy) { p.DustThreshold = 0 },
+			tx:     makeSignedTx(privKey, genesisHash, 0, 0, 98000),
+			code:   RejectNonStandard,
+		},
+		{
+			name:   "no outputs",
+			policy: func(p *Policy) {},
+			tx:     makeSignedTx(privKey, genesisHash, 0),
+			code:   RejectNonStandard,
+		},
+		{
+			name:   "standard",
+			policy: func(p *Policy) {},
+			tx:     makeSignedTx(privKey, genesisHash, 0, 1000, 98000),
+		},
+		{
+			name:   "min fee rate",
+			policy: func(p *Policy) { p.MinFeeRate = 100_000 },
+			tx:     makeSignedTx(privKey, genesisHash, 0, 99000),
+			code:   RejectInsufficientFee,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := DefaultMempoolConfig()
+			tt.policy(&conf.Policy)
+			m := NewMempool(conf)
+			err := m.Admit(c, tt.tx)
+			if tt.code == "" {
+				assert.Nil(t, err)
+				return
+			}
+			rejectErr, ok := RejectFromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.code, rejectErr.Code)
+			assert.Equal(t, RejectPolicy, rejectErr.Code.Class())
+		})
+	}
+}
+
+func TestRejectClass(t *testing.T) {
+	assert.Equal(t, RejectConsensus, RejectBadSignature.Class())
+	assert.Equal(t, RejectPolicy, RejectDust.Class())
+
+	st := status.Convert(newRejectError(RejectDust, "dust"))
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	st = status.Convert(newRejectError(RejectMissingInputs, "utxo not found"))
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxRateLimitKeys bounds the number of the tracked peers,
+// the idle ones are forgotten once the limit is reached
+const maxRateLimitKeys = 1024
+
+// rateLimiter is a token bucket per peer, every peer can spend up to burst tokens
+// at once and gets rate tokens back per second
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes n tokens from the bucket of the peer, it returns false
+// and takes nothing if the bucket does not hold enough tokens
+func (l *rateLimiter) allow(key string, n int, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitKeys {
+			l.forgetIdle(now)
+		}
+		b = &tokenBucket{
+			tokens: l.burst,
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+	return tokens
+}
+
+// forgetIdle drops the buckets that have refilled completely,
+// a new bucket starts full so the peers lose nothing. The caller must hold the lock
+func (l *rateLimiter) forgetIdle(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// peerHost strips the port from the remote address, so the peer cannot
+// get a fresh bucket by opening a new connection
+func peerHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(10, 20)
+	now := time.Now()
+
+	assert.True(t, l.allow("a", 20, now))
+	assert.False(t, l.allow("a", 1, now))
+	// the other peers have their own buckets
+	assert.True(t, l.allow("b", 1, now))
+
+	assert.True(t, l.allow("a", 5, now.Add(500*time.Millisecond)))
+	assert.False(t, l.allow("a", 1, now.Add(500*time.Millisecond)))
+	// the bucket never holds more than burst tokens
+	assert.False(t, l.allow("a", 21, now.Add(time.Hour)))
+}
+
+func TestPeerHost(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", peerHost("10.0.0.1:4000"))
+	assert.Equal(t, "::1", peerHost("[::1]:4000"))
+	assert.Equal(t, "bufconn", peerHost("bufconn"))
+}
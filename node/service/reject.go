@@ -0,0 +1,162 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yuriykis/microblocknet/common/requests"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rejectDomain is the domain of the gRPC error details carrying the reject code
+const rejectDomain = "mempool.microblocknet"
+
+// RejectCode tells the submitter why the transaction was not accepted to the mempool
+type RejectCode string
+
+const (
+	RejectMalformed         RejectCode = "malformed"
+	RejectDuplicate         RejectCode = "duplicate"
+	RejectBadSignature      RejectCode = "bad-signature"
+	RejectMissingInputs     RejectCode = "missing-inputs"
+	RejectNotOwned          RejectCode = "not-owned-inputs"
+	RejectSpentInputs       RejectCode = "spent-inputs"
+	RejectMempoolConflict   RejectCode = "mempool-conflict"
+	RejectInsufficientValue RejectCode = "insufficient-value"
+	RejectInsufficientFee   RejectCode = "insufficient-fee"
+	RejectMempoolFull       RejectCode = "mempool-full"
+	RejectTooLongChain      RejectCode = "too-long-mempool-chain"
+	RejectInvalid           RejectCode = "invalid"
+
+	RejectDust           RejectCode = "dust"
+	RejectTxSize         RejectCode = "tx-size"
+	RejectTooManyInputs  RejectCode = "too-many-inputs"
+	RejectTooManyOutputs RejectCode = "too-many-outputs"
+	RejectOutputType     RejectCode = "output-type"
+	RejectNonStandard    RejectCode = "non-standard"
+)
+
+// RejectClass separates the invalid transactions from the valid ones
+// the node does not accept because of its policy
+type RejectClass string
+
+const (
+	// RejectConsensus means the transaction can never be included in a block
+	RejectConsensus RejectClass = "consensus"
+	// RejectPolicy means the transaction is valid, but the node does not relay it,
+	// another node or the same one later may accept it
+	RejectPolicy RejectClass = "policy"
+)
+
+func (c RejectCode) Class() RejectClass {
+	switch c {
+	case RejectMalformed,
+		RejectBadSignature,
+		RejectMissingInputs,
+		RejectNotOwned,
+		RejectSpentInputs,
+		RejectInsufficientValue,
+		RejectInvalid:
+		return RejectConsensus
+	default:
+		return RejectPolicy
+	}
+}
+
+// RejectError is returned when the transaction does not pass the mempool admission checks
+type RejectError struct {
+	Code   RejectCode
+	Reason string
+}
+
+func newRejectError(code RejectCode, reason string) *RejectError {
+	return &RejectError{
+		Code:   code,
+		Reason: reason,
+	}
+}
+
+func newRejectErrorf(code RejectCode, format string, args ...any) *RejectError {
+	return newRejectError(code, fmt.Sprintf(format, args...))
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("transaction rejected by %s rules (%s): %s", e.Code.Class(), e.Code, e.Reason)
+}
+
+// TxReject converts the error to the form sent to the API clients
+func (e *RejectError) TxReject() *requests.TxReject {
+	return &requests.TxReject{
+		Code:   string(e.Code),
+		Class:  string(e.Code.Class()),
+		Reason: e.Reason,
+	}
+}
+
+// GRPCStatus lets the gRPC server send the reject code to the client in the status details,
+// the invalid transactions are reported as InvalidArgument and the policy rejections as FailedPrecondition
+func (e *RejectError) GRPCStatus() *status.Status {
+	code := codes.InvalidArgument
+	if e.Code.Class() == RejectPolicy {
+		code = codes.FailedPrecondition
+	}
+	st := status.New(code, e.Error())
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: string(e.Code),
+		Domain: rejectDomain,
+		Metadata: map[string]string{
+			"reason": e.Reason,
+		},
+	})
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+// RejectFromError extracts the RejectError from the error returned by the node,
+// either directly or through the gRPC status details
+func RejectFromError(err error) (*RejectError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		return rejectErr, true
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != rejectDomain {
+			continue
+		}
+		return newRejectError(RejectCode(info.Reason), info.Metadata["reason"]), true
+	}
+	return nil, false
+}
+
+// rejectFromChainError maps the chain validation error to the reject code
+func rejectFromChainError(err error) *RejectError {
+	switch {
+	case errors.Is(err, chain.ErrInvalidSignature):
+		return newRejectError(RejectBadSignature, err.Error())
+	case errors.Is(err, chain.ErrUTXONotFound):
+		return newRejectError(RejectMissingInputs, err.Error())
+	case errors.Is(err, chain.ErrInputNotOwned):
+		return newRejectError(RejectNotOwned, err.Error())
+	case errors.Is(err, chain.ErrUTXOSpent), errors.Is(err, chain.ErrDuplicateInput):
+		return newRejectError(RejectSpentInputs, err.Error())
+	case errors.Is(err, chain.ErrInsufficientInputs):
+		return newRejectError(RejectInsufficientValue, err.Error())
+	case errors.Is(err, chain.ErrNegativeOutput):
+		return newRejectError(RejectMalformed, err.Error())
+	default:
+		return newRejectError(RejectInvalid, err.Error())
+	}
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"time"
 
@@ -21,18 +23,25 @@ const (
 	miningInterval         = 5 * time.Second
 	maxMiningDuration      = 10 * time.Second
 	syncBlockchainInterval = 5 * time.Second
+	mempoolExpiryInterval  = time.Minute
+	maxBlockTxsSize        = 1_000_000
 )
 
 type Noder interface {
 	Handshake(ctx context.Context, v *proto.Version) (*proto.Version, error)
 	NewTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
+	StemTransaction(ctx context.Context, t *proto.Transaction) (*proto.Transaction, error)
 	NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, error)
 	GetBlocks(ctx context.Context, v *proto.Version) (*proto.Blocks, error)
+	GetMempool(ctx context.Context, v *proto.Version) (*proto.TxHashes, error)
+	GetMempoolTransactions(ctx context.Context, h *proto.TxHashes) (*proto.Transactions, error)
 }
 
 type Api interface {
 	Chain() *chain.Chain
 	Gate() *gatewayClient
+	Mempool() *Mempool
+	SubmitTransaction(t *proto.Transaction) (*proto.Transaction, error)
 }
 
 type NodeOpts struct {
@@ -46,7 +55,13 @@ type ServerConfig struct {
 	ApiListenAddr        string
 	GatewayAddress       string
 	ConsulServiceAddress string
-	StoreType            string
+	StoreConfig          store.Config
+	MempoolConfig        MempoolConfig
+	DandelionConfig      DandelionConfig
+	// PruneDepth is the number of the recent blocks keeping their bodies, zero keeps all of them
+	PruneDepth int
+	// UTXORootHeight overrides the height the block headers commit to the UTXO set from, zero keeps the stored one
+	UTXORootHeight int
 }
 
 type Node struct {
@@ -59,8 +74,11 @@ type Node struct {
 
 	nm *networkManager
 
-	chain   *chain.Chain
-	mempool *Mempool
+	chain             *chain.Chain
+	mempool           *Mempool
+	stempool          *Mempool
+	mempoolSyncLimits *mempoolSyncLimits
+	dandelion         *dandelion
 
 	gate          *gatewayClient
 	consulService *ConsulService
@@ -72,28 +90,39 @@ type quitNode struct {
 	showNodeInfoQuitCh   chan struct{}
 	syncBlockchainQuitCh chan struct{}
 	pingQuitCh           chan struct{}
+	mempoolExpiryQuitCh  chan struct{}
+	verifySnapshotQuitCh chan struct{}
 }
 
 func (n *Node) shutdown() {
 	close(n.showNodeInfoQuitCh)
 	close(n.syncBlockchainQuitCh)
+	close(n.mempoolExpiryQuitCh)
+	close(n.verifySnapshotQuitCh)
 }
 
 func New(conf ServerConfig) *Node {
 	logger := makeLogger()
-	st, err := store.NewChainStore(conf.StoreType)
+	st, err := store.NewChainStore(conf.StoreConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return &Node{
+	if err := store.Migrate(context.Background(), st); err != nil {
+		log.Fatal(err)
+	}
+	mempool := NewMempool(conf.MempoolConfig)
+	n := &Node{
 		ServerConfig: conf,
 
 		logger: logger,
 
 		nm: NewNetworkManager(conf.NodeListenAddress, logger),
 
-		chain:   chain.New(st),
-		mempool: NewMempool(),
+		chain:             chain.New(st),
+		mempool:           mempool,
+		stempool:          newStempool(conf.MempoolConfig, mempool),
+		mempoolSyncLimits: newMempoolSyncLimits(),
+		dandelion:         newDandelion(conf.DandelionConfig),
 
 		gate:          NewGatewayClient(conf.GatewayAddress, logger),
 		consulService: NewConsulService(logger, conf.ConsulServiceAddress),
@@ -101,17 +130,56 @@ func New(conf ServerConfig) *Node {
 		quitNode: quitNode{
 			showNodeInfoQuitCh:   make(chan struct{}),
 			syncBlockchainQuitCh: make(chan struct{}),
+			mempoolExpiryQuitCh:  make(chan struct{}),
+			verifySnapshotQuitCh: make(chan struct{}),
 		},
 	}
+	if err := n.chain.CheckID(); err != nil {
+		log.Fatal(err)
+	}
+	if repaired, err := n.chain.Repair(); err != nil {
+		log.Fatal(err)
+	} else if repaired {
+		logger.Infof("Node: %s, repaired the partially stored block at height %d", n, n.chain.Height())
+	}
+	if conf.UTXORootHeight != 0 {
+		if err := n.chain.SetUTXORootHeight(conf.UTXORootHeight); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if conf.PruneDepth != 0 {
+		if err := n.chain.EnablePruning(conf.PruneDepth); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pruned, err := n.chain.Pruned()
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.nm.pruned = pruned
+	n.nm.prunedHeight = func() int {
+		height, err := n.Chain().PrunedHeight()
+		if err != nil {
+			n.logger.Errorf("Node: %s, failed to read the pruned height: %v", n, err)
+		}
+		return height
+	}
+	n.nm.onPeerAdded = func(c client.Client) {
+		go n.syncMempool(c)
+	}
+	return n
 }
 
 func (n *Node) Start(opts NodeOpts) error {
 
+	n.loadMempool()
 	n.nm.start(opts.BootstrapNodes)
 	n.consulService.Start()
 
 	go n.syncBlockchainLoop(n.syncBlockchainQuitCh)
 	go n.showNodeInfo(n.showNodeInfoQuitCh, false, true)
+	go n.mempoolExpiryLoop(n.mempoolExpiryQuitCh)
+	go n.verifySnapshotLoop(n.verifySnapshotQuitCh)
 
 	if opts.IsMiner {
 		n.isMiner = opts.IsMiner
@@ -127,6 +195,46 @@ func (n *Node) Start(opts NodeOpts) error {
 func (n *Node) Stop() error {
 	n.shutdown()
 	n.nm.stop()
+	n.dandelion.stop()
+	if err := n.saveMempool(); err != nil {
+		return err
+	}
+	return n.closeStore()
+}
+
+// closeStore releases the store resources, e.g. the database file of the bolt store
+func (n *Node) closeStore() error {
+	closer, ok := n.chain.Store().(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := closer.Close(); err != nil {
+		return fmt.Errorf("Node: %s, failed to close store: %w", n, err)
+	}
+	return nil
+}
+
+// loadMempool restores the transactions saved on the last shutdown,
+// each of them is validated again against the current chain
+func (n *Node) loadMempool() {
+	if n.MempoolConfig.File == "" {
+		return
+	}
+	loaded, dropped, err := n.Mempool().LoadFile(n.Chain(), n.MempoolConfig.File)
+	if err != nil {
+		n.logger.Errorf("Node: %s, failed to load mempool from %s: %v", n, n.MempoolConfig.File, err)
+	}
+	n.logger.Infof("Node: %s, %d transactions loaded to mempool, %d dropped", n, loaded, dropped)
+}
+
+func (n *Node) saveMempool() error {
+	if n.MempoolConfig.File == "" {
+		return nil
+	}
+	if err := n.Mempool().SaveFile(n.MempoolConfig.File); err != nil {
+		return fmt.Errorf("Node: %s, failed to save mempool: %w", n, err)
+	}
+	n.logger.Infof("Node: %s, mempool saved to %s", n, n.MempoolConfig.File)
 	return nil
 }
 
@@ -138,8 +246,8 @@ func (n *Node) Gate() *gatewayClient {
 	return n.gate
 }
 
-func (r *Node) Mempool() *Mempool {
-	return r.mempool
+func (n *Node) Mempool() *Mempool {
+	return n.mempool
 }
 
 func (n *Node) String() string {
@@ -173,16 +281,30 @@ func (n *Node) NewTransaction(
 	}
 	n.logger.Infof("Node: %s, received transaction from %s", n, peer.Addr.String())
 
-	if n.Mempool().Contains(t) {
-		return nil, fmt.Errorf("Node: %s, transaction already exists in mempool", n)
+	if err := n.fluffTransaction(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// fluffTransaction admits the transaction to the mempool and broadcasts it to all the peers
+func (n *Node) fluffTransaction(t *proto.Transaction) error {
+	if err := n.Mempool().Admit(n.Chain(), t); err != nil {
+		if rejectErr, ok := RejectFromError(err); ok && rejectErr.Code.Class() == RejectPolicy {
+			n.logger.Infof("Node: %s, transaction not accepted by policy: %v", n, err)
+		} else {
+			n.logger.Infof("Node: %s, invalid transaction: %v", n, err)
+		}
+		return err
 	}
-	n.Mempool().Add(t)
+	n.dandelion.clearEmbargo(secure.HashTransaction(t))
+	n.stempool.Remove(t)
 	n.logger.Infof("Node: %s, transaction added to mempool", n)
 
 	// check how to broadcast transaction when peer is not available
 	go n.nm.broadcast(t)
 
-	return t, nil
+	return nil
 }
 
 func (n *Node) NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, error) {
@@ -205,31 +327,30 @@ func (n *Node) NewBlock(ctx context.Context, b *proto.Block) (*proto.Block, erro
 	return b, nil
 }
 
+// GetBlocks serves the blocks from the height of the version up to the tip,
+// a pruned node refuses the requests reaching below its pruned height
 func (n *Node) GetBlocks(ctx context.Context, v *proto.Version) (*proto.Blocks, error) {
-	blocks := &proto.Blocks{}
-	for i := 0; i < n.Chain().Height(); i++ {
-		block, err := n.Chain().GetBlockByHeight(i)
-		if err != nil {
-			return nil, err
-		}
-		blocks.Blocks = append(blocks.Blocks, block)
+	blocks, err := n.Chain().BlocksFrom(max(int(v.Height), 0))
+	if err != nil {
+		return nil, fmt.Errorf("Node: %s, failed to get blocks: %w", n, err)
 	}
-	return blocks, nil
+	return &proto.Blocks{Blocks: blocks}, nil
 }
 
-func (n *Node) addMempoolToBlock(block *proto.Block) {
-	for _, tx := range n.Mempool().List() {
-		block.Transactions = append(block.Transactions, tx)
-	}
+// blocksRequest asks a peer for its blocks from the height up
+func (n *Node) blocksRequest(height int) *proto.Version {
+	v := n.nm.version()
+	v.Height = int32(height)
+	return v
+}
 
-	// probably we should clear mempool after the block is mined
-	n.Mempool().Clear()
+func (n *Node) addMempoolToBlock(block *proto.Block) {
+	block.Transactions = append(block.Transactions, n.Mempool().BlockTransactions(maxBlockTxsSize)...)
 }
 
 func (n *Node) clearMempool(b *proto.Block) {
-	for _, tx := range b.Transactions {
-		n.Mempool().Remove(tx)
-	}
+	n.Mempool().RemoveBlockTransactions(b)
+	n.stempool.RemoveBlockTransactions(b)
 }
 
 func (n *Node) mineBlock(newBlockCh chan<- *proto.Block, stopMineBlockCh <-chan struct{}) {
@@ -250,6 +371,11 @@ func (n *Node) mineBlock(newBlockCh chan<- *proto.Block, stopMineBlockCh <-chan
 		},
 	}
 	n.addMempoolToBlock(block)
+	block.Header.UtxoRoot, err = n.Chain().NextUTXORoot(block.Transactions)
+	if err != nil {
+		n.logger.Errorf("Node: %s, failed to compute utxo root: %v", n, err)
+		return
+	}
 mine:
 	for {
 		select {
@@ -301,7 +427,12 @@ func (n *Node) minerLoop() {
 				block.PublicKey = n.PrivateKey.PublicKey().Bytes()
 				secure.SignBlock(block, n.PrivateKey)
 
-				n.Chain().AddBlock(block)
+				if err := n.Chain().AddBlock(block); err != nil {
+					n.logger.Errorf("Node: %s, failed to add mined block: %v", n, err)
+					break mining
+				}
+				// the transactions not included in the block wait for the next one
+				n.clearMempool(block)
 				n.logger.Infof("Node: %s, broadcast block: %s\n", n, secure.HashBlock(block))
 				n.nm.broadcast(block)
 				break mining
@@ -333,20 +464,47 @@ func (n *Node) showNodeInfo(quitCh chan struct{}, netLogging bool, blockchainLog
 			}
 			if blockchainLogging {
 				n.logger.Infof("Node %s, blockchain height: %d", n, n.Chain().Height())
-				n.logger.Infof("Node %s, blocks in blockchain: %v", n, len(n.Chain().Store().BlockStore(ctx).List(ctx)))
-				n.logger.Infof("Node %s, transactions in blockchain: %v", n, len(n.Chain().Store().TxStore(ctx).List(ctx)))
-				n.logger.Infof("Node %s, utxos in blockchain: %v", n, len(n.Chain().Store().UTXOStore(ctx).List(ctx)))
+				n.showStoreCounts(ctx)
 			}
 			time.Sleep(3 * time.Second)
 		}
 	}
 }
 
+// showStoreCounts logs the number of the stored blocks, transactions and UTXOs
+func (n *Node) showStoreCounts(ctx context.Context) {
+	s := n.Chain().Store()
+	blocks, err := s.BlockStore(ctx).Count(ctx)
+	if err != nil {
+		n.logger.Errorf("Node %s, failed to count blocks: %v", n, err)
+		return
+	}
+	txs, err := s.TxStore(ctx).Count(ctx)
+	if err != nil {
+		n.logger.Errorf("Node %s, failed to count transactions: %v", n, err)
+		return
+	}
+	utxos, err := s.UTXOStore(ctx).Count(ctx)
+	if err != nil {
+		n.logger.Errorf("Node %s, failed to count utxos: %v", n, err)
+		return
+	}
+	n.logger.Infof("Node %s, blocks in blockchain: %v", n, blocks)
+	n.logger.Infof("Node %s, transactions in blockchain: %v", n, txs)
+	n.logger.Infof("Node %s, utxos in blockchain: %v", n, utxos)
+}
+
 func (n *Node) processBlocks(blocks *proto.Blocks) error {
 	for _, block := range blocks.Blocks {
+		// the peer sends its blocks from the requested height, the node continues
+		// from its own tip, which may have moved since the request
+		if int(block.Header.Height) <= n.Chain().Height() {
+			continue
+		}
 		if err := n.Chain().AddBlock(block); err != nil {
 			return err
 		}
+		n.clearMempool(block)
 	}
 	return nil
 }
@@ -359,8 +517,13 @@ func (n *Node) syncBlockchainLoop(quit chan struct{}) {
 			n.logger.Infof("Node: %s, stopping syncBlockchainLoop", n)
 			return
 		default:
-			for c := range n.nm.peers.peersForPing() {
-				blocks, err := c.GetBlocks(context.Background(), n.nm.version())
+			for c, p := range n.nm.peers.peersForPing() {
+				height := n.Chain().Height() + 1
+				// a pruned peer still serves the blocks above its pruned height
+				if !p.servesBlocksFrom(height) {
+					continue
+				}
+				blocks, err := c.GetBlocks(context.Background(), n.blocksRequest(height))
 				if err != nil {
 					n.logger.Errorf("Node: %s, failed to get blocks from %s: %v", n, c, err)
 					continue
@@ -371,6 +534,70 @@ func (n *Node) syncBlockchainLoop(quit chan struct{}) {
 	}
 }
 
+// verifySnapshotLoop downloads the history below the imported UTXO snapshot
+// from the peers until one of them provides the blocks that verify it
+func (n *Node) verifySnapshotLoop(quit chan struct{}) {
+	for {
+		height, err := n.Chain().SnapshotHeight()
+		if err != nil {
+			n.logger.Errorf("Node: %s, failed to read the snapshot height: %v", n, err)
+			return
+		}
+		if height == 0 {
+			return
+		}
+		select {
+		case <-quit:
+			n.logger.Infof("Node: %s, stopping verifySnapshotLoop", n)
+			return
+		case <-time.After(syncBlockchainInterval):
+		}
+		for c, p := range n.nm.peers.peersForPing() {
+			// the replay starts from the genesis block, a pruned peer does not keep it
+			if p.GetPruned() {
+				continue
+			}
+			blocks, err := c.GetBlocks(context.Background(), n.blocksRequest(0))
+			if err != nil {
+				n.logger.Errorf("Node: %s, failed to get blocks from %s: %v", n, c, err)
+				continue
+			}
+			if len(blocks.Blocks) <= height {
+				continue
+			}
+			err = n.Chain().VerifySnapshot(blocks.Blocks)
+			if errors.Is(err, chain.ErrSnapshotMismatch) {
+				n.logger.Errorf("Node: %s, the imported snapshot at height %d is not valid: %v", n, height, err)
+				return
+			}
+			if err != nil {
+				n.logger.Errorf("Node: %s, failed to verify the snapshot with the blocks of %s: %v", n, c, err)
+				continue
+			}
+			n.logger.Infof("Node: %s, verified the history of the snapshot at height %d", n, height)
+			return
+		}
+	}
+}
+
+// mempoolExpiryLoop periodically drops the transactions waiting in the mempool for too long
+func (n *Node) mempoolExpiryLoop(quit chan struct{}) {
+	ticker := time.NewTicker(mempoolExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			n.logger.Infof("Node: %s, stopping mempoolExpiryLoop", n)
+			return
+		case now := <-ticker.C:
+			if removed := n.Mempool().Expire(now); removed > 0 {
+				n.logger.Infof("Node: %s, %d expired transactions removed from mempool", n, removed)
+			}
+			n.stempool.Expire(now)
+		}
+	}
+}
+
 func makeLogger() *zap.SugaredLogger {
 	loggerConfig := zap.NewDevelopmentConfig()
 	loggerConfig.EncoderConfig.TimeKey = "timestamp"
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/chain"
+	"github.com/yuriykis/microblocknet/node/store"
+	"go.uber.org/zap"
+)
+
+func TestGetBlocksFromHeight(t *testing.T) {
+	ctx := context.Background()
+	n := newTestNode(chain.New(store.NewChainMemoryStore()))
+
+	// the range includes the tip, here the genesis block
+	blocks, err := n.GetBlocks(ctx, n.blocksRequest(0))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(blocks.Blocks))
+	blocks, err = n.GetBlocks(ctx, &proto.Version{Height: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(blocks.Blocks))
+}
+
+func TestPeerServesBlocksFrom(t *testing.T) {
+	nm := NewNetworkManager("", zap.NewNop().Sugar())
+	nm.prunedHeight = func() int { return 3 }
+	v := nm.version()
+	assert.Equal(t, int32(3), v.PrunedHeight)
+
+	p := newPeer(v)
+	assert.False(t, p.servesBlocksFrom(3))
+	assert.True(t, p.servesBlocksFrom(4))
+	// the genesis block is never pruned
+	assert.True(t, newPeer(&proto.Version{}).servesBlocksFrom(0))
+}
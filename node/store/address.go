@@ -0,0 +1,393 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	addressTxColl = "address_tx"
+)
+
+// AddressTx is the address index entry of a confirmed transaction that credits
+// or debits the address, Received is the sum of its outputs paying to the address
+// and Sent the sum of the address outputs it spends
+type AddressTx struct {
+	Address  []byte
+	TxHash   []byte
+	Height   int32
+	Position int32
+	Received int64
+	Sent     int64
+}
+
+// Page selects a window of the ordered results, a zero Limit means no limit
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+// apply cuts the page out of the ordered results
+func (p Page) apply(n int) (int, int) {
+	start := p.Offset
+	if start > n {
+		start = n
+	}
+	end := n
+	if p.Limit > 0 && start+p.Limit < n {
+		end = start + p.Limit
+	}
+	return start, end
+}
+
+// newerThan orders the entries of an address from the most recent transaction
+func (e *AddressTx) newerThan(other *AddressTx) bool {
+	if e.Height != other.Height {
+		return e.Height > other.Height
+	}
+	return e.Position > other.Position
+}
+
+// -----------------------------------------------------------------------------
+
+type MemoryAddressTxStore struct {
+	lock    sync.RWMutex
+	entries map[string][]*AddressTx
+}
+
+func NewMemoryAddressTxStore() *MemoryAddressTxStore {
+	return &MemoryAddressTxStore{
+		entries: make(map[string][]*AddressTx),
+	}
+}
+
+func (m *MemoryAddressTxStore) Put(ctx context.Context, entry *AddressTx) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	address := string(entry.Address)
+	entries := m.remove(address, entry.TxHash)
+	i := sort.Search(len(entries), func(i int) bool {
+		return entry.newerThan(entries[i])
+	})
+	entries = append(entries, nil)
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry
+	m.entries[address] = entries
+	return nil
+}
+
+func (m *MemoryAddressTxStore) Delete(ctx context.Context, address []byte, txHash []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entries := m.remove(string(address), txHash)
+	if len(entries) == 0 {
+		delete(m.entries, string(address))
+		return nil
+	}
+	m.entries[string(address)] = entries
+	return nil
+}
+
+// remove returns the entries of the address without the transaction, the caller must hold the lock
+func (m *MemoryAddressTxStore) remove(address string, txHash []byte) []*AddressTx {
+	entries := m.entries[address]
+	for i, e := range entries {
+		if bytes.Equal(e.TxHash, txHash) {
+			return append(entries[:i:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
+
+// List returns the page of the address entries, the most recent first
+func (m *MemoryAddressTxStore) List(ctx context.Context, address []byte, page Page) ([]*AddressTx, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	entries := m.entries[string(address)]
+	start, end := page.apply(len(entries))
+	return append([]*AddressTx{}, entries[start:end]...), nil
+}
+
+// -----------------------------------------------------------------------------
+
+type mongoAddressTxDoc struct {
+	Address  []byte `bson:"address"`
+	TxHash   string `bson:"txHash"`
+	Height   int32  `bson:"height"`
+	Position int32  `bson:"position"`
+	Received int64  `bson:"received"`
+	Sent     int64  `bson:"sent"`
+}
+
+type MongoAddressTxStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoAddressTxStore(db *mongo.Database) *MongoAddressTxStore {
+	return &MongoAddressTxStore{
+		coll: db.Collection(addressTxColl),
+	}
+}
+
+func (m *MongoAddressTxStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "address", Value: 1}, {Key: "txHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "address", Value: 1}, {Key: "height", Value: -1}, {Key: "position", Value: -1}},
+		},
+	})
+	return err
+}
+
+func (m *MongoAddressTxStore) Put(ctx context.Context, entry *AddressTx) error {
+	txHash := hex.EncodeToString(entry.TxHash)
+	_, err := m.coll.ReplaceOne(
+		ctx,
+		bson.M{"address": entry.Address, "txHash": txHash},
+		mongoAddressTxDoc{
+			Address:  entry.Address,
+			TxHash:   txHash,
+			Height:   entry.Height,
+			Position: entry.Position,
+			Received: entry.Received,
+			Sent:     entry.Sent,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *MongoAddressTxStore) Delete(ctx context.Context, address []byte, txHash []byte) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{
+		"address": address,
+		"txHash":  hex.EncodeToString(txHash),
+	})
+	return err
+}
+
+// List returns the page of the address entries, the most recent first
+func (m *MongoAddressTxStore) List(ctx context.Context, address []byte, page Page) ([]*AddressTx, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "height", Value: -1}, {Key: "position", Value: -1}}).
+		SetSkip(int64(page.Offset))
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+	cur, err := m.coll.Find(ctx, bson.M{"address": address}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoAddressTxDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	entries := make([]*AddressTx, 0, len(docs))
+	for _, doc := range docs {
+		txHash, err := hex.DecodeString(doc.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &AddressTx{
+			Address:  doc.Address,
+			TxHash:   txHash,
+			Height:   doc.Height,
+			Position: doc.Position,
+			Received: doc.Received,
+			Sent:     doc.Sent,
+		})
+	}
+	return entries, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltAddressTxStore keeps the entries ordered by the key made of the address,
+// the height and the position of the transaction, so the entries of an address
+// are next to each other in the order of the chain
+type BoltAddressTxStore struct {
+	db *bolt.DB
+}
+
+func NewBoltAddressTxStore(db *bolt.DB) *BoltAddressTxStore {
+	return &BoltAddressTxStore{
+		db: db,
+	}
+}
+
+func (b *BoltAddressTxStore) Put(ctx context.Context, entry *AddressTx) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltAddressTx(tx, entry)
+	})
+}
+
+func (b *BoltAddressTxStore) Delete(ctx context.Context, address []byte, txHash []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltAddressTx(tx, address, txHash)
+	})
+}
+
+// List returns the page of the address entries, the most recent first
+func (b *BoltAddressTxStore) List(ctx context.Context, address []byte, page Page) ([]*AddressTx, error) {
+	entries := make([]*AddressTx, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := boltAddressPrefix(address)
+		c := tx.Bucket(addressTxsBucket).Cursor()
+		// walk the address entries backwards from the last one
+		k, v := c.Seek(boltPrefixEnd(prefix))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		skipped := 0
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+			if skipped < page.Offset {
+				skipped++
+				continue
+			}
+			if page.Limit > 0 && len(entries) == page.Limit {
+				break
+			}
+			entries = append(entries, decodeBoltAddressTx(address, k, v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func putBoltAddressTx(tx *bolt.Tx, entry *AddressTx) error {
+	if err := deleteBoltAddressTx(tx, entry.Address, entry.TxHash); err != nil {
+		return err
+	}
+	key := boltAddressPrefix(entry.Address)
+	key = binary.BigEndian.AppendUint32(key, uint32(entry.Height))
+	key = binary.BigEndian.AppendUint32(key, uint32(entry.Position))
+	key = append(key, entry.TxHash...)
+	value := binary.BigEndian.AppendUint64(nil, uint64(entry.Received))
+	value = binary.BigEndian.AppendUint64(value, uint64(entry.Sent))
+	return tx.Bucket(addressTxsBucket).Put(key, value)
+}
+
+// deleteBoltAddressTx looks the transaction up among the address entries,
+// the key does not tell where it is
+func deleteBoltAddressTx(tx *bolt.Tx, address []byte, txHash []byte) error {
+	prefix := boltAddressPrefix(address)
+	c := tx.Bucket(addressTxsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if bytes.Equal(k[len(prefix)+8:], txHash) {
+			return c.Delete()
+		}
+	}
+	return nil
+}
+
+// boltAddressPrefix is the length-prefixed address, so an address
+// is never the prefix of the keys of a longer one
+func boltAddressPrefix(address []byte) []byte {
+	prefix := binary.BigEndian.AppendUint16(nil, uint16(len(address)))
+	return append(prefix, address...)
+}
+
+// boltPrefixEnd is the first key after all the keys with the prefix
+func boltPrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func decodeBoltAddressTx(address []byte, k, v []byte) *AddressTx {
+	rest := k[len(boltAddressPrefix(address)):]
+	return &AddressTx{
+		Address:  append([]byte{}, address...),
+		Height:   int32(binary.BigEndian.Uint32(rest)),
+		Position: int32(binary.BigEndian.Uint32(rest[4:])),
+		TxHash:   append([]byte{}, rest[8:]...),
+		Received: int64(binary.BigEndian.Uint64(v)),
+		Sent:     int64(binary.BigEndian.Uint64(v[8:])),
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteAddressTxStore keeps the entries in the address_txs table
+type SQLiteAddressTxStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteAddressTxStore(conn sqlConn) *SQLiteAddressTxStore {
+	return &SQLiteAddressTxStore{
+		conn: conn,
+	}
+}
+
+func (s *SQLiteAddressTxStore) Put(ctx context.Context, entry *AddressTx) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		`INSERT INTO address_txs (address, tx_hash, height, position, received, sent)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (address, tx_hash) DO UPDATE SET
+			height = excluded.height,
+			position = excluded.position,
+			received = excluded.received,
+			sent = excluded.sent`,
+		entry.Address, entry.TxHash, entry.Height, entry.Position, entry.Received, entry.Sent,
+	)
+	return err
+}
+
+func (s *SQLiteAddressTxStore) Delete(ctx context.Context, address []byte, txHash []byte) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM address_txs WHERE address = ? AND tx_hash = ?`, address, txHash)
+	return err
+}
+
+// List returns the page of the address entries, the most recent first
+func (s *SQLiteAddressTxStore) List(ctx context.Context, address []byte, page Page) ([]*AddressTx, error) {
+	limit := -1
+	if page.Limit > 0 {
+		limit = page.Limit
+	}
+	rows, err := s.conn.QueryContext(
+		ctx,
+		`SELECT tx_hash, height, position, received, sent FROM address_txs
+		WHERE address = ? ORDER BY height DESC, position DESC LIMIT ? OFFSET ?`,
+		address, limit, page.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*AddressTx, 0)
+	for rows.Next() {
+		entry := &AddressTx{
+			Address: address,
+		}
+		if err := rows.Scan(&entry.TxHash, &entry.Height, &entry.Position, &entry.Received, &entry.Sent); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/node/util"
+)
+
+func testAddressTxStore(t *testing.T, addrStore AddressTxStorer) {
+	ctx := context.Background()
+	address := util.RandomHash()
+	other := append(address[:len(address):len(address)], 0)
+
+	entries := []*AddressTx{
+		{Address: address, TxHash: util.RandomHash(), Height: 1, Position: 0, Received: 100},
+		{Address: address, TxHash: util.RandomHash(), Height: 3, Position: 1, Sent: 50},
+		{Address: address, TxHash: util.RandomHash(), Height: 3, Position: 0, Received: 10, Sent: 20},
+		{Address: address, TxHash: util.RandomHash(), Height: 2, Position: 4, Received: 7},
+	}
+	for _, e := range entries {
+		assert.Nil(t, addrStore.Put(ctx, e))
+	}
+	// the longer address starting with the same bytes has its own history
+	assert.Nil(t, addrStore.Put(ctx, &AddressTx{Address: other, TxHash: util.RandomHash(), Height: 5}))
+
+	all, err := addrStore.List(ctx, address, Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, []*AddressTx{entries[1], entries[2], entries[3], entries[0]}, all)
+
+	page, err := addrStore.List(ctx, address, Page{Offset: 1, Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []*AddressTx{entries[2], entries[3]}, page)
+
+	page, err = addrStore.List(ctx, address, Page{Offset: 4, Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(page))
+
+	// putting the entry again replaces it
+	entries[0].Received = 200
+	assert.Nil(t, addrStore.Put(ctx, entries[0]))
+	assert.Nil(t, addrStore.Delete(ctx, address, entries[1].TxHash))
+	all, err = addrStore.List(ctx, address, Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, []*AddressTx{entries[2], entries[3], entries[0]}, all)
+
+	all, err = addrStore.List(ctx, other, Page{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(all))
+}
+
+func TestMemoryAddressTxStore(t *testing.T) {
+	testAddressTxStore(t, NewMemoryAddressTxStore())
+}
+
+func TestBoltAddressTxStore(t *testing.T) {
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testAddressTxStore(t, s.AddressTxStore(context.Background()))
+}
+
+func TestSQLiteAddressTxStore(t *testing.T) {
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testAddressTxStore(t, s.AddressTxStore(context.Background()))
+}
+
+func TestMongoAddressTxStore(t *testing.T) {
+	s := newTestMongoStore(t)
+	testAddressTxStore(t, s.AddressTxStore(context.Background()))
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+)
+
+// Batch collects the writes that connect a block to the chain or disconnect it,
+// Storer.Commit applies either all of them or none
+type Batch struct {
+	blocks        []*proto.Block
+	deletedBlocks []string
+	txs           []*proto.Transaction
+	// a nil UTXO stands for the deleted one
+	utxos     map[string]*proto.UTXO
+	utxoOrder []string
+	// a nil entry stands for the one deleted from the archive
+	spentUTXOs     map[string]*SpentUTXO
+	spentOrder     []string
+	addressTxs     []*AddressTx
+	deletedAddrTxs []*AddressTx
+	txLocations    []*TxLocation
+	deletedTxLocs  []string
+}
+
+func NewBatch() *Batch {
+	return &Batch{
+		utxos:      make(map[string]*proto.UTXO),
+		spentUTXOs: make(map[string]*SpentUTXO),
+	}
+}
+
+func (b *Batch) PutBlock(block *proto.Block) {
+	b.blocks = append(b.blocks, block)
+}
+
+func (b *Batch) DeleteBlock(blockHash string) {
+	b.deletedBlocks = append(b.deletedBlocks, blockHash)
+}
+
+func (b *Batch) PutTx(tx *proto.Transaction) {
+	b.txs = append(b.txs, tx)
+}
+
+// PutUTXO stages the UTXO, a later write of the same UTXO replaces the earlier one
+func (b *Batch) PutUTXO(utxo *proto.UTXO) {
+	b.stageUTXO(secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)), utxo)
+}
+
+// DeleteUTXO removes the UTXO from the store and from the batch
+func (b *Batch) DeleteUTXO(key string) {
+	b.stageUTXO(key, nil)
+}
+
+func (b *Batch) stageUTXO(key string, utxo *proto.UTXO) {
+	if _, ok := b.utxos[key]; !ok {
+		b.utxoOrder = append(b.utxoOrder, key)
+	}
+	b.utxos[key] = utxo
+}
+
+// PutSpentUTXO archives the spent output, a later write of the same output replaces the earlier one
+func (b *Batch) PutSpentUTXO(spent *SpentUTXO) {
+	b.stageSpentUTXO(spent.Key(), spent)
+}
+
+// DeleteSpentUTXO removes the output from the archive and from the batch
+func (b *Batch) DeleteSpentUTXO(key string) {
+	b.stageSpentUTXO(key, nil)
+}
+
+func (b *Batch) stageSpentUTXO(key string, spent *SpentUTXO) {
+	if _, ok := b.spentUTXOs[key]; !ok {
+		b.spentOrder = append(b.spentOrder, key)
+	}
+	b.spentUTXOs[key] = spent
+}
+
+// PutAddressTx adds the entry to the address index
+func (b *Batch) PutAddressTx(entry *AddressTx) {
+	b.addressTxs = append(b.addressTxs, entry)
+}
+
+// DeleteAddressTx removes the entry of the address and the transaction from the address index
+func (b *Batch) DeleteAddressTx(entry *AddressTx) {
+	b.deletedAddrTxs = append(b.deletedAddrTxs, entry)
+}
+
+// UTXO returns the staged UTXO, so the batch can spend the outputs it creates
+func (b *Batch) UTXO(key string) *proto.UTXO {
+	return b.utxos[key]
+}
+
+// Blocks returns the staged blocks in the order they were put
+func (b *Batch) Blocks() []*proto.Block {
+	return b.blocks
+}
+
+// DeletedBlocks returns the hashes of the deleted blocks
+func (b *Batch) DeletedBlocks() []string {
+	return b.deletedBlocks
+}
+
+// Txs returns the staged transactions in the order they were put
+func (b *Batch) Txs() []*proto.Transaction {
+	return b.txs
+}
+
+// UTXOs returns the staged UTXOs in the order they were first put
+func (b *Batch) UTXOs() []*proto.UTXO {
+	utxos := make([]*proto.UTXO, 0, len(b.utxoOrder))
+	for _, key := range b.utxoOrder {
+		if utxo := b.utxos[key]; utxo != nil {
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos
+}
+
+// DeletedUTXOs returns the keys of the deleted UTXOs
+func (b *Batch) DeletedUTXOs() []string {
+	keys := make([]string, 0)
+	for _, key := range b.utxoOrder {
+		if b.utxos[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// SpentUTXOs returns the archived outputs in the order they were first put
+func (b *Batch) SpentUTXOs() []*SpentUTXO {
+	spent := make([]*SpentUTXO, 0, len(b.spentOrder))
+	for _, key := range b.spentOrder {
+		if s := b.spentUTXOs[key]; s != nil {
+			spent = append(spent, s)
+		}
+	}
+	return spent
+}
+
+// DeletedSpentUTXOs returns the keys of the outputs to remove from the archive
+func (b *Batch) DeletedSpentUTXOs() []string {
+	keys := make([]string, 0)
+	for _, key := range b.spentOrder {
+		if b.spentUTXOs[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// AddressTxs returns the staged address index entries
+func (b *Batch) AddressTxs() []*AddressTx {
+	return b.addressTxs
+}
+
+// DeletedAddressTxs returns the address index entries to remove
+func (b *Batch) DeletedAddressTxs() []*AddressTx {
+	return b.deletedAddrTxs
+}
+
+// PutTxLocation records the block that confirms the transaction
+func (b *Batch) PutTxLocation(loc *TxLocation) {
+	b.txLocations = append(b.txLocations, loc)
+}
+
+// DeleteTxLocation removes the transaction from the location index
+func (b *Batch) DeleteTxLocation(txHash string) {
+	b.deletedTxLocs = append(b.deletedTxLocs, txHash)
+}
+
+// TxLocations returns the staged transaction locations
+func (b *Batch) TxLocations() []*TxLocation {
+	return b.txLocations
+}
+
+// DeletedTxLocations returns the hashes of the transactions to remove from the location index
+func (b *Batch) DeletedTxLocations() []string {
+	return b.deletedTxLocs
+}
@@ -2,15 +2,20 @@ package store
 
 import (
 	"context"
+	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yuriykis/microblocknet/common/proto"
 	"github.com/yuriykis/microblocknet/node/secure"
+	bolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	pb "google.golang.org/protobuf/proto"
 )
 
 const (
@@ -20,6 +25,8 @@ const (
 type MemoryBlockStore struct {
 	lock   sync.RWMutex
 	blocks map[string]*proto.Block
+	// keys are the block hashes in order, for the pages of Iterate
+	keys sortedKeys
 }
 
 func NewMemoryBlockStore() *MemoryBlockStore {
@@ -34,6 +41,7 @@ func (m *MemoryBlockStore) Put(ctx context.Context, block *proto.Block) error {
 
 	hash := secure.HashBlock(block)
 	m.blocks[hash] = block
+	m.keys.add(hash)
 	return nil
 }
 
@@ -48,6 +56,15 @@ func (m *MemoryBlockStore) Get(ctx context.Context, blockHash string) (*proto.Bl
 	return block, nil
 }
 
+func (m *MemoryBlockStore) Delete(ctx context.Context, blockHash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.blocks, blockHash)
+	m.keys.remove(blockHash)
+	return nil
+}
+
 func (m *MemoryBlockStore) List(ctx context.Context) []*proto.Block {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -59,70 +76,402 @@ func (m *MemoryBlockStore) List(ctx context.Context) []*proto.Block {
 	return blocks
 }
 
+// Iterate calls fn for the blocks of the page ordered by their hash
+func (m *MemoryBlockStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Block) error) (string, error) {
+	m.lock.RLock()
+	keys, next := m.keys.page(cursor)
+	blocks := make([]*proto.Block, 0, len(keys))
+	for _, key := range keys {
+		blocks = append(blocks, m.blocks[key])
+	}
+	m.lock.RUnlock()
+
+	for _, block := range blocks {
+		if err := fn(block); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (m *MemoryBlockStore) Count(ctx context.Context) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.blocks), nil
+}
+
 // -----------------------------------------------------------------------------
 
+// mongoBlockDoc keeps the protobuf encoded block, the hash and height
+// are stored next to it so they can be indexed
+type mongoBlockDoc struct {
+	Hash   string `bson:"hash"`
+	Height int32  `bson:"height"`
+	Data   []byte `bson:"data"`
+}
+
 type MongoBlockStore struct {
-	client *mongo.Client
-	coll   *mongo.Collection
+	coll *mongo.Collection
 }
 
-func NewMongoBlockStore(client *mongo.Client) *MongoBlockStore {
+func NewMongoBlockStore(db *mongo.Database) *MongoBlockStore {
 	return &MongoBlockStore{
-		client: client,
-		coll:   client.Database(mongoDBName).Collection(blockColl),
+		coll: db.Collection(blockColl),
 	}
 }
 
-// Put inserts a block into the database, implementing the BlockStorer interface.
-func (m *MongoBlockStore) Put(ctx context.Context, block *proto.Block) error {
-	hash := secure.HashBlock(block)
-	res, err := m.coll.InsertOne(ctx, bson.M{
-		"hash":  hex.EncodeToString([]byte(hash)),
-		"block": block,
+func (m *MongoBlockStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "height", Value: 1}},
+		},
 	})
+	return err
+}
+
+func (m *MongoBlockStore) Put(ctx context.Context, block *proto.Block) error {
+	data, err := pb.Marshal(block)
 	if err != nil {
 		return err
 	}
-	logrus.Infof("inserted block %s", res.InsertedID)
-	return nil
+	hash := hex.EncodeToString([]byte(secure.HashBlock(block)))
+	_, err = m.coll.ReplaceOne(
+		ctx,
+		bson.M{"hash": hash},
+		mongoBlockDoc{
+			Hash:   hash,
+			Height: block.Header.GetHeight(),
+			Data:   data,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
 }
 
-// Get retrieves a block from the database, implementing the BlockStorer interface.
-func (m *MongoBlockStore) Get(ctx context.Context, blockID string) (*proto.Block, error) {
-	var blockDoc struct {
-		Hash  string       `bson:"hash"`
-		Block *proto.Block `bson:"block"`
+func (m *MongoBlockStore) Get(ctx context.Context, blockHash string) (*proto.Block, error) {
+	var doc mongoBlockDoc
+	err := m.coll.FindOne(ctx, bson.M{
+		"hash": hex.EncodeToString([]byte(blockHash)),
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("block with id %s not found", blockHash)
 	}
-	if err := m.coll.FindOne(ctx, blockID).Decode(&blockDoc); err != nil {
+	if err != nil {
+		return nil, err
+	}
+	block := &proto.Block{}
+	if err := pb.Unmarshal(doc.Data, block); err != nil {
 		return nil, err
 	}
-	return blockDoc.Block, nil
+	return block, nil
+}
+
+func (m *MongoBlockStore) Delete(ctx context.Context, blockHash string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{
+		"hash": hex.EncodeToString([]byte(blockHash)),
+	})
+	return err
 }
 
-// List retrieves all blocks from the database, implementing the BlockStorer interface.
 func (m *MongoBlockStore) List(ctx context.Context) []*proto.Block {
-	blocksDocs := make([]struct {
-		Hash  string       `bson:"hash"`
-		Block *proto.Block `bson:"block"`
-	}, 0)
-	cur, err := m.coll.Find(ctx, nil)
-	if err != nil {
+	var docs []mongoBlockDoc
+	if err := findAll(ctx, m.coll, &docs); err != nil {
+		logrus.Errorf("error listing blocks: %s", err)
 		return nil
 	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		var blockDoc struct {
-			Hash  string       `bson:"hash"`
-			Block *proto.Block `bson:"block"`
+	blocks := make([]*proto.Block, 0, len(docs))
+	for _, doc := range docs {
+		block := &proto.Block{}
+		if err := pb.Unmarshal(doc.Data, block); err != nil {
+			logrus.Errorf("error decoding block %s: %s", doc.Hash, err)
+			return nil
 		}
-		if err := cur.Decode(&blockDoc); err != nil {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// Iterate calls fn for the blocks of the page ordered by their hash
+func (m *MongoBlockStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Block) error) (string, error) {
+	return iterateMongo(ctx, m.coll, "hash", cursor, func(cur *mongo.Cursor) error {
+		var doc mongoBlockDoc
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		block := &proto.Block{}
+		if err := pb.Unmarshal(doc.Data, block); err != nil {
+			return fmt.Errorf("error decoding block %s: %w", doc.Hash, err)
+		}
+		return fn(block)
+	})
+}
+
+func (m *MongoBlockStore) Count(ctx context.Context) (int, error) {
+	n, err := m.coll.CountDocuments(ctx, bson.D{})
+	return int(n), err
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltBlockStore keeps the protobuf encoded blocks by their hash
+type BoltBlockStore struct {
+	db *bolt.DB
+}
+
+func NewBoltBlockStore(db *bolt.DB) *BoltBlockStore {
+	return &BoltBlockStore{
+		db: db,
+	}
+}
+
+func (b *BoltBlockStore) Put(ctx context.Context, block *proto.Block) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltBlock(tx, block)
+	})
+}
+
+func putBoltBlock(tx *bolt.Tx, block *proto.Block) error {
+	data, err := pb.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return putBoltCounted(tx.Bucket(blocksBucket), []byte(secure.HashBlock(block)), data)
+}
+
+func (b *BoltBlockStore) Get(ctx context.Context, blockHash string) (*proto.Block, error) {
+	block := &proto.Block{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(blocksBucket).Get([]byte(blockHash))
+		if data == nil {
+			return fmt.Errorf("block with id %s not found", blockHash)
+		}
+		return pb.Unmarshal(data, block)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (b *BoltBlockStore) Delete(ctx context.Context, blockHash string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltCounted(tx.Bucket(blocksBucket), []byte(blockHash))
+	})
+}
+
+func (b *BoltBlockStore) List(ctx context.Context) []*proto.Block {
+	blocks := make([]*proto.Block, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blocksBucket).ForEach(func(k, v []byte) error {
+			block := &proto.Block{}
+			if err := pb.Unmarshal(v, block); err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
 			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("error listing blocks: %s", err)
+		return nil
+	}
+	return blocks
+}
+
+// Iterate calls fn for the blocks of the page ordered by their hash
+func (b *BoltBlockStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Block) error) (string, error) {
+	return iterateBolt(b.db, blocksBucket, cursor, func(v []byte) error {
+		block := &proto.Block{}
+		if err := pb.Unmarshal(v, block); err != nil {
+			return err
 		}
-		blocksDocs = append(blocksDocs, blockDoc)
+		return fn(block)
+	})
+}
+
+func (b *BoltBlockStore) Count(ctx context.Context) (int, error) {
+	return countBolt(b.db, blocksBucket)
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteBlockStore keeps the headers in the blocks table and links
+// the block transactions through the block_transactions table
+type SQLiteBlockStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteBlockStore(conn sqlConn) *SQLiteBlockStore {
+	return &SQLiteBlockStore{
+		conn: conn,
 	}
-	blocks := make([]*proto.Block, len(blocksDocs))
-	for i, blockDoc := range blocksDocs {
-		blocks[i] = blockDoc.Block
+}
+
+// Put inserts or replaces the block together with its transactions
+func (s *SQLiteBlockStore) Put(ctx context.Context, block *proto.Block) error {
+	return withSQLTx(ctx, s.conn, func(conn sqlConn) error {
+		return putSQLiteBlock(ctx, conn, block)
+	})
+}
+
+func (s *SQLiteBlockStore) Get(ctx context.Context, blockHash string) (*proto.Block, error) {
+	block, err := getSQLiteBlock(ctx, s.conn, []byte(blockHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("block with id %s not found", blockHash)
+	}
+	return block, err
+}
+
+// Delete removes the block, the links to its transactions go with it
+func (s *SQLiteBlockStore) Delete(ctx context.Context, blockHash string) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM blocks WHERE hash = ?`, []byte(blockHash))
+	return err
+}
+
+func (s *SQLiteBlockStore) List(ctx context.Context) []*proto.Block {
+	hashes, err := queryHashes(ctx, s.conn, `SELECT hash FROM blocks ORDER BY height`)
+	if err != nil {
+		logrus.Errorf("error listing blocks: %s", err)
+		return nil
+	}
+	blocks := make([]*proto.Block, 0, len(hashes))
+	for _, hash := range hashes {
+		block, err := getSQLiteBlock(ctx, s.conn, hash)
+		if err != nil {
+			logrus.Errorf("error listing blocks: %s", err)
+			return nil
+		}
+		blocks = append(blocks, block)
 	}
 	return blocks
 }
+
+// Iterate calls fn for the blocks of the page ordered by their hash, the page is read
+// before fn is called, since the store has a single connection
+func (s *SQLiteBlockStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Block) error) (string, error) {
+	hashes, next, err := querySQLiteHashPage(ctx, s.conn, "blocks", cursor)
+	if err != nil {
+		return "", err
+	}
+	for _, hash := range hashes {
+		block, err := getSQLiteBlock(ctx, s.conn, hash)
+		if err != nil {
+			return "", err
+		}
+		if err := fn(block); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (s *SQLiteBlockStore) Count(ctx context.Context) (int, error) {
+	return countSQLite(ctx, s.conn, "blocks")
+}
+
+func putSQLiteBlock(ctx context.Context, conn sqlConn, block *proto.Block) error {
+	hash := []byte(secure.HashBlock(block))
+	header := block.GetHeader()
+	_, err := conn.ExecContext(
+		ctx,
+		`INSERT INTO blocks (hash, version, height, prev_block_hash, merkle_root, timestamp,
+			header_hash, nonce, utxo_root, public_key, signature)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET
+			version = excluded.version,
+			height = excluded.height,
+			prev_block_hash = excluded.prev_block_hash,
+			merkle_root = excluded.merkle_root,
+			timestamp = excluded.timestamp,
+			header_hash = excluded.header_hash,
+			nonce = excluded.nonce,
+			utxo_root = excluded.utxo_root,
+			public_key = excluded.public_key,
+			signature = excluded.signature`,
+		hash,
+		header.GetVersion(),
+		header.GetHeight(),
+		header.GetPrevBlockHash(),
+		header.GetMerkleRoot(),
+		header.GetTimestamp(),
+		header.GetHash(),
+		// sqlite integers are signed, the nonce keeps its bits
+		int64(header.GetNonce()),
+		header.GetUtxoRoot(),
+		block.PublicKey,
+		block.Signature,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM block_transactions WHERE block_hash = ?`, hash); err != nil {
+		return err
+	}
+	for i, tx := range block.Transactions {
+		if err := putSQLiteTx(ctx, conn, tx); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(
+			ctx,
+			`INSERT INTO block_transactions (block_hash, position, tx_hash) VALUES (?, ?, ?)`,
+			hash, i, []byte(secure.HashTransaction(tx)),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSQLiteBlock assembles the stored block, it returns sql.ErrNoRows if there is no such block
+func getSQLiteBlock(ctx context.Context, conn sqlConn, hash []byte) (*proto.Block, error) {
+	var (
+		header = &proto.Header{}
+		block  = &proto.Block{Header: header}
+		nonce  int64
+	)
+	err := conn.QueryRowContext(
+		ctx,
+		`SELECT version, height, prev_block_hash, merkle_root, timestamp, header_hash, nonce,
+			utxo_root, public_key, signature
+		FROM blocks WHERE hash = ?`,
+		hash,
+	).Scan(
+		&header.Version,
+		&header.Height,
+		&header.PrevBlockHash,
+		&header.MerkleRoot,
+		&header.Timestamp,
+		&header.Hash,
+		&nonce,
+		&header.UtxoRoot,
+		&block.PublicKey,
+		&block.Signature,
+	)
+	if err != nil {
+		return nil, err
+	}
+	header.Nonce = uint64(nonce)
+	txHashes, err := queryHashes(
+		ctx,
+		conn,
+		`SELECT tx_hash FROM block_transactions WHERE block_hash = ? ORDER BY position`,
+		hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, txHash := range txHashes {
+		tx, err := getSQLiteTx(ctx, conn, txHash)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	return block, nil
+}
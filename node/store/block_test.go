@@ -2,11 +2,14 @@ package store
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/proto"
 	"github.com/yuriykis/microblocknet/node/secure"
 	"github.com/yuriykis/microblocknet/node/util"
+	pb "google.golang.org/protobuf/proto"
 )
 
 func TestPutBlock(t *testing.T) {
@@ -38,3 +41,132 @@ func TestListBlocks(t *testing.T) {
 	assert.Contains(t, blocks, firstBlock)
 	assert.Contains(t, blocks, secondBlock)
 }
+
+func TestBoltBlockStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	blockStore := s.BlockStore(ctx)
+
+	putBlock := util.RandomBlock()
+	assert.Nil(t, blockStore.Put(ctx, putBlock))
+	getBlock, err := blockStore.Get(ctx, secure.HashBlock(putBlock))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(putBlock, getBlock))
+
+	_, err = blockStore.Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, blockStore.Put(ctx, util.RandomBlock()))
+	assert.Equal(t, 2, len(blockStore.List(ctx)))
+}
+
+func TestBoltStorePersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chain.db")
+	s, err := NewChainBoltStore(path)
+	assert.Nil(t, err)
+	block := util.RandomBlock()
+	assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+	assert.Nil(t, s.Close())
+
+	s, err = NewChainBoltStore(path)
+	assert.Nil(t, err)
+	defer s.Close()
+	getBlock, err := s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(block, getBlock))
+}
+
+func TestBoltStoreCommit(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	block := util.RandomBlock()
+	tx := util.RandomTransaction()
+	utxo := util.RandomUTXO()
+	batch := NewBatch()
+	batch.PutBlock(block)
+	batch.PutTx(tx)
+	batch.PutUTXO(utxo)
+	spent := pb.Clone(utxo).(*proto.UTXO)
+	spent.Spent = true
+	batch.PutUTXO(spent)
+	assert.Equal(t, 1, len(batch.UTXOs()))
+	assert.Nil(t, s.Commit(ctx, batch))
+
+	_, err = s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+	_, err = s.TxStore(ctx).Get(ctx, secure.HashTransaction(tx))
+	assert.Nil(t, err)
+	getUTXO, err := s.UTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	assert.Nil(t, err)
+	assert.True(t, getUTXO.Spent)
+}
+
+func TestSQLiteBlockStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	blockStore := s.BlockStore(ctx)
+
+	block := util.RandomBlock()
+	block.Header.Nonce = 1 << 63
+	block.PublicKey = util.RandomHash()
+	block.Signature = util.RandomHash()
+	block.Transactions = []*proto.Transaction{
+		util.RandomTransaction(),
+		{
+			Inputs: []*proto.TxInput{
+				{
+					PrevTxHash: util.RandomHash(),
+					OutIndex:   1,
+					PublicKey:  util.RandomHash(),
+					Signature:  util.RandomHash(),
+				},
+			},
+			Outputs: util.RandomTransaction().Outputs,
+		},
+	}
+	assert.Nil(t, blockStore.Put(ctx, block))
+	assert.Nil(t, blockStore.Put(ctx, block))
+
+	getBlock, err := blockStore.Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(block, getBlock))
+	assert.Equal(t, 2, len(s.TxStore(ctx).List(ctx)))
+
+	_, err = blockStore.Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, blockStore.Put(ctx, util.RandomBlock()))
+	assert.Equal(t, 2, len(blockStore.List(ctx)))
+}
+
+func TestSQLiteStoreCommit(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	block := util.RandomBlock()
+	tx := util.RandomTransaction()
+	block.Transactions = []*proto.Transaction{tx}
+	utxo := util.RandomUTXO()
+	batch := NewBatch()
+	batch.PutBlock(block)
+	batch.PutTx(tx)
+	batch.PutUTXO(utxo)
+	assert.Nil(t, s.Commit(ctx, batch))
+
+	getBlock, err := s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(block, getBlock))
+	getUTXO, err := s.UTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(utxo, getUTXO))
+}
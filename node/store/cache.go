@@ -0,0 +1,397 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+)
+
+const (
+	blockCacheName = "block"
+	txCacheName    = "tx"
+	utxoCacheName  = "utxo"
+)
+
+// CacheConfig bounds the number of the entries kept by the caches
+// of the CachedStore, a cache of zero size is disabled
+type CacheConfig struct {
+	Blocks int
+	Txs    int
+	UTXOs  int
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		Blocks: 1024,
+		Txs:    8192,
+		UTXOs:  65536,
+	}
+}
+
+// Enabled reports whether any of the caches is enabled
+func (c CacheConfig) Enabled() bool {
+	return c.Blocks > 0 || c.Txs > 0 || c.UTXOs > 0
+}
+
+// cacheMetrics counts the lookups answered by the caches and the ones
+// that went to the backend, they are shared by all the cached stores
+type cacheMetrics struct {
+	hits   *prometheus.CounterVec
+	misses *prometheus.CounterVec
+}
+
+func newCacheMetrics() *cacheMetrics {
+	return &cacheMetrics{
+		hits: registerCounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_cache_hits_total",
+			Help: "Number of the store lookups answered by the cache",
+		}, []string{"cache"})),
+		misses: registerCounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "store_cache_misses_total",
+			Help: "Number of the store lookups that went to the backend",
+		}, []string{"cache"})),
+	}
+}
+
+// registerCounterVec registers the counter, or returns the one
+// registered already by another cached store
+func registerCounterVec(c *prometheus.CounterVec) *prometheus.CounterVec {
+	err := prometheus.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
+
+func (m *cacheMetrics) observe(cache string, hit bool) {
+	if hit {
+		m.hits.WithLabelValues(cache).Inc()
+	} else {
+		m.misses.WithLabelValues(cache).Inc()
+	}
+}
+
+// CachedStore is a read-through cache in front of another store, it keeps the
+// recently read blocks, transactions and UTXOs in bounded LRU caches. Every write,
+// including the ones of a committed batch, drops the written keys from the caches,
+// so the next read gets them from the backend. The other stores are not cached.
+type CachedStore struct {
+	next Storer
+
+	blockStore *CachedBlockStore
+	txStore    *CachedTxStore
+	utxoStore  *CachedUTXOStore
+}
+
+func NewCachedStore(next Storer, conf CacheConfig) (*CachedStore, error) {
+	metrics := newCacheMetrics()
+	blocks, err := newCache(conf.Blocks)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := newCache(conf.Txs)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := newCache(conf.UTXOs)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	return &CachedStore{
+		next: next,
+		blockStore: &CachedBlockStore{
+			next:    next.BlockStore(ctx),
+			cache:   blocks,
+			metrics: metrics,
+		},
+		txStore: &CachedTxStore{
+			next:    next.TxStore(ctx),
+			cache:   txs,
+			metrics: metrics,
+		},
+		utxoStore: &CachedUTXOStore{
+			next:    next.UTXOStore(ctx),
+			cache:   utxos,
+			metrics: metrics,
+		},
+	}, nil
+}
+
+// lruCache keeps the fills of the cache from racing with the writes, a value
+// read from the backend is added only if no key was invalidated since the read
+// started, otherwise it may be the one from before a write it missed
+type lruCache struct {
+	mu         sync.Mutex
+	cache      *lru.Cache
+	generation uint64
+}
+
+// newCache returns nil for a disabled cache
+func newCache(size int) (*lruCache, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &lruCache{cache: cache}, nil
+}
+
+// get returns the cached value, or the generation to pass to fill
+// with the value read from the backend on a miss
+func (c *lruCache) get(key string) (any, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.cache.Get(key)
+	return v, c.generation, ok
+}
+
+func (c *lruCache) fill(key string, value any, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation == generation {
+		c.cache.Add(key, value)
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cache.Remove(key)
+	c.generation++
+}
+
+func (c *CachedStore) UTXOStore(ctx context.Context) UTXOStorer {
+	return c.utxoStore
+}
+
+func (c *CachedStore) SpentUTXOStore(ctx context.Context) SpentUTXOStorer {
+	return c.next.SpentUTXOStore(ctx)
+}
+
+func (c *CachedStore) TxStore(ctx context.Context) TxStorer {
+	return c.txStore
+}
+
+func (c *CachedStore) BlockStore(ctx context.Context) BlockStorer {
+	return c.blockStore
+}
+
+func (c *CachedStore) AddressTxStore(ctx context.Context) AddressTxStorer {
+	return c.next.AddressTxStore(ctx)
+}
+
+func (c *CachedStore) TxLocationStore(ctx context.Context) TxLocationStorer {
+	return c.next.TxLocationStore(ctx)
+}
+
+func (c *CachedStore) MetaStore(ctx context.Context) MetaStorer {
+	return c.next.MetaStore(ctx)
+}
+
+// Commit applies the batch to the backend and drops its keys from the caches,
+// also when the commit fails, since the backend may have applied a part of it
+func (c *CachedStore) Commit(ctx context.Context, b *Batch) error {
+	err := c.next.Commit(ctx, b)
+	for _, block := range b.Blocks() {
+		c.blockStore.invalidate(secure.HashBlock(block))
+	}
+	for _, hash := range b.DeletedBlocks() {
+		c.blockStore.invalidate(hash)
+	}
+	for _, tx := range b.Txs() {
+		c.txStore.invalidate(secure.HashTransaction(tx))
+	}
+	for _, utxo := range b.UTXOs() {
+		c.utxoStore.invalidate(secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	}
+	for _, key := range b.DeletedUTXOs() {
+		c.utxoStore.invalidate(key)
+	}
+	return err
+}
+
+// Close closes the backend if it holds any resources
+func (c *CachedStore) Close() error {
+	if closer, ok := c.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type CachedBlockStore struct {
+	next    BlockStorer
+	cache   *lruCache
+	metrics *cacheMetrics
+}
+
+func (c *CachedBlockStore) Put(ctx context.Context, block *proto.Block) error {
+	defer c.invalidate(secure.HashBlock(block))
+	return c.next.Put(ctx, block)
+}
+
+func (c *CachedBlockStore) Get(ctx context.Context, blockHash string) (*proto.Block, error) {
+	if c.cache == nil {
+		return c.next.Get(ctx, blockHash)
+	}
+	v, generation, ok := c.cache.get(blockHash)
+	if ok {
+		c.metrics.observe(blockCacheName, true)
+		return v.(*proto.Block), nil
+	}
+	c.metrics.observe(blockCacheName, false)
+	block, err := c.next.Get(ctx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.fill(blockHash, block, generation)
+	return block, nil
+}
+
+func (c *CachedBlockStore) Delete(ctx context.Context, blockHash string) error {
+	defer c.invalidate(blockHash)
+	return c.next.Delete(ctx, blockHash)
+}
+
+func (c *CachedBlockStore) List(ctx context.Context) []*proto.Block {
+	return c.next.List(ctx)
+}
+
+func (c *CachedBlockStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Block) error) (string, error) {
+	return c.next.Iterate(ctx, cursor, fn)
+}
+
+func (c *CachedBlockStore) Count(ctx context.Context) (int, error) {
+	return c.next.Count(ctx)
+}
+
+func (c *CachedBlockStore) invalidate(blockHash string) {
+	c.cache.remove(blockHash)
+}
+
+// -----------------------------------------------------------------------------
+
+type CachedTxStore struct {
+	next    TxStorer
+	cache   *lruCache
+	metrics *cacheMetrics
+}
+
+func (c *CachedTxStore) Put(ctx context.Context, tx *proto.Transaction) error {
+	defer c.invalidate(secure.HashTransaction(tx))
+	return c.next.Put(ctx, tx)
+}
+
+func (c *CachedTxStore) Get(ctx context.Context, txHash string) (*proto.Transaction, error) {
+	if c.cache == nil {
+		return c.next.Get(ctx, txHash)
+	}
+	v, generation, ok := c.cache.get(txHash)
+	if ok {
+		c.metrics.observe(txCacheName, true)
+		return v.(*proto.Transaction), nil
+	}
+	c.metrics.observe(txCacheName, false)
+	tx, err := c.next.Get(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.fill(txHash, tx, generation)
+	return tx, nil
+}
+
+func (c *CachedTxStore) List(ctx context.Context) []*proto.Transaction {
+	return c.next.List(ctx)
+}
+
+func (c *CachedTxStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Transaction) error) (string, error) {
+	return c.next.Iterate(ctx, cursor, fn)
+}
+
+func (c *CachedTxStore) Count(ctx context.Context) (int, error) {
+	return c.next.Count(ctx)
+}
+
+func (c *CachedTxStore) invalidate(txHash string) {
+	c.cache.remove(txHash)
+}
+
+// -----------------------------------------------------------------------------
+
+type CachedUTXOStore struct {
+	next    UTXOStorer
+	cache   *lruCache
+	metrics *cacheMetrics
+}
+
+func (c *CachedUTXOStore) Put(ctx context.Context, utxo *proto.UTXO) error {
+	defer c.invalidate(secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	return c.next.Put(ctx, utxo)
+}
+
+// Get caches the found UTXOs only, the missing ones may be created
+// by a batch committed to the backend through another store
+func (c *CachedUTXOStore) Get(ctx context.Context, key string) (*proto.UTXO, error) {
+	if c.cache == nil {
+		return c.next.Get(ctx, key)
+	}
+	v, generation, ok := c.cache.get(key)
+	if ok {
+		c.metrics.observe(utxoCacheName, true)
+		return v.(*proto.UTXO), nil
+	}
+	c.metrics.observe(utxoCacheName, false)
+	utxo, err := c.next.Get(ctx, key)
+	if err != nil || utxo == nil {
+		return utxo, err
+	}
+	c.cache.fill(key, utxo, generation)
+	return utxo, nil
+}
+
+func (c *CachedUTXOStore) Delete(ctx context.Context, key string) error {
+	defer c.invalidate(key)
+	return c.next.Delete(ctx, key)
+}
+
+func (c *CachedUTXOStore) List(ctx context.Context) []*proto.UTXO {
+	return c.next.List(ctx)
+}
+
+func (c *CachedUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error) {
+	return c.next.GetByAddress(ctx, address)
+}
+
+func (c *CachedUTXOStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.UTXO) error) (string, error) {
+	return c.next.Iterate(ctx, cursor, fn)
+}
+
+func (c *CachedUTXOStore) Count(ctx context.Context) (int, error) {
+	return c.next.Count(ctx)
+}
+
+func (c *CachedUTXOStore) invalidate(key string) {
+	c.cache.remove(key)
+}
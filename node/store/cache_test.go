@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/util"
+)
+
+func TestCachedStoreReadThrough(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewCachedStore(NewChainMemoryStore(), CacheConfig{Blocks: 2, Txs: 2, UTXOs: 2})
+	assert.Nil(t, err)
+	metrics := newCacheMetrics()
+	hits := testutil.ToFloat64(metrics.hits.WithLabelValues(blockCacheName))
+	misses := testutil.ToFloat64(metrics.misses.WithLabelValues(blockCacheName))
+
+	block := util.RandomBlock()
+	assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+	for i := 0; i < 3; i++ {
+		got, err := s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+		assert.Nil(t, err)
+		assert.Equal(t, block, got)
+	}
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.hits.WithLabelValues(blockCacheName)))
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.misses.WithLabelValues(blockCacheName)))
+
+	// a missing block is not cached
+	_, err = s.BlockStore(ctx).Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+	assert.Nil(t, s.BlockStore(ctx).Delete(ctx, secure.HashBlock(block)))
+	_, err = s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.NotNil(t, err)
+
+	// a missing utxo is not cached either, it shows up once it is put
+	utxo := &proto.UTXO{
+		TxHash:   util.RandomHash(),
+		OutIndex: 0,
+		Output:   &proto.TxOutput{Value: 10, Address: util.RandomHash()},
+	}
+	key := secure.MakeUTXOKey(utxo.TxHash, 0)
+	got, err := s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+	assert.Nil(t, s.UTXOStore(ctx).Put(ctx, utxo))
+	got, err = s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, utxo, got)
+}
+
+func TestCachedStoreCommitInvalidates(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	s, err := NewCachedStore(backend, DefaultCacheConfig())
+	assert.Nil(t, err)
+	defer s.Close()
+
+	utxo := &proto.UTXO{
+		TxHash:   util.RandomHash(),
+		OutIndex: 1,
+		Output:   &proto.TxOutput{Value: 10, Address: util.RandomHash()},
+	}
+	key := secure.MakeUTXOKey(utxo.TxHash, 1)
+	block := util.RandomBlock()
+	batch := NewBatch()
+	batch.PutBlock(block)
+	batch.PutUTXO(utxo)
+	assert.Nil(t, s.Commit(ctx, batch))
+	cached, err := s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.False(t, cached.Spent)
+	_, err = s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+
+	// the committed batch replaces the cached entries
+	spent := &proto.UTXO{
+		TxHash:   utxo.TxHash,
+		OutIndex: utxo.OutIndex,
+		Output:   utxo.Output,
+		Spent:    true,
+	}
+	batch = NewBatch()
+	batch.PutUTXO(spent)
+	batch.DeleteBlock(secure.HashBlock(block))
+	assert.Nil(t, s.Commit(ctx, batch))
+	got, err := s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.True(t, got.Spent)
+	_, err = s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.NotNil(t, err)
+
+	batch = NewBatch()
+	batch.DeleteUTXO(key)
+	assert.Nil(t, s.Commit(ctx, batch))
+	got, err = s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+}
+
+func TestCachedStoreDisabledCache(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewCachedStore(NewChainMemoryStore(), CacheConfig{})
+	assert.Nil(t, err)
+	tx := util.RandomTransaction()
+	assert.Nil(t, s.TxStore(ctx).Put(ctx, tx))
+	got, err := s.TxStore(ctx).Get(ctx, secure.HashTransaction(tx))
+	assert.Nil(t, err)
+	assert.Equal(t, tx, got)
+}
+
+// pausedUTXOStore holds the read from the backend until the test lets it go,
+// the value is the one read before the pause
+type pausedUTXOStore struct {
+	UTXOStorer
+	read   chan struct{}
+	resume chan struct{}
+}
+
+func (s *pausedUTXOStore) Get(ctx context.Context, key string) (*proto.UTXO, error) {
+	utxo, err := s.UTXOStorer.Get(ctx, key)
+	s.read <- struct{}{}
+	<-s.resume
+	return utxo, err
+}
+
+func TestCachedStoreStaleFill(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewCachedStore(NewChainMemoryStore(), DefaultCacheConfig())
+	assert.Nil(t, err)
+	utxo := util.RandomUTXO()
+	key := secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))
+	assert.Nil(t, s.UTXOStore(ctx).Put(ctx, utxo))
+
+	paused := &pausedUTXOStore{
+		UTXOStorer: s.utxoStore.next,
+		read:       make(chan struct{}),
+		resume:     make(chan struct{}),
+	}
+	s.utxoStore.next = paused
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got, err := s.UTXOStore(ctx).Get(ctx, key)
+		assert.Nil(t, err)
+		assert.Equal(t, utxo, got)
+	}()
+
+	// the output is spent after the read, before the value gets to the cache
+	<-paused.read
+	batch := NewBatch()
+	batch.DeleteUTXO(key)
+	assert.Nil(t, s.Commit(ctx, batch))
+	close(paused.resume)
+	<-done
+
+	s.utxoStore.next = paused.UTXOStorer
+	got, err := s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+}
+
+func TestCachedStoreConcurrentCommits(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewCachedStore(NewChainMemoryStore(), DefaultCacheConfig())
+	assert.Nil(t, err)
+	utxo := util.RandomUTXO()
+	key := secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_, err := s.UTXOStore(ctx).Get(ctx, key)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	for j := 0; j < 200; j++ {
+		batch := NewBatch()
+		if j%2 == 0 {
+			batch.PutUTXO(utxo)
+		} else {
+			batch.DeleteUTXO(key)
+		}
+		assert.Nil(t, s.Commit(ctx, batch))
+	}
+	wg.Wait()
+
+	// the last commit spent the output, the cache must not have kept it
+	got, err := s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+}
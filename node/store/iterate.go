@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cursor selects a page of the entries in the key order of the store, the page
+// starts after the After key, or with the first entry if After is empty,
+// a zero Limit means no limit
+type Cursor struct {
+	After string
+	Limit int
+}
+
+// sortedKeys keeps the keys of a memory store in order, so the page of a cursor
+// is found by a binary search instead of sorting all the keys
+type sortedKeys []string
+
+// add inserts the key unless it is there already
+func (s *sortedKeys) add(key string) {
+	keys := *s
+	i := sort.SearchStrings(keys, key)
+	if i < len(keys) && keys[i] == key {
+		return
+	}
+	keys = append(keys, "")
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+	*s = keys
+}
+
+func (s *sortedKeys) remove(key string) {
+	keys := *s
+	i := sort.SearchStrings(keys, key)
+	if i == len(keys) || keys[i] != key {
+		return
+	}
+	copy(keys[i:], keys[i+1:])
+	keys[len(keys)-1] = ""
+	*s = keys[:len(keys)-1]
+}
+
+// page returns a copy of the keys selected by the cursor and the key
+// to continue after, empty when there are no more entries
+func (s sortedKeys) page(cursor Cursor) ([]string, string) {
+	start := sort.Search(len(s), func(i int) bool {
+		return s[i] > cursor.After
+	})
+	end, next := len(s), ""
+	if cursor.Limit > 0 && end-start > cursor.Limit {
+		end = start + cursor.Limit
+		next = s[end-1]
+	}
+	page := make([]string, end-start)
+	copy(page, s[start:end])
+	return page, next
+}
+
+// iterateBolt calls fn with the values of the bucket page selected by the cursor,
+// it returns the key to continue after, empty when there are no more entries
+func iterateBolt(db *bolt.DB, bucket []byte, cursor Cursor, fn func(v []byte) error) (string, error) {
+	next := ""
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		k, v := c.First()
+		if cursor.After != "" {
+			k, v = c.Seek([]byte(cursor.After))
+			if k != nil && string(k) == cursor.After {
+				k, v = c.Next()
+			}
+		}
+		var last string
+		for n := 0; k != nil; k, v = c.Next() {
+			if cursor.Limit > 0 && n == cursor.Limit {
+				next = last
+				return nil
+			}
+			if err := fn(v); err != nil {
+				return err
+			}
+			// the key is only valid in the transaction, the conversion copies it
+			last = string(k)
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+// countedBoltBuckets keep the number of their keys in the bucket sequence,
+// so the stores count them without walking the bucket
+var countedBoltBuckets = [][]byte{blocksBucket, txsBucket, utxosBucket, spentUTXOsBucket}
+
+// initBoltCounts sets the key counts of a database written before they were kept,
+// a bucket with keys and a zero sequence was never counted
+func initBoltCounts(tx *bolt.Tx) error {
+	for _, name := range countedBoltBuckets {
+		b := tx.Bucket(name)
+		if b.Sequence() != 0 {
+			continue
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			continue
+		}
+		if err := b.SetSequence(uint64(b.Stats().KeyN)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putBoltCounted puts the value, counting the key if it is new
+func putBoltCounted(b *bolt.Bucket, key, value []byte) error {
+	if b.Get(key) == nil {
+		if err := b.SetSequence(b.Sequence() + 1); err != nil {
+			return err
+		}
+	}
+	return b.Put(key, value)
+}
+
+// deleteBoltCounted deletes the key, uncounting it if it was there
+func deleteBoltCounted(b *bolt.Bucket, key []byte) error {
+	if b.Get(key) != nil {
+		if err := b.SetSequence(b.Sequence() - 1); err != nil {
+			return err
+		}
+	}
+	return b.Delete(key)
+}
+
+func countBolt(db *bolt.DB, bucket []byte) (int, error) {
+	var n int
+	err := db.View(func(tx *bolt.Tx) error {
+		n = int(tx.Bucket(bucket).Sequence())
+		return nil
+	})
+	return n, err
+}
+
+// iterateMongo calls fn with the documents of the page selected by the cursor, ordered
+// by the hex encoded key field. It returns the key to continue after, empty when
+// there are no more documents.
+func iterateMongo(ctx context.Context, coll *mongo.Collection, field string, cursor Cursor, fn func(cur *mongo.Cursor) error) (string, error) {
+	filter := bson.M{}
+	if cursor.After != "" {
+		filter[field] = bson.M{"$gt": hex.EncodeToString([]byte(cursor.After))}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: field, Value: 1}})
+	if cursor.Limit > 0 {
+		// the one more document tells whether there is a next page
+		opts.SetLimit(int64(cursor.Limit) + 1)
+	}
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	defer cur.Close(ctx)
+	var last string
+	for n := 0; cur.Next(ctx); n++ {
+		if cursor.Limit > 0 && n == cursor.Limit {
+			return last, nil
+		}
+		if err := fn(cur); err != nil {
+			return "", err
+		}
+		key, err := hex.DecodeString(cur.Current.Lookup(field).StringValue())
+		if err != nil {
+			return "", err
+		}
+		last = string(key)
+	}
+	return "", cur.Err()
+}
+
+// sqlitePageLimit is the LIMIT of the query reading the page of the cursor,
+// the one more row tells whether there is a next page
+func sqlitePageLimit(cursor Cursor) int {
+	if cursor.Limit > 0 {
+		return cursor.Limit + 1
+	}
+	return -1
+}
+
+// querySQLiteHashPage returns the hashes of the table page selected by the cursor,
+// the key to continue after is the last of them, empty when there are no more rows
+func querySQLiteHashPage(ctx context.Context, conn sqlConn, table string, cursor Cursor) ([][]byte, string, error) {
+	hashes, err := queryHashes(
+		ctx,
+		conn,
+		`SELECT hash FROM `+table+` WHERE hash > ? ORDER BY hash LIMIT ?`,
+		[]byte(cursor.After),
+		sqlitePageLimit(cursor),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if cursor.Limit > 0 && len(hashes) > cursor.Limit {
+		hashes = hashes[:cursor.Limit]
+		return hashes, string(hashes[len(hashes)-1]), nil
+	}
+	return hashes, "", nil
+}
+
+func countSQLite(ctx context.Context, conn sqlConn, table string) (int, error) {
+	var n int
+	err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+func testIterate(t *testing.T, s Storer) {
+	ctx := context.Background()
+	blocks := make(map[string]bool)
+	txs := make(map[string]bool)
+	utxos := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		block := util.RandomBlock()
+		assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+		blocks[secure.HashBlock(block)] = true
+		tx := util.RandomTransaction()
+		assert.Nil(t, s.TxStore(ctx).Put(ctx, tx))
+		txs[secure.HashTransaction(tx)] = true
+		utxo := util.RandomUTXO()
+		assert.Nil(t, s.UTXOStore(ctx).Put(ctx, utxo))
+		utxos[secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))] = true
+	}
+
+	n, err := s.BlockStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = s.TxStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	n, err = s.UTXOStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	// the pages of two cover every entry once
+	pages := 0
+	seen := make(map[string]bool)
+	for cursor := (Cursor{Limit: 2}); ; pages++ {
+		next, err := s.BlockStore(ctx).Iterate(ctx, cursor, func(block *proto.Block) error {
+			hash := secure.HashBlock(block)
+			assert.False(t, seen[hash])
+			seen[hash] = true
+			return nil
+		})
+		assert.Nil(t, err)
+		if next == "" {
+			break
+		}
+		cursor.After = next
+	}
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, blocks, seen)
+
+	seen = make(map[string]bool)
+	for cursor := (Cursor{Limit: 3}); ; {
+		next, err := s.TxStore(ctx).Iterate(ctx, cursor, func(tx *proto.Transaction) error {
+			seen[secure.HashTransaction(tx)] = true
+			return nil
+		})
+		assert.Nil(t, err)
+		if next == "" {
+			break
+		}
+		cursor.After = next
+	}
+	assert.Equal(t, txs, seen)
+
+	seen = make(map[string]bool)
+	for cursor := (Cursor{Limit: 2}); ; {
+		next, err := s.UTXOStore(ctx).Iterate(ctx, cursor, func(utxo *proto.UTXO) error {
+			seen[secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))] = true
+			return nil
+		})
+		assert.Nil(t, err)
+		if next == "" {
+			break
+		}
+		cursor.After = next
+	}
+	assert.Equal(t, utxos, seen)
+
+	// no limit reads everything at once, the page of the exact size has no next one
+	count := 0
+	next, err := s.UTXOStore(ctx).Iterate(ctx, Cursor{}, func(utxo *proto.UTXO) error {
+		count++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", next)
+	assert.Equal(t, 5, count)
+	next, err = s.BlockStore(ctx).Iterate(ctx, Cursor{Limit: 5}, func(block *proto.Block) error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", next)
+
+	// the error of fn stops the iteration
+	errStop := errors.New("stop")
+	count = 0
+	_, err = s.TxStore(ctx).Iterate(ctx, Cursor{}, func(tx *proto.Transaction) error {
+		count++
+		return errStop
+	})
+	assert.True(t, errors.Is(err, errStop))
+	assert.Equal(t, 1, count)
+
+	// putting an entry again does not count it twice, deleting a missing one changes nothing
+	for hash := range blocks {
+		block, err := s.BlockStore(ctx).Get(ctx, hash)
+		assert.Nil(t, err)
+		assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+		break
+	}
+	n, err = s.BlockStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	for key := range utxos {
+		assert.Nil(t, s.UTXOStore(ctx).Delete(ctx, key))
+		assert.Nil(t, s.UTXOStore(ctx).Delete(ctx, key))
+		delete(utxos, key)
+		break
+	}
+	n, err = s.UTXOStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	seen = make(map[string]bool)
+	_, err = s.UTXOStore(ctx).Iterate(ctx, Cursor{}, func(utxo *proto.UTXO) error {
+		seen[secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))] = true
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, utxos, seen)
+}
+
+func TestMemoryIterate(t *testing.T) {
+	testIterate(t, NewChainMemoryStore())
+}
+
+func TestBoltIterate(t *testing.T) {
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testIterate(t, s)
+}
+
+func TestBoltCountsInitialized(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chain.db")
+	s, err := NewChainBoltStore(path)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, s.UTXOStore(ctx).Put(ctx, util.RandomUTXO()))
+	}
+	// a database written before the counts were kept has zero sequences
+	assert.Nil(t, s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(utxosBucket).SetSequence(0)
+	}))
+	assert.Nil(t, s.Close())
+
+	s, err = NewChainBoltStore(path)
+	assert.Nil(t, err)
+	defer s.Close()
+	n, err := s.UTXOStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestSQLiteIterate(t *testing.T) {
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testIterate(t, s)
+}
+
+func TestMongoIterate(t *testing.T) {
+	testIterate(t, newTestMongoStore(t))
+}
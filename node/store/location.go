@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	txLocationColl = "tx_location"
+)
+
+// TxLocation tells in which block of the main chain a transaction is confirmed,
+// Position is the index of the transaction in the block
+type TxLocation struct {
+	TxHash    []byte
+	BlockHash []byte
+	Height    int32
+	Position  int32
+}
+
+// -----------------------------------------------------------------------------
+
+type MemoryTxLocationStore struct {
+	lock      sync.RWMutex
+	locations map[string]*TxLocation
+}
+
+func NewMemoryTxLocationStore() *MemoryTxLocationStore {
+	return &MemoryTxLocationStore{
+		locations: make(map[string]*TxLocation),
+	}
+}
+
+func (m *MemoryTxLocationStore) Put(ctx context.Context, loc *TxLocation) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.locations[string(loc.TxHash)] = loc
+	return nil
+}
+
+// Get returns nil if the transaction is not confirmed
+func (m *MemoryTxLocationStore) Get(ctx context.Context, txHash string) (*TxLocation, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.locations[txHash], nil
+}
+
+func (m *MemoryTxLocationStore) Delete(ctx context.Context, txHash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.locations, txHash)
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type mongoTxLocationDoc struct {
+	Hash      string `bson:"hash"`
+	BlockHash string `bson:"blockHash"`
+	Height    int32  `bson:"height"`
+	Position  int32  `bson:"position"`
+}
+
+type MongoTxLocationStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoTxLocationStore(db *mongo.Database) *MongoTxLocationStore {
+	return &MongoTxLocationStore{
+		coll: db.Collection(txLocationColl),
+	}
+}
+
+func (m *MongoTxLocationStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (m *MongoTxLocationStore) Put(ctx context.Context, loc *TxLocation) error {
+	hash := hex.EncodeToString(loc.TxHash)
+	_, err := m.coll.ReplaceOne(
+		ctx,
+		bson.M{"hash": hash},
+		mongoTxLocationDoc{
+			Hash:      hash,
+			BlockHash: hex.EncodeToString(loc.BlockHash),
+			Height:    loc.Height,
+			Position:  loc.Position,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Get returns nil if the transaction is not confirmed
+func (m *MongoTxLocationStore) Get(ctx context.Context, txHash string) (*TxLocation, error) {
+	var doc mongoTxLocationDoc
+	err := m.coll.FindOne(ctx, bson.M{
+		"hash": hex.EncodeToString([]byte(txHash)),
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blockHash, err := hex.DecodeString(doc.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	return &TxLocation{
+		TxHash:    []byte(txHash),
+		BlockHash: blockHash,
+		Height:    doc.Height,
+		Position:  doc.Position,
+	}, nil
+}
+
+func (m *MongoTxLocationStore) Delete(ctx context.Context, txHash string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{
+		"hash": hex.EncodeToString([]byte(txHash)),
+	})
+	return err
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltTxLocationStore keeps the locations keyed by the transaction hash,
+// the value is the height and the position followed by the block hash
+type BoltTxLocationStore struct {
+	db *bolt.DB
+}
+
+func NewBoltTxLocationStore(db *bolt.DB) *BoltTxLocationStore {
+	return &BoltTxLocationStore{
+		db: db,
+	}
+}
+
+func (b *BoltTxLocationStore) Put(ctx context.Context, loc *TxLocation) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltTxLocation(tx, loc)
+	})
+}
+
+// Get returns nil if the transaction is not confirmed
+func (b *BoltTxLocationStore) Get(ctx context.Context, txHash string) (*TxLocation, error) {
+	var loc *TxLocation
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(txLocationsBucket).Get([]byte(txHash))
+		if v == nil {
+			return nil
+		}
+		loc = &TxLocation{
+			TxHash:    []byte(txHash),
+			Height:    int32(binary.BigEndian.Uint32(v)),
+			Position:  int32(binary.BigEndian.Uint32(v[4:])),
+			BlockHash: append([]byte{}, v[8:]...),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+func (b *BoltTxLocationStore) Delete(ctx context.Context, txHash string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(txLocationsBucket).Delete([]byte(txHash))
+	})
+}
+
+func putBoltTxLocation(tx *bolt.Tx, loc *TxLocation) error {
+	value := binary.BigEndian.AppendUint32(nil, uint32(loc.Height))
+	value = binary.BigEndian.AppendUint32(value, uint32(loc.Position))
+	value = append(value, loc.BlockHash...)
+	return tx.Bucket(txLocationsBucket).Put(loc.TxHash, value)
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteTxLocationStore keeps the locations in the tx_locations table
+type SQLiteTxLocationStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteTxLocationStore(conn sqlConn) *SQLiteTxLocationStore {
+	return &SQLiteTxLocationStore{
+		conn: conn,
+	}
+}
+
+func (s *SQLiteTxLocationStore) Put(ctx context.Context, loc *TxLocation) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		`INSERT INTO tx_locations (tx_hash, block_hash, height, position)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (tx_hash) DO UPDATE SET
+			block_hash = excluded.block_hash,
+			height = excluded.height,
+			position = excluded.position`,
+		loc.TxHash, loc.BlockHash, loc.Height, loc.Position,
+	)
+	return err
+}
+
+// Get returns nil if the transaction is not confirmed
+func (s *SQLiteTxLocationStore) Get(ctx context.Context, txHash string) (*TxLocation, error) {
+	loc := &TxLocation{
+		TxHash: []byte(txHash),
+	}
+	err := s.conn.QueryRowContext(
+		ctx,
+		`SELECT block_hash, height, position FROM tx_locations WHERE tx_hash = ?`,
+		[]byte(txHash),
+	).Scan(&loc.BlockHash, &loc.Height, &loc.Position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+func (s *SQLiteTxLocationStore) Delete(ctx context.Context, txHash string) error {
+	_, err := s.conn.ExecContext(ctx, `DELETE FROM tx_locations WHERE tx_hash = ?`, []byte(txHash))
+	return err
+}
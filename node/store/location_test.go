@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/node/util"
+)
+
+func testTxLocationStore(t *testing.T, locStore TxLocationStorer) {
+	ctx := context.Background()
+	loc := &TxLocation{
+		TxHash:    util.RandomHash(),
+		BlockHash: util.RandomHash(),
+		Height:    7,
+		Position:  2,
+	}
+	assert.Nil(t, locStore.Put(ctx, loc))
+
+	got, err := locStore.Get(ctx, string(loc.TxHash))
+	assert.Nil(t, err)
+	assert.Equal(t, loc, got)
+
+	// putting the location again moves the transaction to the other block
+	moved := &TxLocation{
+		TxHash:    loc.TxHash,
+		BlockHash: util.RandomHash(),
+		Height:    8,
+		Position:  0,
+	}
+	assert.Nil(t, locStore.Put(ctx, moved))
+	got, err = locStore.Get(ctx, string(loc.TxHash))
+	assert.Nil(t, err)
+	assert.Equal(t, moved, got)
+
+	assert.Nil(t, locStore.Delete(ctx, string(loc.TxHash)))
+	got, err = locStore.Get(ctx, string(loc.TxHash))
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+}
+
+func TestMemoryTxLocationStore(t *testing.T) {
+	testTxLocationStore(t, NewMemoryTxLocationStore())
+}
+
+func TestBoltTxLocationStore(t *testing.T) {
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testTxLocationStore(t, s.TxLocationStore(context.Background()))
+}
+
+func TestSQLiteTxLocationStore(t *testing.T) {
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testTxLocationStore(t, s.TxLocationStore(context.Background()))
+}
+
+func TestMongoTxLocationStore(t *testing.T) {
+	s := newTestMongoStore(t)
+	testTxLocationStore(t, s.TxLocationStore(context.Background()))
+}
@@ -0,0 +1,364 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yuriykis/microblocknet/common/proto"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	metaColl = "meta"
+	// metaDocID is the id of the single document of the meta collection
+	metaDocID = "chain"
+)
+
+var (
+	metaVersionKey        = []byte("version")
+	metaChainIDKey        = []byte("chain_id")
+	metaSnapshotHeightKey = []byte("snapshot_height")
+	metaSnapshotHashKey   = []byte("snapshot_hash")
+	metaPrunedHeightKey   = []byte("pruned_height")
+	metaUTXORootHeightKey = []byte("utxo_root_height")
+)
+
+// ErrSchemaTooNew is returned when the store was written by a newer version of the node
+var ErrSchemaTooNew = errors.New("store schema is newer than the supported version")
+
+// Meta describes the data of the store, Version is the schema version the data
+// is written in and ChainID the hash of the genesis block of the chain it holds.
+// SnapshotHeight is the height of the UTXO snapshot the store was imported from
+// while the history below it is not verified yet, SnapshotHash is the hash
+// of the UTXO set of the snapshot. The blocks up to PrunedHeight are kept
+// without their transactions by a pruned node, zero when none of them is pruned.
+// UTXORootHeight is the height of the first block whose header had to commit
+// to the UTXO set when the store was upgraded, zero for a store that started
+// with the commitment.
+type Meta struct {
+	Version        int
+	ChainID        string
+	SnapshotHeight int32
+	SnapshotHash   []byte
+	PrunedHeight   int32
+	UTXORootHeight int32
+}
+
+// migration converts the data of a store written in the previous schema version,
+// it works through the entity stores, so a single migration serves every backend.
+// The tables of the sqlite store are migrated separately when the store is opened.
+type migration struct {
+	version int
+	name    string
+	// apply is nil when the version only marks the layout, the meta it changes
+	// is put together with the new version
+	apply func(ctx context.Context, s Storer, meta *Meta) error
+}
+
+// migrations is the schema history shared by all the backends, new migrations
+// are appended with the next version, the applied ones must never change
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial layout",
+	},
+	{
+		version: 2,
+		name:    "move the spent outputs to the archive",
+		apply:   archiveSpentUTXOs,
+	},
+	{
+		version: 3,
+		name:    "record the height the utxo root is required from",
+		apply:   recordUTXORootHeight,
+	},
+}
+
+// SchemaVersion returns the schema version the node writes
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Migrate upgrades the store to the current schema version one migration at a time,
+// recording the version after each of them, so an interrupted upgrade resumes where
+// it stopped. A new store is marked with the current version, a store with blocks
+// but without the meta record predates the versioning and starts from version zero.
+func Migrate(ctx context.Context, s Storer) error {
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read store meta: %w", err)
+	}
+	if meta == nil {
+		meta = &Meta{}
+		n, err := s.BlockStore(ctx).Count(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count blocks: %w", err)
+		}
+		if n == 0 {
+			meta.Version = SchemaVersion()
+			return s.MetaStore(ctx).Put(ctx, meta)
+		}
+	}
+	if meta.Version > SchemaVersion() {
+		return fmt.Errorf("%w: store version %d, supported version %d", ErrSchemaTooNew, meta.Version, SchemaVersion())
+	}
+	for _, m := range migrations {
+		if m.version <= meta.Version {
+			continue
+		}
+		if m.apply != nil {
+			logrus.Infof("migrating store to version %d (%s)", m.version, m.name)
+			if err := m.apply(ctx, s, meta); err != nil {
+				return fmt.Errorf("failed to apply store migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+		meta.Version = m.version
+		if err := s.MetaStore(ctx).Put(ctx, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordUTXORootHeight requires the utxo root from the block above the highest stored one,
+// the blocks of a store written before the headers carried the root do not have it
+func recordUTXORootHeight(ctx context.Context, s Storer, meta *Meta) error {
+	height := int32(-1)
+	_, err := s.BlockStore(ctx).Iterate(ctx, Cursor{}, func(block *proto.Block) error {
+		if block.Header.Height > height {
+			height = block.Header.Height
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	meta.UTXORootHeight = height + 1
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type MemoryMetaStore struct {
+	lock sync.RWMutex
+	meta *Meta
+}
+
+func NewMemoryMetaStore() *MemoryMetaStore {
+	return &MemoryMetaStore{}
+}
+
+// Get returns nil if the meta record was never put
+func (m *MemoryMetaStore) Get(ctx context.Context) (*Meta, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.meta == nil {
+		return nil, nil
+	}
+	meta := *m.meta
+	return &meta, nil
+}
+
+func (m *MemoryMetaStore) Put(ctx context.Context, meta *Meta) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stored := *meta
+	m.meta = &stored
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type mongoMetaDoc struct {
+	ID             string `bson:"_id"`
+	Version        int    `bson:"version"`
+	ChainID        string `bson:"chainId"`
+	SnapshotHeight int32  `bson:"snapshotHeight"`
+	SnapshotHash   []byte `bson:"snapshotHash"`
+	PrunedHeight   int32  `bson:"prunedHeight"`
+	UTXORootHeight int32  `bson:"utxoRootHeight"`
+}
+
+type MongoMetaStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoMetaStore(db *mongo.Database) *MongoMetaStore {
+	return &MongoMetaStore{
+		coll: db.Collection(metaColl),
+	}
+}
+
+// Get returns nil if the meta record was never put
+func (m *MongoMetaStore) Get(ctx context.Context) (*Meta, error) {
+	var doc mongoMetaDoc
+	err := m.coll.FindOne(ctx, bson.M{"_id": metaDocID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Meta{
+		Version:        doc.Version,
+		ChainID:        doc.ChainID,
+		SnapshotHeight: doc.SnapshotHeight,
+		SnapshotHash:   doc.SnapshotHash,
+		PrunedHeight:   doc.PrunedHeight,
+		UTXORootHeight: doc.UTXORootHeight,
+	}, nil
+}
+
+func (m *MongoMetaStore) Put(ctx context.Context, meta *Meta) error {
+	_, err := m.coll.ReplaceOne(
+		ctx,
+		bson.M{"_id": metaDocID},
+		mongoMetaDoc{
+			ID:             metaDocID,
+			Version:        meta.Version,
+			ChainID:        meta.ChainID,
+			SnapshotHeight: meta.SnapshotHeight,
+			SnapshotHash:   meta.SnapshotHash,
+			PrunedHeight:   meta.PrunedHeight,
+			UTXORootHeight: meta.UTXORootHeight,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltMetaStore keeps the fields of the meta record under their own keys of the meta bucket
+type BoltMetaStore struct {
+	db *bolt.DB
+}
+
+func NewBoltMetaStore(db *bolt.DB) *BoltMetaStore {
+	return &BoltMetaStore{
+		db: db,
+	}
+}
+
+// Get returns nil if the meta record was never put
+func (b *BoltMetaStore) Get(ctx context.Context) (*Meta, error) {
+	var meta *Meta
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metaBucket)
+		version := bucket.Get(metaVersionKey)
+		if version == nil {
+			return nil
+		}
+		meta = &Meta{
+			Version: int(binary.BigEndian.Uint32(version)),
+			ChainID: string(bucket.Get(metaChainIDKey)),
+		}
+		if height := bucket.Get(metaSnapshotHeightKey); height != nil {
+			meta.SnapshotHeight = int32(binary.BigEndian.Uint32(height))
+		}
+		if hash := bucket.Get(metaSnapshotHashKey); len(hash) > 0 {
+			meta.SnapshotHash = append([]byte{}, hash...)
+		}
+		if height := bucket.Get(metaPrunedHeightKey); height != nil {
+			meta.PrunedHeight = int32(binary.BigEndian.Uint32(height))
+		}
+		if height := bucket.Get(metaUTXORootHeightKey); height != nil {
+			meta.UTXORootHeight = int32(binary.BigEndian.Uint32(height))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (b *BoltMetaStore) Put(ctx context.Context, meta *Meta) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metaBucket)
+		if err := bucket.Put(metaVersionKey, binary.BigEndian.AppendUint32(nil, uint32(meta.Version))); err != nil {
+			return err
+		}
+		if err := bucket.Put(metaChainIDKey, []byte(meta.ChainID)); err != nil {
+			return err
+		}
+		if err := bucket.Put(metaSnapshotHeightKey, binary.BigEndian.AppendUint32(nil, uint32(meta.SnapshotHeight))); err != nil {
+			return err
+		}
+		if err := bucket.Put(metaSnapshotHashKey, append([]byte{}, meta.SnapshotHash...)); err != nil {
+			return err
+		}
+		if err := bucket.Put(metaPrunedHeightKey, binary.BigEndian.AppendUint32(nil, uint32(meta.PrunedHeight))); err != nil {
+			return err
+		}
+		return bucket.Put(metaUTXORootHeightKey, binary.BigEndian.AppendUint32(nil, uint32(meta.UTXORootHeight)))
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteMetaStore keeps the meta record in the single row of the meta table
+type SQLiteMetaStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteMetaStore(conn sqlConn) *SQLiteMetaStore {
+	return &SQLiteMetaStore{
+		conn: conn,
+	}
+}
+
+// Get returns nil if the meta record was never put
+func (s *SQLiteMetaStore) Get(ctx context.Context) (*Meta, error) {
+	meta := &Meta{}
+	err := s.conn.QueryRowContext(
+		ctx,
+		`SELECT version, chain_id, snapshot_height, snapshot_hash, pruned_height, utxo_root_height FROM meta WHERE id = 1`,
+	).Scan(
+		&meta.Version,
+		&meta.ChainID,
+		&meta.SnapshotHeight,
+		&meta.SnapshotHash,
+		&meta.PrunedHeight,
+		&meta.UTXORootHeight,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *SQLiteMetaStore) Put(ctx context.Context, meta *Meta) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		`INSERT INTO meta (id, version, chain_id, snapshot_height, snapshot_hash, pruned_height, utxo_root_height)
+		VALUES (1, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			version = excluded.version,
+			chain_id = excluded.chain_id,
+			snapshot_height = excluded.snapshot_height,
+			snapshot_hash = excluded.snapshot_hash,
+			pruned_height = excluded.pruned_height,
+			utxo_root_height = excluded.utxo_root_height`,
+		meta.Version,
+		meta.ChainID,
+		meta.SnapshotHeight,
+		meta.SnapshotHash,
+		meta.PrunedHeight,
+		meta.UTXORootHeight,
+	)
+	return err
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/node/util"
+)
+
+func testMetaStore(t *testing.T, metaStore MetaStorer) {
+	ctx := context.Background()
+	meta, err := metaStore.Get(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, meta)
+
+	assert.Nil(t, metaStore.Put(ctx, &Meta{Version: 1}))
+	meta, err = metaStore.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &Meta{Version: 1}, meta)
+
+	assert.Nil(t, metaStore.Put(ctx, &Meta{Version: 2, ChainID: "abc"}))
+	meta, err = metaStore.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &Meta{Version: 2, ChainID: "abc"}, meta)
+
+	snapshot := &Meta{Version: 2, ChainID: "abc", SnapshotHeight: 10, SnapshotHash: util.RandomHash(), PrunedHeight: 4}
+	snapshot.UTXORootHeight = 7
+	assert.Nil(t, metaStore.Put(ctx, snapshot))
+	meta, err = metaStore.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot, meta)
+
+	// clearing the snapshot once its history is verified
+	assert.Nil(t, metaStore.Put(ctx, &Meta{Version: 2, ChainID: "abc"}))
+	meta, err = metaStore.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &Meta{Version: 2, ChainID: "abc"}, meta)
+}
+
+func TestMemoryMetaStore(t *testing.T) {
+	testMetaStore(t, NewMemoryMetaStore())
+}
+
+func TestBoltMetaStore(t *testing.T) {
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testMetaStore(t, s.MetaStore(context.Background()))
+}
+
+func TestSQLiteMetaStore(t *testing.T) {
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testMetaStore(t, s.MetaStore(context.Background()))
+}
+
+func TestMongoMetaStore(t *testing.T) {
+	s := newTestMongoStore(t)
+	testMetaStore(t, s.MetaStore(context.Background()))
+}
+
+func TestMigrateNewStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewChainMemoryStore()
+	assert.Nil(t, Migrate(ctx, s))
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion(), meta.Version)
+}
+
+func TestMigrateUnversionedStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewChainMemoryStore()
+	assert.Nil(t, s.BlockStore(ctx).Put(ctx, util.RandomBlock()))
+
+	saved := migrations
+	defer func() {
+		migrations = saved
+	}()
+	applied := make([]int, 0)
+	migrations = []migration{
+		{version: 1, name: "initial layout"},
+		{version: 2, name: "second", apply: func(ctx context.Context, s Storer, meta *Meta) error {
+			applied = append(applied, 2)
+			return nil
+		}},
+		{version: 3, name: "third", apply: func(ctx context.Context, s Storer, meta *Meta) error {
+			applied = append(applied, 3)
+			return nil
+		}},
+	}
+	// the store with blocks and without the meta record upgrades from the start
+	assert.Nil(t, Migrate(ctx, s))
+	assert.Equal(t, []int{2, 3}, applied)
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, meta.Version)
+
+	// an upgraded store is not migrated again
+	assert.Nil(t, Migrate(ctx, s))
+	assert.Equal(t, []int{2, 3}, applied)
+}
+
+func TestMigrateRecordsUTXORootHeight(t *testing.T) {
+	ctx := context.Background()
+	s := NewChainMemoryStore()
+	assert.Nil(t, s.MetaStore(ctx).Put(ctx, &Meta{Version: 2, ChainID: "abc"}))
+	for h := 0; h < 3; h++ {
+		block := util.RandomBlock()
+		block.Header.Height = int32(h)
+		assert.Nil(t, s.BlockStore(ctx).Put(ctx, block))
+	}
+	assert.Nil(t, Migrate(ctx, s))
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), meta.UTXORootHeight)
+
+	// a new store requires the root from the start
+	s = NewChainMemoryStore()
+	assert.Nil(t, Migrate(ctx, s))
+	meta, err = s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), meta.UTXORootHeight)
+}
+
+func TestMigrateStopsAtFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	s := NewChainMemoryStore()
+	assert.Nil(t, s.MetaStore(ctx).Put(ctx, &Meta{Version: 1, ChainID: "abc"}))
+
+	saved := migrations
+	defer func() {
+		migrations = saved
+	}()
+	migrations = []migration{
+		{version: 1, name: "initial layout"},
+		{version: 2, name: "second"},
+		{version: 3, name: "broken", apply: func(ctx context.Context, s Storer, meta *Meta) error {
+			return errors.New("broken")
+		}},
+	}
+	assert.NotNil(t, Migrate(ctx, s))
+	// the successful migrations are recorded and the chain id is kept
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &Meta{Version: 2, ChainID: "abc"}, meta)
+}
+
+func TestMigrateRefusesNewerStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.Nil(t, s.MetaStore(ctx).Put(ctx, &Meta{Version: SchemaVersion() + 1}))
+	assert.True(t, errors.Is(Migrate(ctx, s), ErrSchemaTooNew))
+}
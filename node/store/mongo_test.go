@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/crypto"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/util"
+	"go.mongodb.org/mongo-driver/bson"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// errMongoUnavailable remembers the failed connection, so the rest of the tests
+// are skipped without waiting for the timeout again
+var errMongoUnavailable error
+
+// newTestMongoStore opens the chain in a fresh database of the mongod at MONGO_TEST_URI,
+// the local server by default, the test is skipped when the server is not running
+func newTestMongoStore(t *testing.T) *ChainMongoStore {
+	if errMongoUnavailable != nil {
+		t.Skipf("mongod is not available: %v", errMongoUnavailable)
+	}
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		uri = defaultMongoURI
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	dbName := fmt.Sprintf("microblocknet_test_%d", time.Now().UnixNano())
+	s, err := NewMongoChainStore(ctx, uri, dbName)
+	if err != nil {
+		errMongoUnavailable = err
+		t.Skipf("mongod is not available: %v", err)
+	}
+	t.Cleanup(func() {
+		s.client.Database(dbName).Drop(context.Background())
+		s.Close()
+	})
+	return s
+}
+
+func TestMongoBlockStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMongoStore(t)
+	blockStore := s.BlockStore(ctx)
+
+	block := util.RandomBlock()
+	assert.Nil(t, blockStore.Put(ctx, block))
+	// putting the same block again replaces it
+	assert.Nil(t, blockStore.Put(ctx, block))
+
+	getBlock, err := blockStore.Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(block, getBlock))
+
+	_, err = blockStore.Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, blockStore.Put(ctx, util.RandomBlock()))
+	assert.Equal(t, 2, len(blockStore.List(ctx)))
+}
+
+func TestMongoTxStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMongoStore(t)
+	txStore := s.TxStore(ctx)
+
+	tx := util.RandomTransaction()
+	assert.Nil(t, txStore.Put(ctx, tx))
+	assert.Nil(t, txStore.Put(ctx, tx))
+
+	getTx, err := txStore.Get(ctx, secure.HashTransaction(tx))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(tx, getTx))
+
+	_, err = txStore.Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 1, len(txStore.List(ctx)))
+}
+
+func TestMongoUTXOStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMongoStore(t)
+	utxoStore := s.UTXOStore(ctx)
+
+	missing, err := utxoStore.Get(ctx, secure.MakeUTXOKey(util.RandomHash(), 0))
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+
+	address := crypto.GeneratePrivateKey().PublicKey().Address().Bytes()
+	utxo := util.RandomUTXO()
+	utxo.Output.Address = address
+	assert.Nil(t, utxoStore.Put(ctx, utxo))
+	assert.Nil(t, utxoStore.Put(ctx, util.RandomUTXO()))
+
+	utxos, err := utxoStore.GetByAddress(ctx, address)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(utxos))
+	assert.True(t, pb.Equal(utxo, utxos[0]))
+
+	// putting the output again updates the existing document
+	changed := pb.Clone(utxo).(*proto.UTXO)
+	changed.Output.Value++
+	assert.Nil(t, utxoStore.Put(ctx, changed))
+	assert.Equal(t, 2, len(utxoStore.List(ctx)))
+
+	getUTXO, err := utxoStore.Get(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(changed, getUTXO))
+
+	// the spent output leaves the set
+	assert.Nil(t, utxoStore.Delete(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))))
+	utxos, err = utxoStore.GetByAddress(ctx, address)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(utxos))
+}
+
+func TestMongoUniqueIndexes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMongoStore(t)
+
+	// a second document with the same hash or key violates the unique index
+	_, err := s.blockStore.coll.InsertOne(ctx, bson.M{"hash": "duplicate"})
+	assert.Nil(t, err)
+	_, err = s.blockStore.coll.InsertOne(ctx, bson.M{"hash": "duplicate"})
+	assert.NotNil(t, err)
+
+	_, err = s.utxoStore.coll.InsertOne(ctx, bson.M{"key": "duplicate"})
+	assert.Nil(t, err)
+	_, err = s.utxoStore.coll.InsertOne(ctx, bson.M{"key": "duplicate"})
+	assert.NotNil(t, err)
+
+	_, err = s.txStore.coll.InsertOne(ctx, bson.M{"hash": "duplicate"})
+	assert.Nil(t, err)
+	_, err = s.txStore.coll.InsertOne(ctx, bson.M{"hash": "duplicate"})
+	assert.NotNil(t, err)
+}
+
+func TestMongoStoreCommit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMongoStore(t)
+
+	block := util.RandomBlock()
+	tx := util.RandomTransaction()
+	utxo := util.RandomUTXO()
+	batch := NewBatch()
+	batch.PutBlock(block)
+	batch.PutTx(tx)
+	batch.PutUTXO(utxo)
+	assert.Nil(t, s.Commit(ctx, batch))
+
+	_, err := s.BlockStore(ctx).Get(ctx, secure.HashBlock(block))
+	assert.Nil(t, err)
+	_, err = s.TxStore(ctx).Get(ctx, secure.HashTransaction(tx))
+	assert.Nil(t, err)
+	getUTXO, err := s.UTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	assert.Nil(t, err)
+	assert.NotNil(t, getUTXO)
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	pb "google.golang.org/protobuf/proto"
+)
+
+const (
+	spentUTXOColl = "spent_utxo"
+)
+
+// SpentUTXO is an output removed from the UTXO set by the transaction
+// of the main chain block at Height, the archive keeps it to undo the block
+type SpentUTXO struct {
+	UTXO   *proto.UTXO
+	Height int32
+}
+
+// Key returns the UTXO key of the spent output
+func (s *SpentUTXO) Key() string {
+	return secure.MakeUTXOKey(s.UTXO.TxHash, int(s.UTXO.OutIndex))
+}
+
+// archiveSpentUTXOs moves the outputs that the earlier versions marked spent
+// from the UTXO set to the archive, the heights of the blocks spending them
+// are looked up in the stored blocks
+func archiveSpentUTXOs(ctx context.Context, s Storer, meta *Meta) error {
+	spent := make(map[string]*SpentUTXO)
+	_, err := s.UTXOStore(ctx).Iterate(ctx, Cursor{}, func(utxo *proto.UTXO) error {
+		if utxo.Spent {
+			spent[secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))] = &SpentUTXO{UTXO: utxo}
+		}
+		return nil
+	})
+	if err != nil || len(spent) == 0 {
+		return err
+	}
+	_, err = s.BlockStore(ctx).Iterate(ctx, Cursor{}, func(block *proto.Block) error {
+		for _, tx := range block.Transactions {
+			for _, input := range tx.Inputs {
+				if entry, ok := spent[secure.MakeUTXOKey(input.PrevTxHash, int(input.OutIndex))]; ok {
+					entry.Height = block.Header.Height
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := NewBatch()
+	for key, entry := range spent {
+		batch.PutSpentUTXO(entry)
+		batch.DeleteUTXO(key)
+	}
+	return s.Commit(ctx, batch)
+}
+
+// -----------------------------------------------------------------------------
+
+type MemorySpentUTXOStore struct {
+	lock  sync.RWMutex
+	spent map[string]*SpentUTXO
+}
+
+func NewMemorySpentUTXOStore() *MemorySpentUTXOStore {
+	return &MemorySpentUTXOStore{
+		spent: make(map[string]*SpentUTXO),
+	}
+}
+
+func (m *MemorySpentUTXOStore) Put(ctx context.Context, spent *SpentUTXO) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.spent[spent.Key()] = spent
+	return nil
+}
+
+// Get returns nil if the output is not archived
+func (m *MemorySpentUTXOStore) Get(ctx context.Context, key string) (*SpentUTXO, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.spent[key], nil
+}
+
+func (m *MemorySpentUTXOStore) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.spent, key)
+	return nil
+}
+
+func (m *MemorySpentUTXOStore) Count(ctx context.Context) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.spent), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// mongoSpentUTXODoc keeps the protobuf encoded UTXO by its hex encoded key
+type mongoSpentUTXODoc struct {
+	Key    string `bson:"key"`
+	Height int32  `bson:"height"`
+	Data   []byte `bson:"data"`
+}
+
+type MongoSpentUTXOStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoSpentUTXOStore(db *mongo.Database) *MongoSpentUTXOStore {
+	return &MongoSpentUTXOStore{
+		coll: db.Collection(spentUTXOColl),
+	}
+}
+
+func (m *MongoSpentUTXOStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "height", Value: 1}},
+		},
+	})
+	return err
+}
+
+func (m *MongoSpentUTXOStore) Put(ctx context.Context, spent *SpentUTXO) error {
+	data, err := pb.Marshal(spent.UTXO)
+	if err != nil {
+		return err
+	}
+	key := hex.EncodeToString([]byte(spent.Key()))
+	_, err = m.coll.ReplaceOne(
+		ctx,
+		bson.M{"key": key},
+		mongoSpentUTXODoc{
+			Key:    key,
+			Height: spent.Height,
+			Data:   data,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Get returns nil if the output is not archived
+func (m *MongoSpentUTXOStore) Get(ctx context.Context, key string) (*SpentUTXO, error) {
+	var doc mongoSpentUTXODoc
+	err := m.coll.FindOne(ctx, bson.M{
+		"key": hex.EncodeToString([]byte(key)),
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	utxo := &proto.UTXO{}
+	if err := pb.Unmarshal(doc.Data, utxo); err != nil {
+		return nil, fmt.Errorf("error decoding spent utxo %s: %w", doc.Key, err)
+	}
+	return &SpentUTXO{
+		UTXO:   utxo,
+		Height: doc.Height,
+	}, nil
+}
+
+func (m *MongoSpentUTXOStore) Delete(ctx context.Context, key string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{
+		"key": hex.EncodeToString([]byte(key)),
+	})
+	return err
+}
+
+func (m *MongoSpentUTXOStore) Count(ctx context.Context) (int, error) {
+	n, err := m.coll.CountDocuments(ctx, bson.D{})
+	return int(n), err
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltSpentUTXOStore keeps the spent outputs by their UTXO key,
+// the value is the height followed by the protobuf encoded UTXO
+type BoltSpentUTXOStore struct {
+	db *bolt.DB
+}
+
+func NewBoltSpentUTXOStore(db *bolt.DB) *BoltSpentUTXOStore {
+	return &BoltSpentUTXOStore{
+		db: db,
+	}
+}
+
+func (b *BoltSpentUTXOStore) Put(ctx context.Context, spent *SpentUTXO) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltSpentUTXO(tx, spent)
+	})
+}
+
+// Get returns nil if the output is not archived
+func (b *BoltSpentUTXOStore) Get(ctx context.Context, key string) (*SpentUTXO, error) {
+	var spent *SpentUTXO
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(spentUTXOsBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		utxo := &proto.UTXO{}
+		if err := pb.Unmarshal(v[4:], utxo); err != nil {
+			return err
+		}
+		spent = &SpentUTXO{
+			UTXO:   utxo,
+			Height: int32(binary.BigEndian.Uint32(v)),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func (b *BoltSpentUTXOStore) Delete(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltCounted(tx.Bucket(spentUTXOsBucket), []byte(key))
+	})
+}
+
+func (b *BoltSpentUTXOStore) Count(ctx context.Context) (int, error) {
+	return countBolt(b.db, spentUTXOsBucket)
+}
+
+func putBoltSpentUTXO(tx *bolt.Tx, spent *SpentUTXO) error {
+	data, err := pb.Marshal(spent.UTXO)
+	if err != nil {
+		return err
+	}
+	value := binary.BigEndian.AppendUint32(nil, uint32(spent.Height))
+	value = append(value, data...)
+	return putBoltCounted(tx.Bucket(spentUTXOsBucket), []byte(spent.Key()), value)
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteSpentUTXOStore keeps the spent outputs in the spent_utxos table
+type SQLiteSpentUTXOStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteSpentUTXOStore(conn sqlConn) *SQLiteSpentUTXOStore {
+	return &SQLiteSpentUTXOStore{
+		conn: conn,
+	}
+}
+
+func (s *SQLiteSpentUTXOStore) Put(ctx context.Context, spent *SpentUTXO) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		`INSERT INTO spent_utxos (tx_hash, out_index, value, address, height) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (tx_hash, out_index) DO UPDATE SET
+			value = excluded.value,
+			address = excluded.address,
+			height = excluded.height`,
+		spent.UTXO.TxHash,
+		spent.UTXO.OutIndex,
+		spent.UTXO.GetOutput().GetValue(),
+		spent.UTXO.GetOutput().GetAddress(),
+		spent.Height,
+	)
+	return err
+}
+
+// Get returns nil if the output is not archived
+func (s *SQLiteSpentUTXOStore) Get(ctx context.Context, key string) (*SpentUTXO, error) {
+	txHash, outIndex, err := secure.ParseUTXOKey(key)
+	if err != nil {
+		return nil, err
+	}
+	spent := &SpentUTXO{
+		UTXO: &proto.UTXO{
+			Output: &proto.TxOutput{},
+			Spent:  true,
+		},
+	}
+	err = s.conn.QueryRowContext(
+		ctx,
+		`SELECT tx_hash, out_index, value, address, height FROM spent_utxos
+		WHERE tx_hash = ? AND out_index = ?`,
+		txHash, outIndex,
+	).Scan(&spent.UTXO.TxHash, &spent.UTXO.OutIndex, &spent.UTXO.Output.Value, &spent.UTXO.Output.Address, &spent.Height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func (s *SQLiteSpentUTXOStore) Delete(ctx context.Context, key string) error {
+	txHash, outIndex, err := secure.ParseUTXOKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.ExecContext(ctx, `DELETE FROM spent_utxos WHERE tx_hash = ? AND out_index = ?`, txHash, outIndex)
+	return err
+}
+
+func (s *SQLiteSpentUTXOStore) Count(ctx context.Context) (int, error) {
+	return countSQLite(ctx, s.conn, "spent_utxos")
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yuriykis/microblocknet/common/proto"
+	"github.com/yuriykis/microblocknet/node/secure"
+	"github.com/yuriykis/microblocknet/node/util"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// testSpentUTXOStore moves an output from the UTXO set to the archive with a batch
+// and back, the way the chain connects and disconnects the block spending it
+func testSpentUTXOStore(t *testing.T, s Storer) {
+	ctx := context.Background()
+	utxo := util.RandomUTXO()
+	batch := NewBatch()
+	batch.PutUTXO(utxo)
+	assert.Nil(t, s.Commit(ctx, batch))
+
+	spent := &SpentUTXO{
+		UTXO:   pb.Clone(utxo).(*proto.UTXO),
+		Height: 7,
+	}
+	spent.UTXO.Spent = true
+	key := spent.Key()
+	batch = NewBatch()
+	batch.DeleteUTXO(key)
+	batch.PutSpentUTXO(spent)
+	assert.Nil(t, s.Commit(ctx, batch))
+
+	got, err := s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+	archived, err := s.SpentUTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(7), archived.Height)
+	assert.True(t, pb.Equal(spent.UTXO, archived.UTXO))
+	n, err := s.SpentUTXOStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	batch = NewBatch()
+	batch.PutUTXO(utxo)
+	batch.DeleteSpentUTXO(key)
+	assert.Nil(t, s.Commit(ctx, batch))
+	got, err = s.UTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(utxo, got))
+	archived, err = s.SpentUTXOStore(ctx).Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Nil(t, archived)
+}
+
+func TestMemorySpentUTXOStore(t *testing.T) {
+	testSpentUTXOStore(t, NewChainMemoryStore())
+}
+
+func TestBoltSpentUTXOStore(t *testing.T) {
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testSpentUTXOStore(t, s)
+}
+
+func TestSQLiteSpentUTXOStore(t *testing.T) {
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	testSpentUTXOStore(t, s)
+}
+
+func TestMongoSpentUTXOStore(t *testing.T) {
+	testSpentUTXOStore(t, newTestMongoStore(t))
+}
+
+func TestMigrateArchivesSpentUTXOs(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.Nil(t, s.MetaStore(ctx).Put(ctx, &Meta{Version: 1}))
+
+	// the first version kept the spent outputs in the set
+	unspent := util.RandomUTXO()
+	spent := util.RandomUTXO()
+	spent.Spent = true
+	block := util.RandomBlock()
+	block.Header.Height = 3
+	block.Transactions = []*proto.Transaction{{
+		Inputs: []*proto.TxInput{{PrevTxHash: spent.TxHash, OutIndex: spent.OutIndex}},
+	}}
+	batch := NewBatch()
+	batch.PutBlock(block)
+	batch.PutUTXO(unspent)
+	batch.PutUTXO(spent)
+	assert.Nil(t, s.Commit(ctx, batch))
+
+	assert.Nil(t, Migrate(ctx, s))
+	n, err := s.UTXOStore(ctx).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	archived, err := s.SpentUTXOStore(ctx).Get(ctx, secure.MakeUTXOKey(spent.TxHash, int(spent.OutIndex)))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), archived.Height)
+	assert.True(t, pb.Equal(spent, archived.UTXO))
+	meta, err := s.MetaStore(ctx).Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion(), meta.Version)
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqliteMigration is a schema change applied once, in the order of the versions
+type sqliteMigration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+// sqliteMigrations is the schema history of the sqlite store, new migrations
+// are appended with the next version, the applied ones must never change
+var sqliteMigrations = []sqliteMigration{
+	{
+		version: 1,
+		name:    "create chain tables",
+		stmts: []string{
+			`CREATE TABLE blocks (
+				hash            BLOB PRIMARY KEY,
+				version         INTEGER NOT NULL,
+				height          INTEGER NOT NULL,
+				prev_block_hash BLOB,
+				merkle_root     BLOB,
+				timestamp       INTEGER NOT NULL,
+				header_hash     BLOB,
+				nonce           INTEGER NOT NULL,
+				public_key      BLOB,
+				signature       BLOB
+			)`,
+			`CREATE TABLE transactions (
+				hash BLOB PRIMARY KEY
+			)`,
+			`CREATE TABLE block_transactions (
+				block_hash BLOB NOT NULL REFERENCES blocks (hash) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				tx_hash    BLOB NOT NULL REFERENCES transactions (hash),
+				PRIMARY KEY (block_hash, position)
+			)`,
+			`CREATE TABLE tx_inputs (
+				tx_hash      BLOB NOT NULL REFERENCES transactions (hash) ON DELETE CASCADE,
+				position     INTEGER NOT NULL,
+				prev_tx_hash BLOB,
+				out_index    INTEGER NOT NULL,
+				public_key   BLOB,
+				signature    BLOB,
+				PRIMARY KEY (tx_hash, position)
+			)`,
+			`CREATE TABLE tx_outputs (
+				tx_hash  BLOB NOT NULL REFERENCES transactions (hash) ON DELETE CASCADE,
+				position INTEGER NOT NULL,
+				value    INTEGER NOT NULL,
+				address  BLOB,
+				PRIMARY KEY (tx_hash, position)
+			)`,
+			`CREATE TABLE utxos (
+				tx_hash   BLOB NOT NULL,
+				out_index INTEGER NOT NULL,
+				value     INTEGER NOT NULL,
+				address   BLOB,
+				spent     INTEGER NOT NULL,
+				PRIMARY KEY (tx_hash, out_index)
+			)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "index heights, spent inputs and addresses",
+		stmts: []string{
+			`CREATE INDEX blocks_height ON blocks (height)`,
+			`CREATE INDEX block_transactions_tx ON block_transactions (tx_hash)`,
+			`CREATE INDEX tx_inputs_prev ON tx_inputs (prev_tx_hash, out_index)`,
+			`CREATE INDEX tx_outputs_address ON tx_outputs (address)`,
+			`CREATE INDEX utxos_address ON utxos (address, spent)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "create address index",
+		stmts: []string{
+			`CREATE TABLE address_txs (
+				address  BLOB NOT NULL,
+				tx_hash  BLOB NOT NULL,
+				height   INTEGER NOT NULL,
+				position INTEGER NOT NULL,
+				received INTEGER NOT NULL,
+				sent     INTEGER NOT NULL,
+				PRIMARY KEY (address, tx_hash)
+			)`,
+			`CREATE INDEX address_txs_order ON address_txs (address, height, position)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "create transaction location index",
+		stmts: []string{
+			`CREATE TABLE tx_locations (
+				tx_hash    BLOB PRIMARY KEY,
+				block_hash BLOB NOT NULL,
+				height     INTEGER NOT NULL,
+				position   INTEGER NOT NULL
+			)`,
+			`CREATE INDEX tx_locations_block ON tx_locations (block_hash)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "create meta record",
+		stmts: []string{
+			`CREATE TABLE meta (
+				id       INTEGER PRIMARY KEY CHECK (id = 1),
+				version  INTEGER NOT NULL,
+				chain_id TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "record the imported snapshot",
+		stmts: []string{
+			`ALTER TABLE meta ADD COLUMN snapshot_height INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE meta ADD COLUMN snapshot_hash BLOB`,
+		},
+	},
+	{
+		version: 7,
+		name:    "create spent-output archive",
+		stmts: []string{
+			`CREATE TABLE spent_utxos (
+				tx_hash   BLOB NOT NULL,
+				out_index INTEGER NOT NULL,
+				value     INTEGER NOT NULL,
+				address   BLOB,
+				height    INTEGER NOT NULL,
+				PRIMARY KEY (tx_hash, out_index)
+			)`,
+			`CREATE INDEX spent_utxos_height ON spent_utxos (height)`,
+		},
+	},
+	{
+		version: 8,
+		name:    "record the pruned height",
+		stmts: []string{
+			`ALTER TABLE meta ADD COLUMN pruned_height INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 9,
+		name:    "add the utxo root to the block headers",
+		stmts: []string{
+			`ALTER TABLE blocks ADD COLUMN utxo_root BLOB`,
+		},
+	},
+	{
+		version: 10,
+		name:    "record the height the utxo root is required from",
+		stmts: []string{
+			`ALTER TABLE meta ADD COLUMN utxo_root_height INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+}
+
+// sqlConn is implemented by both *sql.DB and *sql.Tx,
+// so the stores work the same inside and outside of a commit
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// withSQLTx runs fn in a transaction, or in the one conn already is
+func withSQLTx(ctx context.Context, conn sqlConn, fn func(sqlConn) error) error {
+	db, ok := conn.(*sql.DB)
+	if !ok {
+		return fn(conn)
+	}
+	return runSQLTx(ctx, db, fn)
+}
+
+func runSQLTx(ctx context.Context, db *sql.DB, fn func(sqlConn) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateSQLite applies the migrations newer than the current schema version,
+// each of them in its own transaction together with its version record
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if latest := sqliteMigrations[len(sqliteMigrations)-1].version; current > latest {
+		return fmt.Errorf("sqlite schema version %d is newer than the supported version %d", current, latest)
+	}
+	for _, m := range sqliteMigrations {
+		if m.version <= current {
+			continue
+		}
+		err := runSQLTx(ctx, db, func(conn sqlConn) error {
+			for _, stmt := range m.stmts {
+				if _, err := conn.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			_, err := conn.ExecContext(
+				ctx,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				m.version,
+				m.name,
+				time.Now().Unix(),
+			)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply sqlite migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chain.db")
+	s, err := NewChainSQLiteStore(path)
+	assert.Nil(t, err)
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+
+	var version int
+	assert.Nil(t, s.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, latest, version)
+	assert.Nil(t, s.Close())
+
+	// reopening the store applies nothing again
+	s, err = NewChainSQLiteStore(path)
+	assert.Nil(t, err)
+	var count int
+	assert.Nil(t, s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Equal(t, len(sqliteMigrations), count)
+
+	// a schema written by a newer node is refused
+	_, err = s.db.ExecContext(
+		ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'future', 0)`,
+		latest+1,
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+	_, err = NewChainSQLiteStore(path)
+	assert.NotNil(t, err)
+}
+
+func TestSQLiteMigrationRollsBack(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+
+	migrations := sqliteMigrations
+	defer func() {
+		sqliteMigrations = migrations
+	}()
+	sqliteMigrations = append(migrations[:len(migrations):len(migrations)], sqliteMigration{
+		version: migrations[len(migrations)-1].version + 1,
+		name:    "broken",
+		stmts: []string{
+			`CREATE TABLE broken (id INTEGER)`,
+			`NOT SQL`,
+		},
+	})
+	assert.NotNil(t, migrateSQLite(ctx, db))
+
+	// the earlier migrations stay applied, the broken one leaves nothing behind
+	var version int
+	assert.Nil(t, db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, migrations[len(migrations)-1].version, version)
+	var tables int
+	assert.Nil(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'`).Scan(&tables))
+	assert.Equal(t, 0, tables)
+}
@@ -2,37 +2,172 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
 
-	"github.com/sirupsen/logrus"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/yuriykis/microblocknet/common/proto"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 const (
 	storeTypeMemory = "memory"
 	storeTypeMongo  = "mongo"
+	storeTypeBolt   = "bolt"
+	storeTypeSQLite = "sqlite"
 
-	mongoDBName = "microblocknet"
+	mongoDBName     = "microblocknet"
+	defaultMongoURI = "mongodb://localhost:27017"
+	// mongoConnectTimeout limits connecting to the server and creating the indexes
+	mongoConnectTimeout = 10 * time.Second
+
+	// boltOpenTimeout limits waiting for the file lock held by another process
+	boltOpenTimeout = time.Second
+)
+
+var (
+	blocksBucket = []byte("blocks")
+	txsBucket    = []byte("transactions")
+	utxosBucket  = []byte("utxos")
+	// addressTxsBucket is the address index
+	addressTxsBucket = []byte("address_txs")
+	// txLocationsBucket maps the confirmed transactions to their blocks
+	txLocationsBucket = []byte("tx_locations")
+	// metaBucket holds the schema version and the chain id
+	metaBucket = []byte("meta")
+	// spentUTXOsBucket is the spent-output archive
+	spentUTXOsBucket = []byte("spent_utxos")
 )
 
+// Config selects the store backend, Path is the database file of the bolt and sqlite stores,
+// MongoURI and MongoDatabase locate the mongo store, Cache sizes the caches
+// in front of the persistent stores
+type Config struct {
+	Type          string
+	Path          string
+	MongoURI      string
+	MongoDatabase string
+	Cache         CacheConfig
+}
+
 type Storer interface {
 	UTXOStore(context.Context) UTXOStorer
+	SpentUTXOStore(context.Context) SpentUTXOStorer
 	TxStore(context.Context) TxStorer
 	BlockStore(context.Context) BlockStorer
+	AddressTxStore(context.Context) AddressTxStorer
+	TxLocationStore(context.Context) TxLocationStorer
+	MetaStore(context.Context) MetaStorer
+	// Commit applies all the writes of the batch or none of them
+	Commit(context.Context, *Batch) error
 }
 
-func NewChainStore(sType string) (Storer, error) {
-	switch sType {
+// commitBatch applies the batch through the given stores one write at a time,
+// the blocks go first, so an interrupted commit always leaves the block behind
+// and the chain can find and repair it on startup
+func commitBatch(ctx context.Context, s Storer, b *Batch) error {
+	for _, block := range b.Blocks() {
+		if err := s.BlockStore(ctx).Put(ctx, block); err != nil {
+			return err
+		}
+	}
+	for _, tx := range b.Txs() {
+		if err := s.TxStore(ctx).Put(ctx, tx); err != nil {
+			return err
+		}
+	}
+	for _, utxo := range b.UTXOs() {
+		if err := s.UTXOStore(ctx).Put(ctx, utxo); err != nil {
+			return err
+		}
+	}
+	// the spent outputs are archived before they leave the UTXO set,
+	// so an interrupted commit never loses them
+	for _, spent := range b.SpentUTXOs() {
+		if err := s.SpentUTXOStore(ctx).Put(ctx, spent); err != nil {
+			return err
+		}
+	}
+	for _, key := range b.DeletedUTXOs() {
+		if err := s.UTXOStore(ctx).Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	for _, key := range b.DeletedSpentUTXOs() {
+		if err := s.SpentUTXOStore(ctx).Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	for _, entry := range b.AddressTxs() {
+		if err := s.AddressTxStore(ctx).Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+	for _, entry := range b.DeletedAddressTxs() {
+		if err := s.AddressTxStore(ctx).Delete(ctx, entry.Address, entry.TxHash); err != nil {
+			return err
+		}
+	}
+	for _, loc := range b.TxLocations() {
+		if err := s.TxLocationStore(ctx).Put(ctx, loc); err != nil {
+			return err
+		}
+	}
+	for _, txHash := range b.DeletedTxLocations() {
+		if err := s.TxLocationStore(ctx).Delete(ctx, txHash); err != nil {
+			return err
+		}
+	}
+	// the disconnected block goes last, so it is found and repaired
+	// if the node stops before the rest is undone
+	for _, hash := range b.DeletedBlocks() {
+		if err := s.BlockStore(ctx).Delete(ctx, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewChainStore opens the configured store, the persistent ones
+// are wrapped in a CachedStore when any cache is enabled
+func NewChainStore(conf Config) (Storer, error) {
+	s, err := newChainBackend(conf)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.(*ChainMemoryStore); ok || !conf.Cache.Enabled() {
+		return s, nil
+	}
+	cached, err := NewCachedStore(s, conf.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func newChainBackend(conf Config) (Storer, error) {
+	switch conf.Type {
 	case storeTypeMemory:
 		return NewChainMemoryStore(), nil
 	case storeTypeMongo:
-		client, err := mongo.Connect(nil, nil)
-		if err != nil {
-			// TODO: adjust logger
-			logrus.WithError(err).Error("failed to connect to mongo")
-			return nil, err
+		uri, dbName := conf.MongoURI, conf.MongoDatabase
+		if uri == "" {
+			uri = defaultMongoURI
+		}
+		if dbName == "" {
+			dbName = mongoDBName
 		}
-		return NewChainMongoStore(client), nil
+		return NewMongoChainStore(context.Background(), uri, dbName)
+	case storeTypeBolt:
+		return NewChainBoltStore(conf.Path)
+	case storeTypeSQLite:
+		return NewChainSQLiteStore(conf.Path)
 	default:
 		return NewChainMemoryStore(), nil
 	}
@@ -46,6 +181,12 @@ type ChainMemoryStore struct {
 	txStore    TxStorer
 	blockStore BlockStorer
 	utxoStore  UTXOStorer
+	spentStore SpentUTXOStorer
+	addrStore  AddressTxStorer
+	locStore   TxLocationStorer
+	metaStore  MetaStorer
+
+	commitLock sync.Mutex
 }
 
 func (c *ChainMemoryStore) UTXOStore(ctx context.Context) UTXOStorer {
@@ -55,6 +196,13 @@ func (c *ChainMemoryStore) UTXOStore(ctx context.Context) UTXOStorer {
 	return c.utxoStore
 }
 
+func (c *ChainMemoryStore) SpentUTXOStore(ctx context.Context) SpentUTXOStorer {
+	if c.spentStore == nil {
+		c.spentStore = NewMemorySpentUTXOStore()
+	}
+	return c.spentStore
+}
+
 func (c *ChainMemoryStore) TxStore(ctx context.Context) TxStorer {
 	if c.txStore == nil {
 		c.txStore = NewMemoryTxStore()
@@ -69,56 +217,478 @@ func (c *ChainMemoryStore) BlockStore(ctx context.Context) BlockStorer {
 	return c.blockStore
 }
 
+func (c *ChainMemoryStore) AddressTxStore(ctx context.Context) AddressTxStorer {
+	if c.addrStore == nil {
+		c.addrStore = NewMemoryAddressTxStore()
+	}
+	return c.addrStore
+}
+
+func (c *ChainMemoryStore) TxLocationStore(ctx context.Context) TxLocationStorer {
+	if c.locStore == nil {
+		c.locStore = NewMemoryTxLocationStore()
+	}
+	return c.locStore
+}
+
+func (c *ChainMemoryStore) MetaStore(ctx context.Context) MetaStorer {
+	if c.metaStore == nil {
+		c.metaStore = NewMemoryMetaStore()
+	}
+	return c.metaStore
+}
+
+// Commit applies the batch, the memory store does not survive a crash,
+// so the commits only have to be serialized
+func (c *ChainMemoryStore) Commit(ctx context.Context, b *Batch) error {
+	c.commitLock.Lock()
+	defer c.commitLock.Unlock()
+	return commitBatch(ctx, c, b)
+}
+
+// ChainMongoStore keeps the chain in the collections of a mongo database
 type ChainMongoStore struct {
-	txStore    TxStorer
-	blockStore BlockStorer
-	utxoStore  UTXOStorer
+	txStore    *MongoTxStore
+	blockStore *MongoBlockStore
+	utxoStore  *MongoUTXOStore
+	spentStore *MongoSpentUTXOStore
+	addrStore  *MongoAddressTxStore
+	locStore   *MongoTxLocationStore
+	metaStore  *MongoMetaStore
 
 	client *mongo.Client
+	// transactions tells whether the server supports multi-document transactions,
+	// a standalone mongod does not, only a replica set or a sharded cluster does
+	transactions bool
 }
 
-func NewChainMongoStore(client *mongo.Client) Storer {
-	return &ChainMongoStore{
-		client: client,
+// NewMongoChainStore connects to the mongo server at uri and opens the chain
+// in the database dbName
+func NewMongoChainStore(ctx context.Context, uri string, dbName string) (*ChainMongoStore, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoConnectTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mongo %s: %w", uri, err)
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to connect to mongo %s: %w", uri, err)
 	}
+	s, err := NewChainMongoStore(ctx, client, dbName)
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return s, nil
 }
 
-func (c *ChainMongoStore) UTXOStore(ctx context.Context) UTXOStorer {
-	if c.utxoStore == nil {
-		c.utxoStore = NewMongoUTXOStore(c.client)
+// NewChainMongoStore opens the chain in the database dbName
+// and creates the indexes of its collections
+func NewChainMongoStore(ctx context.Context, client *mongo.Client, dbName string) (*ChainMongoStore, error) {
+	db := client.Database(dbName)
+	s := &ChainMongoStore{
+		txStore:    NewMongoTxStore(db),
+		blockStore: NewMongoBlockStore(db),
+		utxoStore:  NewMongoUTXOStore(db),
+		spentStore: NewMongoSpentUTXOStore(db),
+		addrStore:  NewMongoAddressTxStore(db),
+		locStore:   NewMongoTxLocationStore(db),
+		metaStore:  NewMongoMetaStore(db),
+		client:     client,
+	}
+	if err := s.blockStore.ensureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create block indexes: %w", err)
+	}
+	if err := s.txStore.ensureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create transaction indexes: %w", err)
+	}
+	if err := s.utxoStore.ensureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create utxo indexes: %w", err)
+	}
+	if err := s.spentStore.ensureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create spent utxo indexes: %w", err)
+	}
+	if err := s.addrStore.ensureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create address indexes: %w", err)
+	}
+	if err := s.locStore.ensureIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create transaction location indexes: %w", err)
+	}
+	transactions, err := supportsTransactions(ctx, db)
+	if err != nil {
+		return nil, err
 	}
+	s.transactions = transactions
+	return s, nil
+}
+
+// supportsTransactions asks the server whether it is a replica set member or a mongos router
+func supportsTransactions(ctx context.Context, db *mongo.Database) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, fmt.Errorf("failed to query mongo topology: %w", err)
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+func (c *ChainMongoStore) UTXOStore(ctx context.Context) UTXOStorer {
 	return c.utxoStore
 }
 
+func (c *ChainMongoStore) SpentUTXOStore(ctx context.Context) SpentUTXOStorer {
+	return c.spentStore
+}
+
 func (c *ChainMongoStore) TxStore(ctx context.Context) TxStorer {
-	if c.txStore == nil {
-		c.txStore = NewMongoTxStore(c.client)
-	}
 	return c.txStore
 }
 
 func (c *ChainMongoStore) BlockStore(ctx context.Context) BlockStorer {
-	if c.blockStore == nil {
-		c.blockStore = NewMongoBlockStore(c.client)
+	return c.blockStore
+}
+
+func (c *ChainMongoStore) AddressTxStore(ctx context.Context) AddressTxStorer {
+	return c.addrStore
+}
+
+func (c *ChainMongoStore) TxLocationStore(ctx context.Context) TxLocationStorer {
+	return c.locStore
+}
+
+func (c *ChainMongoStore) MetaStore(ctx context.Context) MetaStorer {
+	return c.metaStore
+}
+
+// Commit applies the batch in a multi-document transaction when the server
+// supports them, otherwise the writes are applied one by one and an interrupted
+// commit is repaired by the chain on startup
+func (c *ChainMongoStore) Commit(ctx context.Context, b *Batch) error {
+	if !c.transactions {
+		return commitBatch(ctx, c, b)
 	}
+	session, err := c.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, commitBatch(sc, c, b)
+	})
+	return err
+}
+
+// Close disconnects from the mongo server
+func (c *ChainMongoStore) Close() error {
+	return c.client.Disconnect(context.Background())
+}
+
+// findAll decodes all the documents of the collection into docs
+func findAll(ctx context.Context, coll *mongo.Collection, docs any) error {
+	cur, err := coll.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, docs)
+}
+
+// ChainBoltStore keeps the chain in a single bolt database file,
+// so the node persists its state without any external service
+type ChainBoltStore struct {
+	txStore    TxStorer
+	blockStore BlockStorer
+	utxoStore  UTXOStorer
+	spentStore SpentUTXOStorer
+	addrStore  AddressTxStorer
+	locStore   TxLocationStorer
+	metaStore  MetaStorer
+
+	db *bolt.DB
+}
+
+// NewChainBoltStore opens the database at path, creating it if it does not exist
+func NewChainBoltStore(path string) (*ChainBoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{blocksBucket, txsBucket, utxosBucket, spentUTXOsBucket, addressTxsBucket, txLocationsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return initBoltCounts(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &ChainBoltStore{
+		txStore:    NewBoltTxStore(db),
+		blockStore: NewBoltBlockStore(db),
+		utxoStore:  NewBoltUTXOStore(db),
+		spentStore: NewBoltSpentUTXOStore(db),
+		addrStore:  NewBoltAddressTxStore(db),
+		locStore:   NewBoltTxLocationStore(db),
+		metaStore:  NewBoltMetaStore(db),
+		db:         db,
+	}, nil
+}
+
+func (c *ChainBoltStore) UTXOStore(ctx context.Context) UTXOStorer {
+	return c.utxoStore
+}
+
+func (c *ChainBoltStore) SpentUTXOStore(ctx context.Context) SpentUTXOStorer {
+	return c.spentStore
+}
+
+func (c *ChainBoltStore) TxStore(ctx context.Context) TxStorer {
+	return c.txStore
+}
+
+func (c *ChainBoltStore) BlockStore(ctx context.Context) BlockStorer {
+	return c.blockStore
+}
+
+func (c *ChainBoltStore) AddressTxStore(ctx context.Context) AddressTxStorer {
+	return c.addrStore
+}
+
+func (c *ChainBoltStore) TxLocationStore(ctx context.Context) TxLocationStorer {
+	return c.locStore
+}
+
+func (c *ChainBoltStore) MetaStore(ctx context.Context) MetaStorer {
+	return c.metaStore
+}
+
+// Commit applies the batch in a single bolt transaction
+func (c *ChainBoltStore) Commit(ctx context.Context, b *Batch) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for _, block := range b.Blocks() {
+			if err := putBoltBlock(tx, block); err != nil {
+				return err
+			}
+		}
+		for _, t := range b.Txs() {
+			if err := putBoltTx(tx, t); err != nil {
+				return err
+			}
+		}
+		for _, utxo := range b.UTXOs() {
+			if err := putBoltUTXO(tx, utxo); err != nil {
+				return err
+			}
+		}
+		for _, spent := range b.SpentUTXOs() {
+			if err := putBoltSpentUTXO(tx, spent); err != nil {
+				return err
+			}
+		}
+		for _, key := range b.DeletedUTXOs() {
+			if err := deleteBoltCounted(tx.Bucket(utxosBucket), []byte(key)); err != nil {
+				return err
+			}
+		}
+		for _, key := range b.DeletedSpentUTXOs() {
+			if err := deleteBoltCounted(tx.Bucket(spentUTXOsBucket), []byte(key)); err != nil {
+				return err
+			}
+		}
+		for _, entry := range b.AddressTxs() {
+			if err := putBoltAddressTx(tx, entry); err != nil {
+				return err
+			}
+		}
+		for _, entry := range b.DeletedAddressTxs() {
+			if err := deleteBoltAddressTx(tx, entry.Address, entry.TxHash); err != nil {
+				return err
+			}
+		}
+		for _, loc := range b.TxLocations() {
+			if err := putBoltTxLocation(tx, loc); err != nil {
+				return err
+			}
+		}
+		for _, txHash := range b.DeletedTxLocations() {
+			if err := tx.Bucket(txLocationsBucket).Delete([]byte(txHash)); err != nil {
+				return err
+			}
+		}
+		for _, hash := range b.DeletedBlocks() {
+			if err := deleteBoltCounted(tx.Bucket(blocksBucket), []byte(hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close releases the database file
+func (c *ChainBoltStore) Close() error {
+	return c.db.Close()
+}
+
+// ChainSQLiteStore keeps the chain in normalized tables of a sqlite database,
+// so the chain of a node can be queried with plain SQL
+type ChainSQLiteStore struct {
+	txStore    *SQLiteTxStore
+	blockStore *SQLiteBlockStore
+	utxoStore  *SQLiteUTXOStore
+	spentStore *SQLiteSpentUTXOStore
+	addrStore  *SQLiteAddressTxStore
+	locStore   *SQLiteTxLocationStore
+	metaStore  *SQLiteMetaStore
+
+	db *sql.DB
+}
+
+// NewChainSQLiteStore opens the database at path, creating it if it does not exist,
+// and migrates its schema to the latest version
+func NewChainSQLiteStore(path string) (*ChainSQLiteStore, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite store %s: %w", path, err)
+	}
+	// sqlite allows a single writer, one connection avoids the busy errors
+	db.SetMaxOpenConns(1)
+	if err := migrateSQLite(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &ChainSQLiteStore{
+		txStore:    NewSQLiteTxStore(db),
+		blockStore: NewSQLiteBlockStore(db),
+		utxoStore:  NewSQLiteUTXOStore(db),
+		spentStore: NewSQLiteSpentUTXOStore(db),
+		addrStore:  NewSQLiteAddressTxStore(db),
+		locStore:   NewSQLiteTxLocationStore(db),
+		metaStore:  NewSQLiteMetaStore(db),
+		db:         db,
+	}, nil
+}
+
+func (c *ChainSQLiteStore) UTXOStore(ctx context.Context) UTXOStorer {
+	return c.utxoStore
+}
+
+func (c *ChainSQLiteStore) SpentUTXOStore(ctx context.Context) SpentUTXOStorer {
+	return c.spentStore
+}
+
+func (c *ChainSQLiteStore) TxStore(ctx context.Context) TxStorer {
+	return c.txStore
+}
+
+func (c *ChainSQLiteStore) BlockStore(ctx context.Context) BlockStorer {
 	return c.blockStore
 }
 
+func (c *ChainSQLiteStore) AddressTxStore(ctx context.Context) AddressTxStorer {
+	return c.addrStore
+}
+
+func (c *ChainSQLiteStore) TxLocationStore(ctx context.Context) TxLocationStorer {
+	return c.locStore
+}
+
+func (c *ChainSQLiteStore) MetaStore(ctx context.Context) MetaStorer {
+	return c.metaStore
+}
+
+// Commit applies the batch in a single sqlite transaction
+func (c *ChainSQLiteStore) Commit(ctx context.Context, b *Batch) error {
+	return runSQLTx(ctx, c.db, func(conn sqlConn) error {
+		return commitBatch(ctx, &ChainSQLiteStore{
+			txStore:    NewSQLiteTxStore(conn),
+			blockStore: NewSQLiteBlockStore(conn),
+			utxoStore:  NewSQLiteUTXOStore(conn),
+			spentStore: NewSQLiteSpentUTXOStore(conn),
+			addrStore:  NewSQLiteAddressTxStore(conn),
+			locStore:   NewSQLiteTxLocationStore(conn),
+			metaStore:  NewSQLiteMetaStore(conn),
+		}, b)
+	})
+}
+
+// Close releases the database file
+func (c *ChainSQLiteStore) Close() error {
+	return c.db.Close()
+}
+
 type TxStorer interface {
 	Put(ctx context.Context, tx *proto.Transaction) error
 	Get(ctx context.Context, txHash string) (*proto.Transaction, error)
 	List(ctx context.Context) []*proto.Transaction
+	// Iterate calls fn for each transaction of the page selected by the cursor and stops
+	// at the first error of fn, fn must not write to the store. It returns the key
+	// to continue after, empty when there are no more transactions.
+	Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Transaction) error) (string, error)
+	Count(ctx context.Context) (int, error)
 }
 
 type BlockStorer interface {
 	Put(ctx context.Context, block *proto.Block) error
 	Get(ctx context.Context, blockHash string) (*proto.Block, error)
+	Delete(ctx context.Context, blockHash string) error
 	List(ctx context.Context) []*proto.Block
+	// Iterate calls fn for each block of the page selected by the cursor and stops
+	// at the first error of fn, fn must not write to the store. It returns the key
+	// to continue after, empty when there are no more blocks.
+	Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Block) error) (string, error)
+	Count(ctx context.Context) (int, error)
 }
 
+// UTXOStorer is the set of the unspent outputs, the spent ones are deleted from it
 type UTXOStorer interface {
 	Put(ctx context.Context, utxo *proto.UTXO) error
 	Get(ctx context.Context, key string) (*proto.UTXO, error)
+	Delete(ctx context.Context, key string) error
 	List(ctx context.Context) []*proto.UTXO
 	GetByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error)
+	// Iterate calls fn for each UTXO of the page selected by the cursor and stops
+	// at the first error of fn, fn must not write to the store. It returns the key
+	// to continue after, empty when there are no more UTXOs.
+	Iterate(ctx context.Context, cursor Cursor, fn func(*proto.UTXO) error) (string, error)
+	// Count returns the number of the unspent outputs
+	Count(ctx context.Context) (int, error)
+}
+
+// SpentUTXOStorer is the spent-output archive, it keeps the outputs the main chain
+// removed from the UTXO set, so the blocks that spent them can be disconnected
+type SpentUTXOStorer interface {
+	Put(ctx context.Context, spent *SpentUTXO) error
+	// Get returns nil if the output is not archived
+	Get(ctx context.Context, key string) (*SpentUTXO, error)
+	Delete(ctx context.Context, key string) error
+	Count(ctx context.Context) (int, error)
+}
+
+// AddressTxStorer is the address index, it maps every address to the confirmed
+// transactions that pay to it or spend its outputs
+type AddressTxStorer interface {
+	Put(ctx context.Context, entry *AddressTx) error
+	Delete(ctx context.Context, address []byte, txHash []byte) error
+	// List returns the page of the address entries, the most recent transaction first
+	List(ctx context.Context, address []byte, page Page) ([]*AddressTx, error)
+}
+
+// TxLocationStorer maps every transaction of the main chain to the block that confirms it
+type TxLocationStorer interface {
+	Put(ctx context.Context, loc *TxLocation) error
+	// Get returns nil if the transaction is not in the main chain
+	Get(ctx context.Context, txHash string) (*TxLocation, error)
+	Delete(ctx context.Context, txHash string) error
+}
+
+// MetaStorer keeps the single meta record of the store
+type MetaStorer interface {
+	// Get returns nil if the meta record was never put
+	Get(ctx context.Context) (*Meta, error)
+	Put(ctx context.Context, meta *Meta) error
 }
@@ -2,15 +2,20 @@ package store
 
 import (
 	"context"
+	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yuriykis/microblocknet/common/proto"
 	"github.com/yuriykis/microblocknet/node/secure"
+	bolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	pb "google.golang.org/protobuf/proto"
 )
 
 const (
@@ -21,6 +26,8 @@ const (
 type MemoryTxStore struct {
 	lock sync.RWMutex
 	txs  map[string]*proto.Transaction
+	// keys are the transaction hashes in order, for the pages of Iterate
+	keys sortedKeys
 }
 
 func NewMemoryTxStore() *MemoryTxStore {
@@ -35,6 +42,7 @@ func (m *MemoryTxStore) Put(ctx context.Context, tx *proto.Transaction) error {
 
 	hashTx := secure.HashTransaction(tx)
 	m.txs[hashTx] = tx
+	m.keys.add(hashTx)
 	return nil
 }
 
@@ -58,75 +66,375 @@ func (m *MemoryTxStore) List(ctx context.Context) []*proto.Transaction {
 	return txs
 }
 
+// Iterate calls fn for the transactions of the page ordered by their hash
+func (m *MemoryTxStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Transaction) error) (string, error) {
+	m.lock.RLock()
+	keys, next := m.keys.page(cursor)
+	txs := make([]*proto.Transaction, 0, len(keys))
+	for _, key := range keys {
+		txs = append(txs, m.txs[key])
+	}
+	m.lock.RUnlock()
+
+	for _, tx := range txs {
+		if err := fn(tx); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (m *MemoryTxStore) Count(ctx context.Context) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.txs), nil
+}
+
 // -----------------------------------------------------------------------------
 
+// mongoTxDoc keeps the protobuf encoded transaction by its hash
+type mongoTxDoc struct {
+	Hash string `bson:"hash"`
+	Data []byte `bson:"data"`
+}
+
 // MongoTxStore
 type MongoTxStore struct {
-	client *mongo.Client
-	coll   *mongo.Collection
+	coll *mongo.Collection
 }
 
-func NewMongoTxStore(client *mongo.Client) *MongoTxStore {
+func NewMongoTxStore(db *mongo.Database) *MongoTxStore {
 	return &MongoTxStore{
-		client: client,
-		coll:   client.Database(mongoDBName).Collection(txColl),
+		coll: db.Collection(txColl),
 	}
 }
 
-// Put inserts transaction into the store, implements TxStorer interface
-func (m *MongoTxStore) Put(ctx context.Context, tx *proto.Transaction) error {
-	txHash := secure.HashTransaction(tx)
-	res, err := m.coll.InsertOne(ctx, bson.M{
-		"txHash": hex.EncodeToString([]byte(txHash)),
-		"tx":     tx,
+func (m *MongoTxStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
 	})
+	return err
+}
+
+func (m *MongoTxStore) Put(ctx context.Context, tx *proto.Transaction) error {
+	data, err := pb.Marshal(tx)
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("inserted transaction %s", res.InsertedID)
-	return nil
+	hash := hex.EncodeToString([]byte(secure.HashTransaction(tx)))
+	_, err = m.coll.ReplaceOne(
+		ctx,
+		bson.M{"hash": hash},
+		mongoTxDoc{
+			Hash: hash,
+			Data: data,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
 }
 
-// Get retrieves transaction from the store, implements TxStorer interface
 func (m *MongoTxStore) Get(ctx context.Context, txHash string) (*proto.Transaction, error) {
-	var txDoc struct {
-		TxHash string            `bson:"txHash"`
-		Tx     proto.Transaction `bson:"tx"`
+	var doc mongoTxDoc
+	err := m.coll.FindOne(ctx, bson.M{
+		"hash": hex.EncodeToString([]byte(txHash)),
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("transaction with id %s not found", txHash)
+	}
+	if err != nil {
+		return nil, err
 	}
-	if err := m.coll.FindOne(ctx, bson.M{
-		"txHash": hex.EncodeToString([]byte(txHash)),
-	}).Decode(&txDoc); err != nil {
+	tx := &proto.Transaction{}
+	if err := pb.Unmarshal(doc.Data, tx); err != nil {
 		return nil, err
 	}
-	return &txDoc.Tx, nil
+	return tx, nil
 }
 
-// List retrieves all transactions from the store, implements TxStorer interface
 func (m *MongoTxStore) List(ctx context.Context) []*proto.Transaction {
-	txsDocs := make(
-		[]struct {
-			TxHash string            `bson:"txHash"`
-			Tx     proto.Transaction `bson:"tx"`
-		}, 0)
+	var docs []mongoTxDoc
+	if err := findAll(ctx, m.coll, &docs); err != nil {
+		logrus.Errorf("error listing transactions: %s", err)
+		return nil
+	}
+	txs := make([]*proto.Transaction, 0, len(docs))
+	for _, doc := range docs {
+		tx := &proto.Transaction{}
+		if err := pb.Unmarshal(doc.Data, tx); err != nil {
+			logrus.Errorf("error decoding transaction %s: %s", doc.Hash, err)
+			return nil
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// Iterate calls fn for the transactions of the page ordered by their hash
+func (m *MongoTxStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Transaction) error) (string, error) {
+	return iterateMongo(ctx, m.coll, "hash", cursor, func(cur *mongo.Cursor) error {
+		var doc mongoTxDoc
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		tx := &proto.Transaction{}
+		if err := pb.Unmarshal(doc.Data, tx); err != nil {
+			return fmt.Errorf("error decoding transaction %s: %w", doc.Hash, err)
+		}
+		return fn(tx)
+	})
+}
 
-	cur, err := m.coll.Find(ctx, nil)
+func (m *MongoTxStore) Count(ctx context.Context) (int, error) {
+	n, err := m.coll.CountDocuments(ctx, bson.D{})
+	return int(n), err
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltTxStore keeps the protobuf encoded transactions by their hash
+type BoltTxStore struct {
+	db *bolt.DB
+}
+
+func NewBoltTxStore(db *bolt.DB) *BoltTxStore {
+	return &BoltTxStore{
+		db: db,
+	}
+}
+
+func (b *BoltTxStore) Put(ctx context.Context, t *proto.Transaction) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltTx(tx, t)
+	})
+}
+
+func putBoltTx(tx *bolt.Tx, t *proto.Transaction) error {
+	data, err := pb.Marshal(t)
 	if err != nil {
-		return nil
+		return err
 	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		var txDoc struct {
-			TxHash string            `bson:"txHash"`
-			Tx     proto.Transaction `bson:"tx"`
+	return putBoltCounted(tx.Bucket(txsBucket), []byte(secure.HashTransaction(t)), data)
+}
+
+func (b *BoltTxStore) Get(ctx context.Context, txHash string) (*proto.Transaction, error) {
+	t := &proto.Transaction{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(txsBucket).Get([]byte(txHash))
+		if data == nil {
+			return fmt.Errorf("transaction with id %s not found", txHash)
 		}
-		if err := cur.Decode(&txDoc); err != nil {
+		return pb.Unmarshal(data, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (b *BoltTxStore) List(ctx context.Context) []*proto.Transaction {
+	txs := make([]*proto.Transaction, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(txsBucket).ForEach(func(k, v []byte) error {
+			t := &proto.Transaction{}
+			if err := pb.Unmarshal(v, t); err != nil {
+				return err
+			}
+			txs = append(txs, t)
 			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("error listing transactions: %s", err)
+		return nil
+	}
+	return txs
+}
+
+// Iterate calls fn for the transactions of the page ordered by their hash
+func (b *BoltTxStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Transaction) error) (string, error) {
+	return iterateBolt(b.db, txsBucket, cursor, func(v []byte) error {
+		t := &proto.Transaction{}
+		if err := pb.Unmarshal(v, t); err != nil {
+			return err
 		}
-		txsDocs = append(txsDocs, txDoc)
+		return fn(t)
+	})
+}
+
+func (b *BoltTxStore) Count(ctx context.Context) (int, error) {
+	return countBolt(b.db, txsBucket)
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteTxStore keeps the transactions in the transactions table,
+// their inputs and outputs in the tx_inputs and tx_outputs tables
+type SQLiteTxStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteTxStore(conn sqlConn) *SQLiteTxStore {
+	return &SQLiteTxStore{
+		conn: conn,
+	}
+}
+
+// Put inserts the transaction, a transaction that is already stored is left as it is
+func (s *SQLiteTxStore) Put(ctx context.Context, tx *proto.Transaction) error {
+	return withSQLTx(ctx, s.conn, func(conn sqlConn) error {
+		return putSQLiteTx(ctx, conn, tx)
+	})
+}
+
+func (s *SQLiteTxStore) Get(ctx context.Context, txHash string) (*proto.Transaction, error) {
+	var exists int
+	err := s.conn.QueryRowContext(ctx, `SELECT 1 FROM transactions WHERE hash = ?`, []byte(txHash)).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("transaction with id %s not found", txHash)
+	}
+	if err != nil {
+		return nil, err
 	}
-	txs := make([]*proto.Transaction, len(txsDocs))
-	for i, txDoc := range txsDocs {
-		txs[i] = &txDoc.Tx
+	return getSQLiteTx(ctx, s.conn, []byte(txHash))
+}
+
+func (s *SQLiteTxStore) List(ctx context.Context) []*proto.Transaction {
+	hashes, err := queryHashes(ctx, s.conn, `SELECT hash FROM transactions`)
+	if err != nil {
+		logrus.Errorf("error listing transactions: %s", err)
+		return nil
+	}
+	txs := make([]*proto.Transaction, 0, len(hashes))
+	for _, hash := range hashes {
+		tx, err := getSQLiteTx(ctx, s.conn, hash)
+		if err != nil {
+			logrus.Errorf("error listing transactions: %s", err)
+			return nil
+		}
+		txs = append(txs, tx)
 	}
 	return txs
 }
+
+// Iterate calls fn for the transactions of the page ordered by their hash, the page
+// is read before fn is called
+func (s *SQLiteTxStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.Transaction) error) (string, error) {
+	hashes, next, err := querySQLiteHashPage(ctx, s.conn, "transactions", cursor)
+	if err != nil {
+		return "", err
+	}
+	for _, hash := range hashes {
+		tx, err := getSQLiteTx(ctx, s.conn, hash)
+		if err != nil {
+			return "", err
+		}
+		if err := fn(tx); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (s *SQLiteTxStore) Count(ctx context.Context) (int, error) {
+	return countSQLite(ctx, s.conn, "transactions")
+}
+
+// putSQLiteTx inserts the transaction with its inputs and outputs,
+// the hash covers the whole transaction, so a stored one never changes
+func putSQLiteTx(ctx context.Context, conn sqlConn, tx *proto.Transaction) error {
+	hash := []byte(secure.HashTransaction(tx))
+	res, err := conn.ExecContext(ctx, `INSERT INTO transactions (hash) VALUES (?) ON CONFLICT (hash) DO NOTHING`, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	for i, input := range tx.Inputs {
+		_, err := conn.ExecContext(
+			ctx,
+			`INSERT INTO tx_inputs (tx_hash, position, prev_tx_hash, out_index, public_key, signature)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			hash, i, input.PrevTxHash, input.OutIndex, input.PublicKey, input.Signature,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for i, output := range tx.Outputs {
+		_, err := conn.ExecContext(
+			ctx,
+			`INSERT INTO tx_outputs (tx_hash, position, value, address) VALUES (?, ?, ?, ?)`,
+			hash, i, output.Value, output.Address,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSQLiteTx assembles the stored transaction from its inputs and outputs
+func getSQLiteTx(ctx context.Context, conn sqlConn, hash []byte) (*proto.Transaction, error) {
+	tx := &proto.Transaction{
+		Inputs:  []*proto.TxInput{},
+		Outputs: []*proto.TxOutput{},
+	}
+	rows, err := conn.QueryContext(
+		ctx,
+		`SELECT prev_tx_hash, out_index, public_key, signature FROM tx_inputs
+		WHERE tx_hash = ? ORDER BY position`,
+		hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		input := &proto.TxInput{}
+		if err := rows.Scan(&input.PrevTxHash, &input.OutIndex, &input.PublicKey, &input.Signature); err != nil {
+			return nil, err
+		}
+		tx.Inputs = append(tx.Inputs, input)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = conn.QueryContext(
+		ctx,
+		`SELECT value, address FROM tx_outputs WHERE tx_hash = ? ORDER BY position`,
+		hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		output := &proto.TxOutput{}
+		if err := rows.Scan(&output.Value, &output.Address); err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, output)
+	}
+	return tx, rows.Err()
+}
+
+// queryHashes returns the first column of the rows selected by the query
+func queryHashes(ctx context.Context, conn sqlConn, query string, args ...any) ([][]byte, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make([][]byte, 0)
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
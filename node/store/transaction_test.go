@@ -2,11 +2,13 @@ package store
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/yuriykis/microblocknet/node/secure"
 	"github.com/yuriykis/microblocknet/node/util"
+	pb "google.golang.org/protobuf/proto"
 )
 
 func TestPutTransaction(t *testing.T) {
@@ -38,3 +40,44 @@ func TestListTransactions(t *testing.T) {
 	assert.Contains(t, txs, firstTx)
 	assert.Contains(t, txs, secondTx)
 }
+
+func TestBoltTxStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	txStore := s.TxStore(ctx)
+
+	putTx := util.RandomTransaction()
+	assert.Nil(t, txStore.Put(ctx, putTx))
+	getTx, err := txStore.Get(ctx, secure.HashTransaction(putTx))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(putTx, getTx))
+
+	_, err = txStore.Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, txStore.Put(ctx, util.RandomTransaction()))
+	assert.Equal(t, 2, len(txStore.List(ctx)))
+}
+
+func TestSQLiteTxStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	txStore := s.TxStore(ctx)
+
+	putTx := util.RandomTransaction()
+	assert.Nil(t, txStore.Put(ctx, putTx))
+	assert.Nil(t, txStore.Put(ctx, putTx))
+	getTx, err := txStore.Get(ctx, secure.HashTransaction(putTx))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(putTx, getTx))
+
+	_, err = txStore.Get(ctx, string(util.RandomHash()))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, txStore.Put(ctx, util.RandomTransaction()))
+	assert.Equal(t, 2, len(txStore.List(ctx)))
+}
@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yuriykis/microblocknet/common/proto"
 	"github.com/yuriykis/microblocknet/node/secure"
+	bolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	pb "google.golang.org/protobuf/proto"
 )
 
 const (
@@ -20,6 +25,8 @@ const (
 type MemoryUTXOStore struct {
 	lock  sync.RWMutex
 	utxos map[string]*proto.UTXO
+	// keys are the UTXO keys in order, for the pages of Iterate
+	keys sortedKeys
 }
 
 func NewMemoryUTXOStore() *MemoryUTXOStore {
@@ -35,6 +42,7 @@ func (m *MemoryUTXOStore) Put(ctx context.Context, utxo *proto.UTXO) error {
 	key := secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))
 
 	m.utxos[key] = utxo
+	m.keys.add(key)
 
 	return nil
 }
@@ -50,6 +58,15 @@ func (m *MemoryUTXOStore) Get(ctx context.Context, key string) (*proto.UTXO, err
 	return utxo, nil
 }
 
+func (m *MemoryUTXOStore) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.utxos, key)
+	m.keys.remove(key)
+	return nil
+}
+
 func (m *MemoryUTXOStore) List(ctx context.Context) []*proto.UTXO {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -70,7 +87,7 @@ func (m *MemoryUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*
 	utxos := make([]*proto.UTXO, 0)
 
 	for _, utxo := range m.utxos {
-		if bytes.Equal(utxo.Output.Address, address) && !utxo.Spent {
+		if bytes.Equal(utxo.Output.Address, address) {
 			utxos = append(utxos, utxo)
 		}
 	}
@@ -78,114 +95,408 @@ func (m *MemoryUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*
 	return utxos, nil
 }
 
+func (m *MemoryUTXOStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.UTXO) error) (string, error) {
+	m.lock.RLock()
+	keys, next := m.keys.page(cursor)
+	utxos := make([]*proto.UTXO, 0, len(keys))
+	for _, key := range keys {
+		utxos = append(utxos, m.utxos[key])
+	}
+	m.lock.RUnlock()
+
+	for _, utxo := range utxos {
+		if err := fn(utxo); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (m *MemoryUTXOStore) Count(ctx context.Context) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.utxos), nil
+}
+
 // -----------------------------------------------------------------------------
 
+// mongoUTXODoc keeps the protobuf encoded UTXO, the address is stored
+// next to it, so the UTXOs of an address can be queried by index
+type mongoUTXODoc struct {
+	Key     string `bson:"key"`
+	Address []byte `bson:"address"`
+	Data    []byte `bson:"data"`
+}
+
 type MongoUTXOStore struct {
-	client *mongo.Client
-	coll   *mongo.Collection
+	coll *mongo.Collection
 }
 
-func NewMongoUTXOStore(client *mongo.Client) *MongoUTXOStore {
+func NewMongoUTXOStore(db *mongo.Database) *MongoUTXOStore {
 	return &MongoUTXOStore{
-		client: client,
-		coll:   client.Database(mongoDBName).Collection(utxoColl),
+		coll: db.Collection(utxoColl),
 	}
 }
 
-// Put inserts a new UTXO into the store, implements UTXOStorer interface
-func (m *MongoUTXOStore) Put(ctx context.Context, utxo *proto.UTXO) error {
-	key := secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))
-	res, err := m.coll.InsertOne(ctx, bson.M{
-		"key":  hex.EncodeToString([]byte(key)),
-		"utxo": utxo,
+func (m *MongoUTXOStore) ensureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "address", Value: 1}},
+		},
 	})
+	return err
+}
+
+func (m *MongoUTXOStore) Put(ctx context.Context, utxo *proto.UTXO) error {
+	data, err := pb.Marshal(utxo)
 	if err != nil {
 		return err
 	}
-	logrus.Infof("inserted utxo %s", res.InsertedID)
-	return nil
+	key := hex.EncodeToString([]byte(secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))))
+	_, err = m.coll.ReplaceOne(
+		ctx,
+		bson.M{"key": key},
+		mongoUTXODoc{
+			Key:     key,
+			Address: utxo.GetOutput().GetAddress(),
+			Data:    data,
+		},
+		options.Replace().SetUpsert(true),
+	)
+	return err
 }
 
-// Get retrieves a UTXO from the store, implements UTXOStorer interface
+// Get retrieves the UTXO by its key, it returns nil if there is no such UTXO
 func (m *MongoUTXOStore) Get(ctx context.Context, key string) (*proto.UTXO, error) {
-	var utxoDoc struct {
-		Key  string     `bson:"key"`
-		UTXO proto.UTXO `bson:"utxo"`
-	}
-	if err := m.coll.FindOne(ctx, bson.M{
+	var doc mongoUTXODoc
+	err := m.coll.FindOne(ctx, bson.M{
 		"key": hex.EncodeToString([]byte(key)),
-	}).Decode(&utxoDoc); err != nil {
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
 		return nil, err
 	}
-	return &utxoDoc.UTXO, nil
+	return decodeMongoUTXO(doc)
+}
+
+func (m *MongoUTXOStore) Delete(ctx context.Context, key string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{
+		"key": hex.EncodeToString([]byte(key)),
+	})
+	return err
 }
 
-// List retrieves all UTXOs from the store, implements UTXOStorer interface
 func (m *MongoUTXOStore) List(ctx context.Context) []*proto.UTXO {
-	utxosDocs := make(
-		[]struct {
-			Key  string     `bson:"key"`
-			UTXO proto.UTXO `bson:"utxo"`
-		}, 0)
-	cur, err := m.coll.Find(ctx, nil)
+	utxos, err := m.find(ctx, bson.D{})
 	if err != nil {
+		logrus.Errorf("error listing utxos: %s", err)
 		return nil
 	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		var utxoDoc struct {
-			Key  string     `bson:"key"`
-			UTXO proto.UTXO `bson:"utxo"`
+	return utxos
+}
+
+func (m *MongoUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error) {
+	return m.find(ctx, bson.M{
+		"address": address,
+	})
+}
+
+func (m *MongoUTXOStore) find(ctx context.Context, filter any) ([]*proto.UTXO, error) {
+	cur, err := m.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoUTXODoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	utxos := make([]*proto.UTXO, 0, len(docs))
+	for _, doc := range docs {
+		utxo, err := decodeMongoUTXO(doc)
+		if err != nil {
+			return nil, err
 		}
-		if err := cur.Decode(&utxoDoc); err != nil {
-			logrus.Errorf("error decoding utxo: %s", err)
-			continue
+		utxos = append(utxos, utxo)
+	}
+	return utxos, nil
+}
+
+// Iterate calls fn for the UTXOs of the page ordered by their key
+func (m *MongoUTXOStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.UTXO) error) (string, error) {
+	return iterateMongo(ctx, m.coll, "key", cursor, func(cur *mongo.Cursor) error {
+		var doc mongoUTXODoc
+		if err := cur.Decode(&doc); err != nil {
+			return err
 		}
-		utxosDocs = append(utxosDocs, utxoDoc)
+		utxo, err := decodeMongoUTXO(doc)
+		if err != nil {
+			return err
+		}
+		return fn(utxo)
+	})
+}
+
+func (m *MongoUTXOStore) Count(ctx context.Context) (int, error) {
+	n, err := m.coll.CountDocuments(ctx, bson.D{})
+	return int(n), err
+}
+
+func decodeMongoUTXO(doc mongoUTXODoc) (*proto.UTXO, error) {
+	utxo := &proto.UTXO{}
+	if err := pb.Unmarshal(doc.Data, utxo); err != nil {
+		return nil, fmt.Errorf("error decoding utxo %s: %w", doc.Key, err)
 	}
-	if err := cur.Err(); err != nil {
-		logrus.Errorf("error iterating utxos: %s", err)
-		return nil
+	return utxo, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// BoltUTXOStore keeps the protobuf encoded UTXOs by their key
+type BoltUTXOStore struct {
+	db *bolt.DB
+}
+
+func NewBoltUTXOStore(db *bolt.DB) *BoltUTXOStore {
+	return &BoltUTXOStore{
+		db: db,
+	}
+}
+
+func (b *BoltUTXOStore) Put(ctx context.Context, utxo *proto.UTXO) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putBoltUTXO(tx, utxo)
+	})
+}
+
+func putBoltUTXO(tx *bolt.Tx, utxo *proto.UTXO) error {
+	data, err := pb.Marshal(utxo)
+	if err != nil {
+		return err
 	}
+	key := secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))
+	return putBoltCounted(tx.Bucket(utxosBucket), []byte(key), data)
+}
+
+// Get retrieves the UTXO by its key, it returns nil if there is no such UTXO
+func (b *BoltUTXOStore) Get(ctx context.Context, key string) (*proto.UTXO, error) {
+	var utxo *proto.UTXO
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(utxosBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		utxo = &proto.UTXO{}
+		return pb.Unmarshal(data, utxo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxo, nil
+}
+
+func (b *BoltUTXOStore) Delete(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltCounted(tx.Bucket(utxosBucket), []byte(key))
+	})
+}
+
+func (b *BoltUTXOStore) List(ctx context.Context) []*proto.UTXO {
 	utxos := make([]*proto.UTXO, 0)
-	for _, utxoDoc := range utxosDocs {
-		utxos = append(utxos, &utxoDoc.UTXO)
+	err := b.forEach(func(utxo *proto.UTXO) {
+		utxos = append(utxos, utxo)
+	})
+	if err != nil {
+		logrus.Errorf("error listing utxos: %s", err)
+		return nil
 	}
 	return utxos
 }
 
-// GetByAddress retrieves all UTXOs for a given address from the store, implements UTXOStorer interface
-func (m *MongoUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error) {
-	utxosDocs := make(
-		[]struct {
-			Key  string     `bson:"key"`
-			UTXO proto.UTXO `bson:"utxo"`
-		}, 0)
-	cur, err := m.coll.Find(ctx, bson.M{
-		"utxo.output.address": address,
+func (b *BoltUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error) {
+	utxos := make([]*proto.UTXO, 0)
+	err := b.forEach(func(utxo *proto.UTXO) {
+		if bytes.Equal(utxo.Output.Address, address) {
+			utxos = append(utxos, utxo)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// Iterate calls fn for the UTXOs of the page ordered by their key
+func (b *BoltUTXOStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.UTXO) error) (string, error) {
+	return iterateBolt(b.db, utxosBucket, cursor, func(v []byte) error {
+		utxo := &proto.UTXO{}
+		if err := pb.Unmarshal(v, utxo); err != nil {
+			return err
+		}
+		return fn(utxo)
 	})
+}
+
+func (b *BoltUTXOStore) Count(ctx context.Context) (int, error) {
+	return countBolt(b.db, utxosBucket)
+}
+
+func (b *BoltUTXOStore) forEach(fn func(utxo *proto.UTXO)) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(utxosBucket).ForEach(func(k, v []byte) error {
+			utxo := &proto.UTXO{}
+			if err := pb.Unmarshal(v, utxo); err != nil {
+				return err
+			}
+			fn(utxo)
+			return nil
+		})
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// SQLiteUTXOStore keeps the UTXOs in the utxos table
+type SQLiteUTXOStore struct {
+	conn sqlConn
+}
+
+func NewSQLiteUTXOStore(conn sqlConn) *SQLiteUTXOStore {
+	return &SQLiteUTXOStore{
+		conn: conn,
+	}
+}
+
+func (s *SQLiteUTXOStore) Put(ctx context.Context, utxo *proto.UTXO) error {
+	_, err := s.conn.ExecContext(
+		ctx,
+		`INSERT INTO utxos (tx_hash, out_index, value, address, spent) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (tx_hash, out_index) DO UPDATE SET
+			value = excluded.value,
+			address = excluded.address,
+			spent = excluded.spent`,
+		utxo.TxHash,
+		utxo.OutIndex,
+		utxo.GetOutput().GetValue(),
+		utxo.GetOutput().GetAddress(),
+		utxo.Spent,
+	)
+	return err
+}
+
+// Get retrieves the UTXO by its key, it returns nil if there is no such UTXO
+func (s *SQLiteUTXOStore) Get(ctx context.Context, key string) (*proto.UTXO, error) {
+	txHash, outIndex, err := secure.ParseUTXOKey(key)
 	if err != nil {
 		return nil, err
 	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		var utxoDoc struct {
-			Key  string     `bson:"key"`
-			UTXO proto.UTXO `bson:"utxo"`
+	utxos, err := s.query(
+		ctx,
+		`SELECT tx_hash, out_index, value, address, spent FROM utxos WHERE tx_hash = ? AND out_index = ?`,
+		txHash,
+		outIndex,
+	)
+	if err != nil || len(utxos) == 0 {
+		return nil, err
+	}
+	return utxos[0], nil
+}
+
+func (s *SQLiteUTXOStore) Delete(ctx context.Context, key string) error {
+	txHash, outIndex, err := secure.ParseUTXOKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.ExecContext(ctx, `DELETE FROM utxos WHERE tx_hash = ? AND out_index = ?`, txHash, outIndex)
+	return err
+}
+
+func (s *SQLiteUTXOStore) List(ctx context.Context) []*proto.UTXO {
+	utxos, err := s.query(ctx, `SELECT tx_hash, out_index, value, address, spent FROM utxos`)
+	if err != nil {
+		logrus.Errorf("error listing utxos: %s", err)
+		return nil
+	}
+	return utxos
+}
+
+func (s *SQLiteUTXOStore) GetByAddress(ctx context.Context, address []byte) ([]*proto.UTXO, error) {
+	return s.query(
+		ctx,
+		`SELECT tx_hash, out_index, value, address, spent FROM utxos WHERE address = ?`,
+		address,
+	)
+}
+
+// Iterate calls fn for the UTXOs of the page ordered by their transaction hash and output
+// index, the page is read before fn is called
+func (s *SQLiteUTXOStore) Iterate(ctx context.Context, cursor Cursor, fn func(*proto.UTXO) error) (string, error) {
+	var (
+		utxos []*proto.UTXO
+		err   error
+	)
+	if cursor.After == "" {
+		utxos, err = s.query(
+			ctx,
+			`SELECT tx_hash, out_index, value, address, spent FROM utxos
+			ORDER BY tx_hash, out_index LIMIT ?`,
+			sqlitePageLimit(cursor),
+		)
+	} else {
+		txHash, outIndex, perr := secure.ParseUTXOKey(cursor.After)
+		if perr != nil {
+			return "", perr
 		}
-		if err := cur.Decode(&utxoDoc); err != nil {
-			logrus.Errorf("error decoding utxo: %s", err)
-			continue
+		utxos, err = s.query(
+			ctx,
+			`SELECT tx_hash, out_index, value, address, spent FROM utxos
+			WHERE tx_hash > ? OR (tx_hash = ? AND out_index > ?)
+			ORDER BY tx_hash, out_index LIMIT ?`,
+			txHash, txHash, outIndex, sqlitePageLimit(cursor),
+		)
+	}
+	if err != nil {
+		return "", err
+	}
+	next := ""
+	if cursor.Limit > 0 && len(utxos) > cursor.Limit {
+		utxos = utxos[:cursor.Limit]
+		last := utxos[len(utxos)-1]
+		next = secure.MakeUTXOKey(last.TxHash, int(last.OutIndex))
+	}
+	for _, utxo := range utxos {
+		if err := fn(utxo); err != nil {
+			return "", err
 		}
-		utxosDocs = append(utxosDocs, utxoDoc)
 	}
-	if err := cur.Err(); err != nil {
-		logrus.Errorf("error iterating utxos: %s", err)
+	return next, nil
+}
+
+func (s *SQLiteUTXOStore) Count(ctx context.Context) (int, error) {
+	return countSQLite(ctx, s.conn, "utxos")
+}
+
+func (s *SQLiteUTXOStore) query(ctx context.Context, query string, args ...any) ([]*proto.UTXO, error) {
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	utxos := make([]*proto.UTXO, 0)
-	for _, utxoDoc := range utxosDocs {
-		utxos = append(utxos, &utxoDoc.UTXO)
+	for rows.Next() {
+		utxo := &proto.UTXO{
+			Output: &proto.TxOutput{},
+		}
+		if err := rows.Scan(&utxo.TxHash, &utxo.OutIndex, &utxo.Output.Value, &utxo.Output.Address, &utxo.Spent); err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, utxo)
 	}
-	return utxos, nil
+	return utxos, rows.Err()
 }
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,6 +10,7 @@ import (
 	"github.com/yuriykis/microblocknet/common/proto"
 	"github.com/yuriykis/microblocknet/node/secure"
 	"github.com/yuriykis/microblocknet/node/util"
+	pb "google.golang.org/protobuf/proto"
 )
 
 func TestPutUTXO(t *testing.T) {
@@ -73,3 +75,75 @@ func TestGetUTXOByAddress(t *testing.T) {
 	assert.Equal(t, utxo, utxos[0])
 
 }
+
+func TestBoltUTXOStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainBoltStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	utxoStore := s.UTXOStore(ctx)
+
+	// a missing UTXO is not an error
+	missing, err := utxoStore.Get(ctx, secure.MakeUTXOKey(util.RandomHash(), 0))
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+
+	address := crypto.GeneratePrivateKey().PublicKey().Address().Bytes()
+	unspent := util.RandomUTXO()
+	unspent.Output.Address = address
+	spent := util.RandomUTXO()
+	spent.Output.Address = address
+	for _, utxo := range []*proto.UTXO{unspent, spent, util.RandomUTXO()} {
+		assert.Nil(t, utxoStore.Put(ctx, utxo))
+	}
+	assert.Equal(t, 3, len(utxoStore.List(ctx)))
+
+	getUTXO, err := utxoStore.Get(ctx, secure.MakeUTXOKey(spent.TxHash, int(spent.OutIndex)))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(spent, getUTXO))
+
+	// the spent output leaves the set
+	assert.Nil(t, utxoStore.Delete(ctx, secure.MakeUTXOKey(spent.TxHash, int(spent.OutIndex))))
+	utxos, err := utxoStore.GetByAddress(ctx, address)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(utxos))
+	assert.True(t, pb.Equal(unspent, utxos[0]))
+}
+
+func TestSQLiteUTXOStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewChainSQLiteStore(filepath.Join(t.TempDir(), "chain.db"))
+	assert.Nil(t, err)
+	defer s.Close()
+	utxoStore := s.UTXOStore(ctx)
+
+	missing, err := utxoStore.Get(ctx, secure.MakeUTXOKey(util.RandomHash(), 0))
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+
+	address := crypto.GeneratePrivateKey().PublicKey().Address().Bytes()
+	utxo := util.RandomUTXO()
+	utxo.Output.Address = address
+	assert.Nil(t, utxoStore.Put(ctx, utxo))
+	assert.Nil(t, utxoStore.Put(ctx, util.RandomUTXO()))
+
+	utxos, err := utxoStore.GetByAddress(ctx, address)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(utxos))
+	assert.True(t, pb.Equal(utxo, utxos[0]))
+
+	// putting the output again updates the existing row
+	changed := pb.Clone(utxo).(*proto.UTXO)
+	changed.Output.Value++
+	assert.Nil(t, utxoStore.Put(ctx, changed))
+	assert.Equal(t, 2, len(utxoStore.List(ctx)))
+	getUTXO, err := utxoStore.Get(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex)))
+	assert.Nil(t, err)
+	assert.True(t, pb.Equal(changed, getUTXO))
+
+	// the spent output leaves the set
+	assert.Nil(t, utxoStore.Delete(ctx, secure.MakeUTXOKey(utxo.TxHash, int(utxo.OutIndex))))
+	utxos, err = utxoStore.GetByAddress(ctx, address)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(utxos))
+}
@@ -20,6 +20,7 @@ func RandomBlock() *proto.Block {
 		Height:        int32(rand.Intn(1000)),
 		PrevBlockHash: RandomHash(),
 		MerkleRoot:    RandomHash(),
+		UtxoRoot:      RandomHash(),
 		Timestamp:     time.Now().UnixNano(),
 	}
 	return &proto.Block{